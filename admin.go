@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+)
+
+// serveAdmin serves the metrics, status and debug endpoints on the admin address,
+// the pause endpoints are only served with an admin token
+func serveAdmin(address string, enablePprof bool, m *metrics, st *status, p *pauser) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	mux.Handle("/status", st)
+	if p.token != "" {
+		mux.HandleFunc("/admin/pause", p.handler(true))
+		mux.HandleFunc("/admin/resume", p.handler(false))
+	}
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	log.Printf("Serving admin endpoints on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Printf("Admin server stopped: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/donchev7/fluentd-reloader/pkg/reloader"
+)
+
+// expiryAlert is the JSON body posted to the expiry webhook
+type expiryAlert struct {
+	Cluster          string    `json:"cluster,omitempty"`
+	Target           string    `json:"target,omitempty"`
+	ServedNotAfter   time.Time `json:"servedNotAfter"`
+	ExpectedNotAfter time.Time `json:"expectedNotAfter"`
+	Message          string    `json:"message"`
+}
+
+// expiryAlerter posts an alert to a webhook for every target whose certificate
+// expires without a renewal, repeating it at most once per reminder interval
+type expiryAlerter struct {
+	url      string
+	reminder time.Duration
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+func (a *expiryAlerter) notify(r reloader.Report) {
+	for _, s := range r.Targets {
+		key := s.Cluster + "/" + s.Target
+		if !s.ExpiryWarning {
+			a.mu.Lock()
+			delete(a.sent, key)
+			a.mu.Unlock()
+			continue
+		}
+
+		a.mu.Lock()
+		if a.sent == nil {
+			a.sent = map[string]time.Time{}
+		}
+		last, ok := a.sent[key]
+		due := !ok || time.Since(last) >= a.reminder
+		if due {
+			a.sent[key] = time.Now()
+		}
+		a.mu.Unlock()
+		if !due {
+			continue
+		}
+
+		alert := expiryAlert{
+			Cluster:          s.Cluster,
+			Target:           s.Target,
+			ServedNotAfter:   s.ServedNotAfter,
+			ExpectedNotAfter: s.ExpectedNotAfter,
+			Message:          fmt.Sprintf("served certificate expires on %v and cert-manager has not renewed it", s.ServedNotAfter),
+		}
+		if err := postWebhook(a.url, "expiry alert", alert); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// postWebhook posts v as JSON to url, kind names it in errors
+func postWebhook(url, kind string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", kind, err)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post %s: %w", kind, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to post %s: %s", kind, resp.Status)
+	}
+
+	return nil
+}
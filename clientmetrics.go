@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	kubemetrics "k8s.io/client-go/tools/metrics"
+)
+
+// clientMetrics counts how long the kubernetes clients waited for their rate
+// limiter and how many requests the API server rejected with 429, which its
+// priority and fairness flow control answers when the reloader's priority level is saturated
+type clientMetrics struct {
+	mu sync.Mutex
+	// waitSeconds is the total time requests waited for the client rate limiter
+	waitSeconds float64
+	waits       int
+	// throttled counts the requests the API server answered with 429
+	throttled int
+}
+
+var apiClientMetrics = &clientMetrics{}
+
+func init() {
+	kubemetrics.Register(kubemetrics.RegisterOpts{
+		RateLimiterLatency: rateLimiterLatency{apiClientMetrics},
+		RequestResult:      requestResult{apiClientMetrics},
+	})
+}
+
+type rateLimiterLatency struct{ m *clientMetrics }
+
+func (l rateLimiterLatency) Observe(_ context.Context, _ string, _ url.URL, latency time.Duration) {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	l.m.waitSeconds += latency.Seconds()
+	l.m.waits++
+}
+
+type requestResult struct{ m *clientMetrics }
+
+func (r requestResult) Increment(_ context.Context, code, _, _ string) {
+	if code != "429" {
+		return
+	}
+
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.throttled++
+}
+
+// write writes the client throttling counters in the Prometheus text or the OpenMetrics format
+func (m *clientMetrics) write(w io.Writer, openMetrics bool) {
+	m.mu.Lock()
+	waitSeconds, waits, throttled := m.waitSeconds, m.waits, m.throttled
+	m.mu.Unlock()
+
+	writeCounterHeader(w, "kube_client_rate_limiter_wait_seconds_total", "Time kubernetes API requests waited for the client-side rate limiter.", openMetrics)
+	fmt.Fprintf(w, "kube_client_rate_limiter_wait_seconds_total %s\n", strconv.FormatFloat(waitSeconds, 'f', -1, 64))
+
+	writeCounterHeader(w, "kube_client_rate_limiter_waits_total", "Number of kubernetes API requests that passed the client-side rate limiter.", openMetrics)
+	fmt.Fprintf(w, "kube_client_rate_limiter_waits_total %d\n", waits)
+
+	writeCounterHeader(w, "kube_client_throttled_requests_total", "Number of kubernetes API requests the API server rejected with 429.", openMetrics)
+	fmt.Fprintf(w, "kube_client_throttled_requests_total %d\n", throttled)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/donchev7/fluentd-reloader/pkg/reloader"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// inClusterContext selects the in-cluster config in KUBE_CONTEXTS
+const inClusterContext = "in-cluster"
+
+// clientLimits overrides the client-side rate limit of the kubernetes clients,
+// zero keeps the client-go default
+type clientLimits struct {
+	qps   float32
+	burst int
+}
+
+// getClusters returns the reloader config for every cluster to check, without
+// contexts the in-cluster config or the current kubeconfig context is used
+func getClusters(base reloader.Config, contexts []string, limits clientLimits) ([]reloader.Config, error) {
+	if len(contexts) == 0 {
+		// works both locally if you have kubectl correctly configured and in cluster
+		cfg, err := rest.InClusterConfig()
+		if errors.Is(err, rest.ErrNotInCluster) {
+			cfg, err = kubeconfig("")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := clusterConfig(base, cfg, limits)
+		if err != nil {
+			return nil, err
+		}
+
+		return []reloader.Config{c}, nil
+	}
+
+	clusters := make([]reloader.Config, 0, len(contexts))
+	for _, name := range contexts {
+		var cfg *rest.Config
+		var err error
+		if name == inClusterContext {
+			cfg, err = rest.InClusterConfig()
+		} else {
+			cfg, err = kubeconfig(name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config of cluster %s: %w", name, err)
+		}
+
+		c, err := clusterConfig(base, cfg, limits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client of cluster %s: %w", name, err)
+		}
+		c.Cluster = name
+		clusters = append(clusters, c)
+	}
+
+	return clusters, nil
+}
+
+// kubeconfig loads the given context of the default kubeconfig, an empty name is the current context
+func kubeconfig(context string) (*rest.Config, error) {
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{CurrentContext: context}).ClientConfig()
+}
+
+func clusterConfig(base reloader.Config, cfg *rest.Config, limits clientLimits) (reloader.Config, error) {
+	if limits.qps > 0 {
+		cfg.QPS = limits.qps
+	}
+	if limits.burst > 0 {
+		cfg.Burst = limits.burst
+	}
+
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return reloader.Config{}, err
+	}
+
+	base.Client = clientset
+	base.RESTConfig = cfg
+	return base, nil
+}
+
+// runClusters runs fn for every cluster one after another and merges the reports
+func runClusters(ctx context.Context, clusters []reloader.Config, fn func(context.Context, reloader.Config) (reloader.Report, error)) (reloader.Report, error) {
+	report := reloader.Report{}
+	var firstErr error
+	failed := 0
+	// runs of every cluster share the run ID, which prefixes every log line
+	runID := reloader.RunID(ctx)
+	if runID == "" {
+		runID = reloader.NewRunID()
+		ctx = reloader.WithRunID(ctx, runID)
+	}
+	for _, cluster := range clusters {
+		if cluster.Cluster != "" {
+			log.SetPrefix("[" + cluster.Cluster + " " + runID + "] ")
+		} else {
+			log.SetPrefix("[" + runID + "] ")
+		}
+
+		r, err := fn(ctx, cluster)
+		report.Targets = append(report.Targets, r.Targets...)
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	log.SetPrefix("")
+	report.GeneratedAt = time.Now().UTC()
+
+	if firstErr != nil && len(clusters) > 1 {
+		return report, fmt.Errorf("%d of %d clusters failed, first error: %w", failed, len(clusters), firstErr)
+	}
+
+	return report, firstErr
+}
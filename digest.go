@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/donchev7/fluentd-reloader/pkg/reloader"
+)
+
+// Severities of a digest, a digest is sent when its severity is at least the minimum
+const (
+	severityAlways  = "always"
+	severityChange  = "change"
+	severityFailure = "failure"
+)
+
+var severityRank = map[string]int{severityAlways: 0, severityChange: 1, severityFailure: 2}
+
+// digest is the JSON body posted to the digest webhook once per run
+type digest struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	// Severity is change when a target was reloaded and failure when a check or reload failed
+	Severity     string             `json:"severity"`
+	Summary      string             `json:"summary"`
+	PodsReloaded int                `json:"podsReloaded"`
+	PodsFailed   int                `json:"podsFailed"`
+	PodsSkipped  int                `json:"podsSkipped"`
+	Targets      []digestTargetInfo `json:"targets"`
+}
+
+type digestTargetInfo struct {
+	Cluster          string    `json:"cluster,omitempty"`
+	Target           string    `json:"target,omitempty"`
+	Status           string    `json:"status"`
+	ServedNotAfter   time.Time `json:"servedNotAfter"`
+	ExpectedNotAfter time.Time `json:"expectedNotAfter"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// digestNotifier posts a single summary of every report to a webhook instead of
+// a notification per pod
+type digestNotifier struct {
+	url         string
+	minSeverity string
+}
+
+func (d digestNotifier) notify(r reloader.Report) error {
+	digest := newDigest(r)
+	if severityRank[digest.Severity] < severityRank[d.minSeverity] {
+		return nil
+	}
+
+	return postWebhook(d.url, "digest", digest)
+}
+
+func newDigest(r reloader.Report) digest {
+	d := digest{GeneratedAt: r.GeneratedAt, Severity: severityAlways}
+	failedTargets := 0
+	for _, s := range r.Targets {
+		for _, action := range s.Actions {
+			switch action.Outcome {
+			case reloader.OutcomeReloaded:
+				d.PodsReloaded++
+			case reloader.OutcomeFailed:
+				d.PodsFailed++
+			default:
+				d.PodsSkipped++
+			}
+		}
+		if s.Status == reloader.StatusError {
+			failedTargets++
+		}
+
+		d.Targets = append(d.Targets, digestTargetInfo{
+			Cluster:          s.Cluster,
+			Target:           s.Target,
+			Status:           s.Status,
+			ServedNotAfter:   s.ServedNotAfter,
+			ExpectedNotAfter: s.ExpectedNotAfter,
+			Error:            s.Error,
+		})
+	}
+
+	switch {
+	case failedTargets > 0 || d.PodsFailed > 0:
+		d.Severity = severityFailure
+	case d.PodsReloaded > 0:
+		d.Severity = severityChange
+	}
+	d.Summary = fmt.Sprintf("%d pods reloaded, %d failed, %d skipped, %d of %d targets failed",
+		d.PodsReloaded, d.PodsFailed, d.PodsSkipped, failedTargets, len(r.Targets))
+
+	return d
+}
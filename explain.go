@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/donchev7/fluentd-reloader/pkg/reloader"
+)
+
+// skipExplanations describe why pods are not reloaded, by skip reason
+var skipExplanations = map[string]string{
+	"terminating":    "the pod is terminating",
+	"no-ip":          "the pod has no IP yet",
+	"no-rpc-port":    "the pod has no container port named FLUENTD_RPC_PORT_NAME",
+	"skip-label":     "the pod is labelled fluentd-reloader.io/skip=true",
+	"other-shard":    "the pod belongs to the shard of another replica (SHARDS)",
+	"field-selector": "the pod does not match FLUENTD_FIELD_SELECTOR",
+	"other-node":     "the pod runs on another node than FLUENTD_NODE_NAME",
+	"other-zone":     "the pod runs in another zone than FLUENTD_ZONE",
+}
+
+// runExplain checks every target without reloading and narrates how the
+// reloader decided, to debug why fluentd was or was not reloaded
+func runExplain(clusters []reloader.Config) {
+	for i := range clusters {
+		// reloads are paused so the check stops right before it would reload,
+		// and nothing is recorded on the cluster
+		clusters[i].Paused = func() bool { return true }
+		clusters[i].RecordHistory = false
+		clusters[i].SyncCondition = false
+		clusters[i].StatusResource = false
+		clusters[i].ReportHistoryConfigMap = ""
+	}
+
+	report, _ := runClusters(context.Background(), clusters, reloader.Run)
+	explain(os.Stdout, clusters, report)
+}
+
+// explain writes the step by step explanation of every target of the report
+func explain(w io.Writer, clusters []reloader.Config, report reloader.Report) {
+	for i, s := range report.Targets {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		name := s.Target
+		if name == "" {
+			name = "fluentd"
+		}
+		if s.Cluster != "" {
+			name += " in cluster " + s.Cluster
+		}
+		fmt.Fprintf(w, "Target %s (run %s)\n", name, s.RunID)
+
+		step := 0
+		say := func(format string, args ...interface{}) {
+			step++
+			fmt.Fprintf(w, "  %d. %s\n", step, fmt.Sprintf(format, args...))
+		}
+
+		if len(s.DiscoveredPods) > 0 || len(s.SkippedPods) > 0 {
+			say("Found %d fluentd pods to reload: %s", len(s.DiscoveredPods), dash(strings.Join(s.DiscoveredPods, ", ")))
+			reasons := make([]string, 0, len(s.SkippedPods))
+			for reason := range s.SkippedPods {
+				reasons = append(reasons, reason)
+			}
+			sort.Strings(reasons)
+			for _, reason := range reasons {
+				explanation, ok := skipExplanations[reason]
+				if !ok {
+					explanation = reason
+				}
+				fmt.Fprintf(w, "     %d pods were left out because %s\n", s.SkippedPods[reason], explanation)
+			}
+		}
+
+		if s.SecretSync != nil {
+			synced := "has synced"
+			if !s.SecretSync.Synced {
+				synced = "has not synced"
+			}
+			say("%s %s %s the TLS secret: %s", s.SecretSync.Kind, s.SecretSync.Name, synced, dash(s.SecretSync.Message))
+		}
+
+		if !s.ServedNotAfter.IsZero() {
+			say("The fluentd service serves the certificate with serial %s issued by %s, expiring %s",
+				dash(s.ServedSerial), dash(s.ServedIssuer), formatTime(s.ServedNotAfter))
+			for _, e := range s.Endpoints {
+				state := "the expected certificate"
+				if !e.InSync {
+					state = "a stale certificate"
+				}
+				fmt.Fprintf(w, "     %s serves %s expiring %s\n", e.URL, state, formatTime(e.ServedNotAfter))
+			}
+		}
+		if !s.ExpectedNotAfter.IsZero() {
+			say("The certificate source expects a certificate expiring %s", formatTime(s.ExpectedNotAfter))
+		}
+		if s.Revoked {
+			say("The served certificate is revoked")
+		}
+		if s.ExpiryWarning {
+			say("The served certificate expires soon and no renewal was issued")
+		}
+		if len(s.UnpropagatedPods) > 0 {
+			say("These pods do not have the expected certificate mounted yet: %s", strings.Join(s.UnpropagatedPods, ", "))
+		}
+		if len(s.DriftedPods) > 0 {
+			say("These pods run a config differing from the fluentd ConfigMap: %s", strings.Join(s.DriftedPods, ", "))
+		}
+
+		say("Decision: %s", decision(s))
+		if window := clusterWindow(clusters, s.Cluster); window != nil && s.Status == reloader.StatusReloadPaused {
+			if next := window.NextOpen(time.Now()); next.After(time.Now()) {
+				fmt.Fprintf(w, "     the reload waits for the reload window opening %s\n", formatTime(next))
+			}
+		}
+	}
+}
+
+// decision explains the status of the target, reloads are paused by explain
+// so a needed reload shows up as reload-paused
+func decision(s reloader.TargetReport) string {
+	switch s.Status {
+	case reloader.StatusInSync:
+		return "no reload is needed, fluentd serves the expected certificate"
+	case reloader.StatusReloadPaused:
+		if len(s.DriftedPods) > 0 {
+			// drift is only checked once the certificate is in sync
+			return "the pods with a drifted config would be reloaded, the certificate is in sync"
+		}
+		return "fluentd would be reloaded, it serves a stale certificate"
+	case reloader.StatusSecretPropagating:
+		return "no reload yet, kubelet has not updated the secret volume of every pod, a reload would load the old certificate"
+	case reloader.StatusRenewalPending:
+		return "no reload yet, the renewed certificate or a dependent certificate is not issued yet"
+	case reloader.StatusError:
+		hint := "retrying may pass"
+		if s.ErrorClass == reloader.ErrorClassTerminal {
+			hint = "the configuration or the cluster has to change"
+		}
+		return fmt.Sprintf("the check failed, %s: %s", hint, s.Error)
+	}
+
+	return s.Status
+}
+
+func clusterWindow(clusters []reloader.Config, cluster string) *reloader.ReloadWindow {
+	for _, c := range clusters {
+		if c.Cluster == cluster {
+			return c.ReloadWindow
+		}
+	}
+
+	return nil
+}
@@ -2,196 +2,759 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	"github.com/donchev7/fluentd-reloader/pkg/reloader"
+	batchv1 "k8s.io/api/batch/v1"
+	"sigs.k8s.io/yaml"
 )
 
-type app struct {
-	namespace string
-	certName  string
-	client    *kubernetes.Clientset
-}
+// serviceAccountNamespaceFile holds the namespace of the pod's service account
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+var random = rand.New(rand.NewSource(time.Now().UnixNano()))
 
+// exit codes used with --report-change-exit-code
+const (
+	exitInSync   = 0
+	exitError    = 1
+	exitReloaded = 3
+)
+
+// config holds the reloader config and the settings only the command needs
 type config struct {
-	serviceURL string
-	certName   string
-	namespace  string
+	reloader            reloader.Config
+	skipPermissionCheck bool
+	// kubeContexts are the kubeconfig contexts of the clusters to check
+	kubeContexts []string
+	clientLimits clientLimits
+	// checkInterval runs the reloader as a daemon when set
+	checkInterval time.Duration
+	// watchEvents also checks the targets when their certificate or secret changes
+	watchEvents bool
+	// startupJitter and runSplay are the upper bounds of the random delays
+	// spreading the runs of many reloaders over time
+	startupJitter time.Duration
+	runSplay      time.Duration
+	// adminAddress serves the metrics, status and debug endpoints in daemon mode
+	adminAddress string
+	enablePprof  bool
+	// adminToken authenticates the pause and resume endpoints
+	adminToken string
+	// pushgatewayURL receives the metrics of one-shot runs
+	pushgatewayURL string
+	pushgatewayJob string
+	// expiryWebhookURL receives an alert when a certificate expires without a
+	// renewal, repeated every expiryReminder in daemon mode
+	expiryWebhookURL string
+	expiryReminder   time.Duration
+	// nats publishes reload events when its url is set
+	nats natsPublisher
+	// digest posts a summary of every run when its url is set
+	digest digestNotifier
+	// reportPath is the file the run report is written to, - writes it to stdout
+	reportPath string
 }
 
-func getConfig() config {
-	serviceURL, ok := os.LookupEnv("FLUENTD_SERVICE_URL")
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
+
+func getBoolEnv(key string, fallback bool) bool {
+	value, ok := os.LookupEnv(key)
 	if !ok {
-		panic("FLUENTD_SERVICE_URL is not set")
+		return fallback
 	}
 
-	certName, ok := os.LookupEnv("FLUENTD_CERT_NAME")
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		panic(fmt.Sprintf("%s is not a valid boolean: %v", key, err))
+	}
+
+	return b
+}
+
+func getDurationEnv(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
 	if !ok {
-		panic("FLUENTD_CERT_NAME is not set")
+		return fallback
 	}
 
-	namespace, ok := os.LookupEnv("FLUENTD_NAMESPACE")
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		panic(fmt.Sprintf("%s is not a valid duration: %v", key, err))
+	}
+
+	return d
+}
+
+func getIntEnv(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
 	if !ok {
-		panic("FLUENTD_NAMESPACE is not set")
+		return fallback
 	}
 
-	return config{
-		serviceURL: serviceURL,
-		certName:   certName,
-		namespace:  namespace,
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		panic(fmt.Sprintf("%s is not a valid number: %v", key, err))
 	}
+
+	return i
 }
 
-// get all pods with label app=fluentd in the configured namespace
-// note that this will only work if the pods are created by a statefulset
-func (a app) getFluentdIPs() ([]string, error) {
-	pods, err := a.client.CoreV1().Pods(a.namespace).List(context.Background(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("app=%s", a.namespace),
-	})
+func getFloatEnv(key string, fallback float64) float64 {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
 
+	f, err := strconv.ParseFloat(value, 64)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get fluentd pods: %w", err)
+		panic(fmt.Sprintf("%s is not a valid number: %v", key, err))
 	}
 
-	fluentdIPs := make([]string, 0, len(pods.Items))
-	for _, pod := range pods.Items {
-		if _, ok := pod.Labels["statefulset.kubernetes.io/pod-name"]; !ok {
-			log.Println("Pod is not from statefulset, skipping", pod.Name)
-			continue
-		}
+	return f
+}
 
-		fluentdIPs = append(fluentdIPs, pod.Status.PodIP)
+// getListEnv returns the comma separated values of key
+func getListEnv(key string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return nil
 	}
 
-	return fluentdIPs, nil
+	values := strings.Split(value, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+
+	return values
 }
 
-func (a app) getCRD() (cmapi.Certificate, error) {
-	certificates := cmapi.CertificateList{}
-	uri := fmt.Sprintf("/apis/cert-manager.io/v1/namespaces/%s/certificates", a.namespace)
-	err := a.client.RESTClient().Get().RequestURI(uri).Do(context.Background()).Into(&certificates)
-	if err != nil {
-		return cmapi.Certificate{}, fmt.Errorf("failed to get certificates: %w", err)
+// getHookEnv returns the reload hook configured by the variables with the
+// prefix, nil when it has neither a URL nor a command
+func getHookEnv(prefix string) *reloader.ReloadHook {
+	hook := &reloader.ReloadHook{
+		URL:           os.Getenv(prefix + "_URL"),
+		Command:       strings.Fields(os.Getenv(prefix + "_COMMAND")),
+		Timeout:       getDurationEnv(prefix+"_TIMEOUT", 10*time.Second),
+		FailurePolicy: getEnv(prefix+"_FAILURE_POLICY", reloader.HookFailureAbort),
+	}
+	if hook.URL == "" && len(hook.Command) == 0 {
+		return nil
 	}
 
-	for _, cert := range certificates.Items {
-		if strings.EqualFold(cert.Name, a.certName) {
-			return cert, nil
-		}
+	return hook
+}
 
-		log.Printf("Certificate %s is not fluentd cerificate", cert.Name)
+// getHeadersEnv parses the comma separated "Name: value" headers of key
+func getHeadersEnv(key string) http.Header {
+	headers := http.Header{}
+	for _, header := range getListEnv(key) {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			panic(fmt.Sprintf("%s has an invalid header %q, expected Name: value", key, header))
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
 	}
 
-	return cmapi.Certificate{}, fmt.Errorf("failed to find fluentd certificate")
+	return headers
 }
 
-func checkCert(serviceURL string) (time.Time, error) {
-	conn, err := tls.Dial("tcp", fmt.Sprintf("%s:443", serviceURL), nil)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("Server doesn't support SSL certificate err: %w", err)
+// getJobTemplate reads the job manifest at the path in key
+func getJobTemplate(key string) *batchv1.Job {
+	path := os.Getenv(key)
+	if path == "" {
+		return nil
 	}
 
-	err = conn.VerifyHostname(serviceURL)
+	b, err := os.ReadFile(path)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("Hostname doesn't match with certificate: %w", err)
+		panic(fmt.Sprintf("%s cannot be read: %v", key, err))
+	}
+	job := &batchv1.Job{}
+	if err := yaml.Unmarshal(b, job); err != nil {
+		panic(fmt.Sprintf("%s is not a valid job: %v", key, err))
 	}
-	expiry := conn.ConnectionState().PeerCertificates[0].NotAfter
-	log.Printf("Issuer: %s\nExpiry: %v\n", conn.ConnectionState().PeerCertificates[0].Issuer, expiry.Format(time.RFC850))
 
-	return expiry, nil
+	return job
 }
 
-func reloadFluentdConfig(ips ...string) error {
-	for _, ip := range ips {
-		log.Println("Reloading fluentd config on", ip)
+// jitter returns a random duration in [0, max)
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
 
-		url := fmt.Sprintf("http://%s:24444/api/config.gracefulReload", ip)
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
-		}
+	return time.Duration(random.Int63n(int64(max)))
+}
+
+// getConfig reads the config from the environment, requireTarget demands the
+// certificate and service settings that are not needed to force a reload
+func getConfig(requireTarget bool) config {
+	// the service url and certificate can be given per target in the targets configmap instead
+	targetsConfigMap := os.Getenv("FLUENTD_TARGETS_CONFIGMAP")
+
+	// the secret-revision check mode never connects to fluentd
+	checkMode := os.Getenv("CHECK_MODE")
+
+	// the certificates matching a cert name pattern are annotated with their service URL
+	serviceURL, ok := os.LookupEnv("FLUENTD_SERVICE_URL")
+	if !ok && targetsConfigMap == "" && requireTarget && checkMode != reloader.CheckModeSecretRevision && !reloader.IsCertNamePattern(os.Getenv("FLUENTD_CERT_NAME")) {
+		panic("FLUENTD_SERVICE_URL is not set")
+	}
 
-		client := &http.Client{
-			Timeout: 5 * time.Second,
+	// a plain TLS secret, a PEM file or a Vault path can be checked instead of a cert-manager certificate
+	secretName := os.Getenv("FLUENTD_SECRET_NAME")
+	certFile := os.Getenv("FLUENTD_CERT_FILE")
+	var certSource reloader.CertSource
+	if vaultPath := os.Getenv("VAULT_CERT_PATH"); vaultPath != "" {
+		vault := &reloader.VaultSource{
+			Address:   os.Getenv("VAULT_ADDR"),
+			Path:      vaultPath,
+			Field:     os.Getenv("VAULT_CERT_FIELD"),
+			Namespace: os.Getenv("VAULT_NAMESPACE"),
+			Auth:      getEnv("VAULT_AUTH_METHOD", reloader.VaultAuthToken),
+			AuthMount: os.Getenv("VAULT_AUTH_MOUNT"),
+			Token:     os.Getenv("VAULT_TOKEN"),
+			RoleID:    os.Getenv("VAULT_ROLE_ID"),
+			SecretID:  os.Getenv("VAULT_SECRET_ID"),
+			Role:      os.Getenv("VAULT_ROLE"),
+			CAFile:    os.Getenv("VAULT_CACERT"),
 		}
-		resp, err := client.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to send request: %w", err)
+		if err := vault.Validate(); err != nil {
+			panic(err)
 		}
-		defer resp.Body.Close()
+		certSource = vault
+	}
+
+	certName, ok := os.LookupEnv("FLUENTD_CERT_NAME")
+	if !ok && targetsConfigMap == "" && secretName == "" && certFile == "" && certSource == nil && requireTarget {
+		panic("FLUENTD_CERT_NAME is not set")
+	}
+
+	// several comma separated service URLs are all probed
+	serviceURLs := strings.Split(serviceURL, ",")
+	for i := range serviceURLs {
+		serviceURLs[i] = strings.TrimSpace(serviceURLs[i])
+	}
 
-		if resp.StatusCode >= 400 {
-			return fmt.Errorf("failed to reload fluentd config: %s", resp.Status)
+	namespace, ok := os.LookupEnv("FLUENTD_NAMESPACE")
+	if !ok {
+		// default to the namespace the reloader runs in
+		b, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err == nil {
+			namespace = strings.TrimSpace(string(b))
+			log.Printf("FLUENTD_NAMESPACE is not set, using namespace %s of the service account", namespace)
+		} else if requireTarget {
+			panic(fmt.Sprintf("FLUENTD_NAMESPACE is not set and the service account namespace cannot be read: %v", err))
 		}
+	}
+
+	digestSeverity := getEnv("DIGEST_MIN_SEVERITY", severityChange)
+	if _, ok := severityRank[digestSeverity]; !ok {
+		panic(fmt.Sprintf("DIGEST_MIN_SEVERITY must be %s, %s or %s", severityAlways, severityChange, severityFailure))
+	}
+
+	shards := getIntEnv("SHARDS", 0)
+	shardIndex := getIntEnv("SHARD_INDEX", -1)
+	if shards > 1 && shardIndex < 0 {
+		// replicas of a statefulset take the shard of their ordinal
+		hostname, _ := os.Hostname()
+		ordinal, err := reloader.ShardOrdinal(hostname)
+		if err != nil {
+			panic(fmt.Sprintf("SHARD_INDEX is not set and cannot be derived from the hostname: %v", err))
+		}
+		shardIndex = ordinal
+	}
 
-		b, err := io.ReadAll(resp.Body)
+	var reloadWindow *reloader.ReloadWindow
+	if spec := getEnv("RELOAD_WINDOW", ""); spec != "" {
+		window, err := reloader.ParseReloadWindow(spec, getEnv("RELOAD_WINDOW_TIMEZONE", "UTC"))
 		if err != nil {
-			return fmt.Errorf("failed to read response body: %w", err)
+			panic(fmt.Sprintf("RELOAD_WINDOW is not valid: %v", err))
 		}
+		reloadWindow = window
+	}
 
-		log.Printf("Response: %s", string(b))
+	rpcPort, err := strconv.Atoi(getEnv("FLUENTD_RPC_PORT", "24444"))
+	if err != nil {
+		panic(fmt.Sprintf("FLUENTD_RPC_PORT is not a valid port: %v", err))
 	}
 
-	return nil
+	return config{
+		reloader: reloader.Config{
+			ServiceURL:               serviceURLs[0],
+			ServiceURLs:              serviceURLs[1:],
+			CertName:                 certName,
+			SecretName:               secretName,
+			SecretManager:            getEnv("FLUENTD_SECRET_MANAGER", ""),
+			SecretSyncTimeout:        getDurationEnv("FLUENTD_SECRET_SYNC_TIMEOUT", 2*time.Minute),
+			CertFile:                 certFile,
+			CertSource:               certSource,
+			CertNamespace:            os.Getenv("FLUENTD_CERT_NAMESPACE"),
+			Namespace:                namespace,
+			Selector:                 os.Getenv("FLUENTD_SELECTOR"),
+			SelectorFromService:      os.Getenv("FLUENTD_SELECTOR_FROM_SERVICE"),
+			ReleaseName:              os.Getenv("FLUENTD_RELEASE_NAME"),
+			StatefulSetName:          os.Getenv("FLUENTD_STATEFULSET_NAME"),
+			SyncCondition:            getBoolEnv("FLUENTD_SYNC_CONDITION", false),
+			StatusResource:           getBoolEnv("FLUENTD_STATUS_RESOURCE", false),
+			ForwarderSelector:        os.Getenv("FLUENTD_FORWARDER_SELECTOR"),
+			ForwarderNamespace:       os.Getenv("FLUENTD_FORWARDER_NAMESPACE"),
+			ForwarderRPCPort:         getIntEnv("FLUENTD_FORWARDER_RPC_PORT", 0),
+			ForwarderReloadStrategy:  os.Getenv("FLUENTD_FORWARDER_RELOAD_STRATEGY"),
+			ForwarderWaveDelay:       getDurationEnv("FLUENTD_FORWARDER_WAVE_DELAY", 0),
+			TargetsConfigMap:         targetsConfigMap,
+			RPCMethod:                os.Getenv("FLUENTD_RPC_METHOD"),
+			RPCTimeout:               getDurationEnv("FLUENTD_RPC_TIMEOUT", 5*time.Second),
+			RPCHeaders:               getHeadersEnv("FLUENTD_RPC_HEADERS"),
+			UserAgent:                os.Getenv("FLUENTD_RPC_USER_AGENT"),
+			RPCPort:                  rpcPort,
+			RPCWorkers:               getIntEnv("FLUENTD_RPC_WORKERS", 0),
+			RPCPortName:              os.Getenv("FLUENTD_RPC_PORT_NAME"),
+			RunDeadline:              getDurationEnv("RUN_DEADLINE", 0),
+			CheckMode:                checkMode,
+			ReloadVia:                os.Getenv("FLUENTD_RELOAD_VIA"),
+			HeadlessService:          os.Getenv("FLUENTD_HEADLESS_SERVICE"),
+			IPFamily:                 strings.ToLower(os.Getenv("FLUENTD_IP_FAMILY")),
+			ReloadURLTemplate:        os.Getenv("FLUENTD_RELOAD_URL_TEMPLATE"),
+			ReloadStrategy:           os.Getenv("FLUENTD_RELOAD_STRATEGY"),
+			FallbackStrategy:         os.Getenv("FLUENTD_FALLBACK_STRATEGY"),
+			VerifyConfigDump:         getBoolEnv("FLUENTD_VERIFY_CONFIG_DUMP", false),
+			ReloadOnHostnameMismatch: getBoolEnv("FLUENTD_RELOAD_ON_HOSTNAME_MISMATCH", false),
+			ConfigMapName:            getEnv("FLUENTD_CONFIGMAP", ""),
+			ConfigMapKey:             getEnv("FLUENTD_CONFIGMAP_KEY", "fluent.conf"),
+			ReportHistoryConfigMap:   getEnv("REPORT_HISTORY_CONFIGMAP", ""),
+			ReportHistoryLimit:       getIntEnv("REPORT_HISTORY_LIMIT", 10),
+			ContainerName:            os.Getenv("FLUENTD_CONTAINER_NAME"),
+			MountedCertPath:          os.Getenv("FLUENTD_MOUNTED_CERT_PATH"),
+			PreReloadHook:            getHookEnv("PRE_RELOAD_HOOK"),
+			PostReloadHook:           getHookEnv("POST_RELOAD_HOOK"),
+			DisruptionWait:           getDurationEnv("FLUENTD_DISRUPTION_WAIT", 0),
+			ReloadSignal:             os.Getenv("FLUENTD_RELOAD_SIGNAL"),
+			AnnotatePods:             getBoolEnv("FLUENTD_ANNOTATE_PODS", false),
+			RecordHistory:            getBoolEnv("FLUENTD_RECORD_HISTORY", false),
+			CompareChain:             getBoolEnv("FLUENTD_COMPARE_CHAIN", false),
+			ComparePublicKey:         getBoolEnv("FLUENTD_COMPARE_PUBLIC_KEY", false),
+			OrderedReload:            getBoolEnv("FLUENTD_ORDERED_RELOAD", false),
+			ReloadPartition:          getIntEnv("FLUENTD_RELOAD_PARTITION", 0),
+			ReloadPause:              getDurationEnv("FLUENTD_RELOAD_PAUSE", 0),
+			AdaptivePacing:           getBoolEnv("FLUENTD_ADAPTIVE_PACING", false),
+			MaxReloadPause:           getDurationEnv("FLUENTD_MAX_RELOAD_PAUSE", 2*time.Minute),
+			MaxReloadPercent:         getIntEnv("FLUENTD_MAX_RELOAD_PERCENT", 0),
+			AllowMassReload:          getBoolEnv("FLUENTD_ALLOW_MASS_RELOAD", false),
+			ReloadWindow:             reloadWindow,
+			FieldSelector:            getEnv("FLUENTD_FIELD_SELECTOR", ""),
+			NodeName:                 getEnv("FLUENTD_NODE_NAME", ""),
+			Zone:                     getEnv("FLUENTD_ZONE", ""),
+			Shards:                   shards,
+			ShardIndex:               shardIndex,
+			ForwardCheck:             getBoolEnv("FLUENTD_FORWARD_CHECK", false),
+			ForwardPort:              getIntEnv("FLUENTD_FORWARD_PORT", 0),
+			ForwardTLS:               getBoolEnv("FLUENTD_FORWARD_TLS", false),
+			ForwardCheckTimeout:      getDurationEnv("FLUENTD_FORWARD_CHECK_TIMEOUT", 0),
+			Canary:                   getBoolEnv("FLUENTD_CANARY", false),
+			CanaryTLSPort:            getIntEnv("FLUENTD_CANARY_TLS_PORT", 0),
+			CanaryHealthPort:         getIntEnv("FLUENTD_CANARY_HEALTH_PORT", 0),
+			CanaryHealthPath:         os.Getenv("FLUENTD_CANARY_HEALTH_PATH"),
+			CanaryTimeout:            getDurationEnv("FLUENTD_CANARY_TIMEOUT", 0),
+			MaxBufferQueueLength:     getIntEnv("FLUENTD_MAX_BUFFER_QUEUE_LENGTH", 0),
+			MaxRetryCount:            getIntEnv("FLUENTD_MAX_RETRY_COUNT", 0),
+			MonitorPort:              getIntEnv("FLUENTD_MONITOR_PORT", 0),
+			SkipUnhealthy:            getBoolEnv("FLUENTD_SKIP_UNHEALTHY", false),
+			CircuitFailures:          getIntEnv("FLUENTD_CIRCUIT_FAILURES", 0),
+			CircuitCooldown:          getDurationEnv("FLUENTD_CIRCUIT_COOLDOWN", time.Hour),
+			CircuitRestart:           getBoolEnv("FLUENTD_CIRCUIT_RESTART", false),
+			CheckOCSP:                getBoolEnv("PROBE_CHECK_OCSP", false),
+			CRLURL:                   os.Getenv("PROBE_CRL_URL"),
+			NotAfterTolerance:        getDurationEnv("FLUENTD_NOT_AFTER_TOLERANCE", 0),
+			StrictNotAfter:           getBoolEnv("FLUENTD_STRICT_NOT_AFTER", false),
+			PropagationWait:          getDurationEnv("SECRET_PROPAGATION_WAIT", 0),
+			RenewalWait:              getDurationEnv("RENEWAL_WAIT", 0),
+			JobTemplate:              getJobTemplate("JOB_TEMPLATE"),
+			ExpiryWarning:            time.Duration(getIntEnv("EXPIRY_WARNING_DAYS", 0)) * 24 * time.Hour,
+			TriggerRenewal:           getBoolEnv("TRIGGER_RENEWAL", false),
+			DependentCerts:           getListEnv("FLUENTD_DEPENDENT_CERTS"),
+			DependentCertsWait:       getDurationEnv("FLUENTD_DEPENDENT_CERTS_WAIT", 0),
+			RPCProtocol:              os.Getenv("FLUENTD_RPC_PROTOCOL"),
+			RPCProxy:                 os.Getenv("FLUENTD_RPC_PROXY"),
+			RPCClientCert:            os.Getenv("FLUENTD_RPC_CLIENT_CERT"),
+			RPCClientKey:             os.Getenv("FLUENTD_RPC_CLIENT_KEY"),
+			RPCCAFile:                os.Getenv("FLUENTD_RPC_CA_FILE"),
+			RPCServerName:            os.Getenv("FLUENTD_RPC_SERVER_NAME"),
+			ProbeProxy:               os.Getenv("PROBE_PROXY"),
+			ProbeProxyUsername:       os.Getenv("PROBE_PROXY_USERNAME"),
+			ProbeProxyPassword:       os.Getenv("PROBE_PROXY_PASSWORD"),
+			ProbeTimeout:             getDurationEnv("PROBE_TIMEOUT", 0),
+			ProbeAttempts:            getIntEnv("PROBE_ATTEMPTS", 0),
+			ProbeMinTLSVersion:       os.Getenv("PROBE_MIN_TLS_VERSION"),
+			ProbeCipherSuites:        getListEnv("PROBE_CIPHER_SUITES"),
+			ProbePortForward:         os.Getenv("PROBE_PORT_FORWARD_SERVICE"),
+			ProbePort:                getIntEnv("PROBE_PORT", 0),
+			Profiles:                 getListEnv("FLUENTD_PROFILES"),
+		},
+		skipPermissionCheck: getBoolEnv("SKIP_PERMISSION_CHECK", false),
+		kubeContexts:        getListEnv("KUBE_CONTEXTS"),
+		clientLimits: clientLimits{
+			qps:   float32(getFloatEnv("KUBE_API_QPS", 0)),
+			burst: getIntEnv("KUBE_API_BURST", 0),
+		},
+		checkInterval:    getDurationEnv("CHECK_INTERVAL", 0),
+		watchEvents:      getBoolEnv("WATCH_EVENTS", false),
+		startupJitter:    getDurationEnv("STARTUP_JITTER", 0),
+		runSplay:         getDurationEnv("RUN_SPLAY", 0),
+		reportPath:       os.Getenv("REPORT_PATH"),
+		adminAddress:     getEnv("ADMIN_ADDRESS", ":8080"),
+		pushgatewayURL:   strings.TrimSuffix(os.Getenv("PUSHGATEWAY_URL"), "/"),
+		pushgatewayJob:   getEnv("PUSHGATEWAY_JOB", "fluentd-reloader"),
+		enablePprof:      getBoolEnv("ENABLE_PPROF", false),
+		adminToken:       os.Getenv("ADMIN_TOKEN"),
+		expiryWebhookURL: os.Getenv("EXPIRY_WEBHOOK_URL"),
+		nats: natsPublisher{
+			url:          os.Getenv("NATS_URL"),
+			subject:      getEnv("NATS_SUBJECT", "fluentd-reloader.reloads"),
+			user:         os.Getenv("NATS_USER"),
+			password:     os.Getenv("NATS_PASSWORD"),
+			token:        os.Getenv("NATS_TOKEN"),
+			caFile:       os.Getenv("NATS_CA_FILE"),
+			credsFile:    os.Getenv("NATS_CREDS_FILE"),
+			nkeySeedFile: os.Getenv("NATS_NKEY_SEED_FILE"),
+			certFile:     os.Getenv("NATS_CERT_FILE"),
+			keyFile:      os.Getenv("NATS_KEY_FILE"),
+		},
+		expiryReminder: getDurationEnv("EXPIRY_REMINDER", 24*time.Hour),
+		digest: digestNotifier{
+			url:         os.Getenv("DIGEST_WEBHOOK_URL"),
+			minSeverity: digestSeverity,
+		},
+	}
 }
 
-func main() {
-	// setup kubernetes client with default config
-	// works both locally if you have kubectl correctly configured and in cluster
-	cfg, err := rest.InClusterConfig()
+// writeReport writes the report of a run as JSON to path, - writes it to stdout
+func writeReport(path string, r reloader.Report) error {
+	b, err := json.MarshalIndent(r, "", "  ")
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	b = append(b, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(b)
+	} else {
+		err = os.WriteFile(path, b, 0o644)
 	}
-	clientset, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to write report: %w", err)
 	}
 
-	config := getConfig()
-	app := app{
-		namespace: config.namespace,
-		certName:  config.certName,
-		client:    clientset,
-	}
+	return nil
+}
 
-	fluentdIPs, err := app.getFluentdIPs()
-	if err != nil {
+// runForceReload reloads fluentd on every target regardless of the served certificate
+func runForceReload(config config, clusters []reloader.Config, args []string) {
+	flags := flag.NewFlagSet("force-reload", flag.ExitOnError)
+	selector := flags.String("selector", config.reloader.Selector, "label selector of the fluentd pods")
+	namespace := flags.String("namespace", config.reloader.Namespace, "namespace of the fluentd pods")
+	output := flags.String("o", "", "print the report as table, json or yaml")
+	if err := flags.Parse(args); err != nil {
 		panic(err)
 	}
+	if !validOutput(*output) {
+		panic("-o must be table, json or yaml")
+	}
+	for i := range clusters {
+		clusters[i].Selector = *selector
+		clusters[i].Namespace = *namespace
+	}
 
-	expiry, err := checkCert(config.serviceURL)
+	report, err := runClusters(context.Background(), clusters, reloader.ForceReload)
+	if err := writeOutput(os.Stdout, *output, report); err != nil {
+		log.Println(err)
+	}
+	if config.reportPath != "" {
+		if err := writeReport(config.reportPath, report); err != nil {
+			log.Println(err)
+		}
+	}
+	if config.nats.url != "" {
+		if err := config.nats.publish(report); err != nil {
+			log.Println(err)
+		}
+	}
+	if config.digest.url != "" {
+		if err := config.digest.notify(report); err != nil {
+			log.Println(err)
+		}
+	}
 	if err != nil {
 		panic(err)
 	}
+}
 
-	certificate, err := app.getCRD()
+// runCleanup removes the state the reloader recorded for every target
+func runCleanup(clusters []reloader.Config) {
+	report, err := runClusters(context.Background(), clusters, reloader.Cleanup)
+	if err := writeOutput(os.Stdout, outputTable, report); err != nil {
+		log.Println(err)
+	}
 	if err != nil {
 		panic(err)
 	}
+}
+
+// runValidate prints the preflight checks of every cluster and exits with 1
+// when any of them failed
+func runValidate(clusters []reloader.Config) {
+	failed := 0
+	for _, cluster := range clusters {
+		for _, check := range reloader.Preflight(context.Background(), cluster) {
+			result := "PASS"
+			if !check.OK {
+				result = "FAIL"
+				failed++
+			}
+
+			name := check.Name
+			if check.Target != "" {
+				name = check.Target + " " + name
+			}
+			if cluster.Cluster != "" {
+				name = cluster.Cluster + " " + name
+			}
+			if check.Detail != "" {
+				name += ": " + check.Detail
+			}
+			fmt.Println(result, name)
+		}
+	}
 
-	log.Printf("Certificate will expire on %v\n", expiry)
-	t := metav1.NewTime(expiry)
-	if certificate.Status.NotAfter.Equal(&t) {
-		log.Printf("Certificate will be renewed on %v\n", certificate.Status.RenewalTime)
-		log.Println("Certificate is valid")
+	if failed > 0 {
+		fmt.Printf("%d checks failed\n", failed)
+		os.Exit(exitError)
+	}
+}
+
+func main() {
+	reportChangeExitCode := flag.Bool("report-change-exit-code", false,
+		"exit with 3 when a reload was performed, 0 when in sync and 1 on error and print a JSON summary to stdout")
+	selectorFromService := flag.String("selector-from-service", "",
+		"select the fluentd pods with the selector of this service, auto derives it from FLUENTD_SERVICE_URL")
+	allowMassReload := flag.Bool("allow-mass-reload", false,
+		"reload even when more pods than FLUENTD_MAX_RELOAD_PERCENT need it at once")
+	flag.Parse()
+
+	// the sidecar only talks to the fluentd next to it and needs no kubernetes access
+	if certDir := os.Getenv("SIDECAR_CERT_DIR"); certDir != "" {
+		err := reloader.WatchCertificates(context.Background(), reloader.SidecarConfig{
+			CertDir:    certDir,
+			RPCAddress: "localhost:" + getEnv("FLUENTD_RPC_PORT", "24444"),
+			RPCSocket:  os.Getenv("FLUENTD_RPC_SOCKET"),
+			RPCMethod:  os.Getenv("FLUENTD_RPC_METHOD"),
+			RPCTimeout: getDurationEnv("FLUENTD_RPC_TIMEOUT", 5*time.Second),
+		})
+		if err != nil {
+			panic(err)
+		}
 
 		return
 	}
 
-	log.Println("Certificate is not valid")
-	log.Printf("Certificate should expire on %v but it expires on %v\n", certificate.Status.NotAfter, expiry)
-	err = reloadFluentdConfig(fluentdIPs...)
+	forceReload := flag.Arg(0) == "force-reload"
+	config := getConfig(!forceReload)
+	if *selectorFromService != "" {
+		config.reloader.SelectorFromService = *selectorFromService
+	}
+	if *allowMassReload {
+		config.reloader.AllowMassReload = true
+	}
+	if config.reloader.JobTemplate != nil && (!config.watchEvents || config.checkInterval <= 0 || forceReload) {
+		panic("JOB_TEMPLATE needs WATCH_EVENTS and CHECK_INTERVAL")
+	}
+	// check and force-reload run once, whatever CHECK_INTERVAL is
+	config.reloader.Daemon = config.checkInterval > 0 && !forceReload && flag.Arg(0) != "check"
+	config.reloader.WatchEvents = config.reloader.Daemon && config.watchEvents
+
+	// setup a kubernetes client for every cluster
+	clusters, err := getClusters(config.reloader, config.kubeContexts, config.clientLimits)
 	if err != nil {
 		panic(err)
 	}
+	if forceReload {
+		runForceReload(config, clusters, flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "explain" {
+		runExplain(clusters)
+		return
+	}
+	if flag.Arg(0) == "cleanup" {
+		runCleanup(clusters)
+		return
+	}
+	if flag.Arg(0) == "validate" {
+		runValidate(clusters)
+		return
+	}
+	// check runs once and prints the report regardless of CHECK_INTERVAL
+	output := ""
+	if flag.Arg(0) == "check" {
+		flags := flag.NewFlagSet("check", flag.ExitOnError)
+		flags.StringVar(&output, "o", outputTable, "print the report as table, json or yaml")
+		if err := flags.Parse(flag.Args()[1:]); err != nil {
+			panic(err)
+		}
+		if output == "" || !validOutput(output) {
+			panic("-o must be table, json or yaml")
+		}
+		config.checkInterval = 0
+	}
+
+	for _, cluster := range clusters {
+		if err := cluster.Validate(); err != nil {
+			panic(err)
+		}
+
+		if !config.skipPermissionCheck {
+			if err := reloader.CheckPermissions(context.Background(), cluster); err != nil {
+				panic(fmt.Sprintf("cluster %s: %v", cluster.Cluster, err))
+			}
+		}
+	}
+
+	if config.startupJitter > 0 {
+		delay := jitter(config.startupJitter)
+		log.Printf("Delaying start by %v", delay)
+		time.Sleep(delay)
+	}
+
+	if config.checkInterval > 0 {
+		log.Printf("Running as daemon, checking every %v", config.checkInterval)
+		for i := range clusters {
+			if clusters[i].Daemon {
+				clusters[i].PodCache = reloader.NewPodCache(context.Background(), clusters[i].Client, config.checkInterval)
+			}
+		}
+		p := &pauser{token: config.adminToken}
+		for i := range clusters {
+			clusters[i].Paused = p.isPaused
+		}
+		m, st := &metrics{cfg: config.reloader}, &status{paused: p.isPaused}
+		alerter := &expiryAlerter{url: config.expiryWebhookURL, reminder: config.expiryReminder}
+		go serveAdmin(config.adminAddress, config.enablePprof, m, st, p)
+		var reportMu sync.Mutex
+		onReport := func(report reloader.Report) {
+			reportMu.Lock()
+			defer reportMu.Unlock()
+			m.update(report)
+			st.update(report)
+			if alerter.url != "" {
+				alerter.notify(report)
+			}
+			if config.reportPath != "" {
+				if err := writeReport(config.reportPath, report); err != nil {
+					log.Println(err)
+				}
+			}
+			if config.nats.url != "" {
+				if err := config.nats.publish(report); err != nil {
+					log.Println(err)
+				}
+			}
+			if config.digest.url != "" {
+				if err := config.digest.notify(report); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+
+		// every target is checked in its own goroutine
+		errs := make(chan error, len(clusters))
+		for _, cluster := range clusters {
+			go func(cluster reloader.Config) {
+				if config.watchEvents {
+					errs <- reloader.Watch(context.Background(), cluster, config.checkInterval, onReport)
+				} else {
+					errs <- reloader.Loop(context.Background(), cluster, config.checkInterval, config.runSplay, onReport)
+				}
+			}(cluster)
+		}
+		if err := <-errs; err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
+	start := time.Now()
+	// a job spawned by the daemon continues the run that created it
+	ctx := context.Background()
+	if runID := getEnv("FLUENTD_RUN_ID", ""); runID != "" {
+		ctx = reloader.WithRunID(ctx, runID)
+	}
+	report, err := runClusters(ctx, clusters, reloader.Run)
+	if err := writeOutput(os.Stdout, output, report); err != nil {
+		log.Println(err)
+	}
+	if config.reportPath != "" {
+		if err := writeReport(config.reportPath, report); err != nil {
+			log.Println(err)
+		}
+	}
+	if config.nats.url != "" {
+		if err := config.nats.publish(report); err != nil {
+			log.Println(err)
+		}
+	}
+	if config.digest.url != "" {
+		if err := config.digest.notify(report); err != nil {
+			log.Println(err)
+		}
+	}
+	if config.expiryWebhookURL != "" {
+		alerter := &expiryAlerter{url: config.expiryWebhookURL, reminder: config.expiryReminder}
+		alerter.notify(report)
+	}
+	if config.pushgatewayURL != "" {
+		if err := pushMetrics(config.pushgatewayURL, config.pushgatewayJob, report, config.reloader, time.Since(start)); err != nil {
+			log.Println(err)
+		}
+	}
+	if !*reportChangeExitCode {
+		if err != nil {
+			panic(err)
+		}
+
+		return
+	}
+
+	exitCode := exitInSync
+	for _, s := range report.Targets {
+		switch {
+		case s.Status == reloader.StatusError:
+			exitCode = exitError
+		case s.Status == reloader.StatusReloaded && exitCode != exitError:
+			exitCode = exitReloaded
+		}
+
+		if err := json.NewEncoder(os.Stdout).Encode(s); err != nil {
+			log.Printf("Failed to write summary: %v", err)
+		}
+	}
+
+	os.Exit(exitCode)
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/donchev7/fluentd-reloader/pkg/reloader"
+)
+
+// metrics exposes the latest report of every target in the Prometheus text
+// format, or in the OpenMetrics format with exemplars when the scraper asks for it
+type metrics struct {
+	// cfg decides whether the served and the expected expiry match
+	cfg reloader.Config
+
+	mu      sync.Mutex
+	targets map[string]reloader.TargetReport
+	reloads map[reloadKey]*reloadCount
+}
+
+// reloadKey identifies a series of the reload counter
+type reloadKey struct {
+	cluster, target, reason string
+}
+
+// reloadCount counts the reloads of a series, the last reload is its exemplar
+type reloadCount struct {
+	count  int
+	serial string
+	runID  string
+	at     time.Time
+}
+
+func (m *metrics) update(r reloader.Report) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.targets == nil {
+		m.targets = map[string]reloader.TargetReport{}
+		m.reloads = map[reloadKey]*reloadCount{}
+	}
+	for _, s := range r.Targets {
+		m.targets[s.Cluster+"/"+s.Target] = s
+
+		for _, action := range append(s.Actions, s.ForwarderActions...) {
+			if action.Outcome != reloader.OutcomeReloaded || action.Reason == "" {
+				continue
+			}
+			key := reloadKey{cluster: s.Cluster, target: s.Target, reason: action.Reason}
+			if m.reloads[key] == nil {
+				m.reloads[key] = &reloadCount{}
+			}
+			c := m.reloads[key]
+			c.count++
+			c.serial, c.runID, c.at = s.ExpectedSerial, s.RunID, r.GeneratedAt
+		}
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	report := reloader.Report{}
+	for _, s := range m.targets {
+		report.Targets = append(report.Targets, s)
+	}
+	keys := make([]reloadKey, 0, len(m.reloads))
+	reloads := map[reloadKey]reloadCount{}
+	for key, c := range m.reloads {
+		keys = append(keys, key)
+		reloads[key] = *c
+	}
+	m.mu.Unlock()
+	sort.Slice(report.Targets, func(i, j int) bool {
+		return report.Targets[i].Cluster+"/"+report.Targets[i].Target < report.Targets[j].Cluster+"/"+report.Targets[j].Target
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].cluster+"/"+keys[i].target+"/"+keys[i].reason < keys[j].cluster+"/"+keys[j].target+"/"+keys[j].reason
+	})
+
+	// exemplars are only part of the OpenMetrics format
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	}
+	writeMetrics(w, report, m.cfg)
+	writeCounterHeader(w, "fluentd_reloads_total", "Number of fluentd instances reloaded, by reason, with the expected certificate serial of the last reload as exemplar.", openMetrics)
+	for _, key := range keys {
+		c := reloads[key]
+		fmt.Fprintf(w, "fluentd_reloads_total{cluster=%s,target=%s,reason=%s} %d", strconv.Quote(key.cluster), strconv.Quote(key.target), strconv.Quote(key.reason), c.count)
+		if openMetrics && c.serial != "" {
+			fmt.Fprintf(w, " # {serial=%s,run_id=%s} 1 %d", strconv.Quote(c.serial), strconv.Quote(c.runID), c.at.Unix())
+		}
+		fmt.Fprintln(w)
+	}
+	apiClientMetrics.write(w, openMetrics)
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
+
+// writeCounterHeader writes the HELP and TYPE lines of a counter, OpenMetrics
+// names the family without the _total suffix of its samples
+func writeCounterHeader(w io.Writer, name, help string, openMetrics bool) {
+	if openMetrics {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+}
+
+// writeMetrics writes the certificate gauges of every target of the report
+func writeMetrics(w io.Writer, report reloader.Report, cfg reloader.Config) {
+	fmt.Fprintln(w, "# HELP cert_served_not_after_seconds Expiry of the certificate fluentd serves.")
+	fmt.Fprintln(w, "# TYPE cert_served_not_after_seconds gauge")
+	for _, s := range report.Targets {
+		if !s.ServedNotAfter.IsZero() {
+			fmt.Fprintf(w, "cert_served_not_after_seconds{cluster=%s,target=%s} %d\n", strconv.Quote(s.Cluster), strconv.Quote(s.Target), s.ServedNotAfter.Unix())
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP cert_expected_not_after_seconds Expiry of the certificate cert-manager issued.")
+	fmt.Fprintln(w, "# TYPE cert_expected_not_after_seconds gauge")
+	for _, s := range report.Targets {
+		if !s.ExpectedNotAfter.IsZero() {
+			fmt.Fprintf(w, "cert_expected_not_after_seconds{cluster=%s,target=%s} %d\n", strconv.Quote(s.Cluster), strconv.Quote(s.Target), s.ExpectedNotAfter.Unix())
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP cert_drift_detected Whether fluentd served a stale certificate in the last check.")
+	fmt.Fprintln(w, "# TYPE cert_drift_detected gauge")
+	for _, s := range report.Targets {
+		if s.ServedNotAfter.IsZero() || s.ExpectedNotAfter.IsZero() {
+			continue
+		}
+
+		drift := 0
+		if !cfg.NotAfterMatches(s.ServedNotAfter, s.ExpectedNotAfter) {
+			drift = 1
+		}
+		fmt.Fprintf(w, "cert_drift_detected{cluster=%s,target=%s} %d\n", strconv.Quote(s.Cluster), strconv.Quote(s.Target), drift)
+	}
+
+	fmt.Fprintln(w, "# HELP reload_buffer_gated_pods Number of pods not reloaded in the last check because their buffers were too deep.")
+	fmt.Fprintln(w, "# TYPE reload_buffer_gated_pods gauge")
+	for _, s := range report.Targets {
+		fmt.Fprintf(w, "reload_buffer_gated_pods{cluster=%s,target=%s} %d\n", strconv.Quote(s.Cluster), strconv.Quote(s.Target), len(s.BufferGatedPods))
+	}
+
+	fmt.Fprintln(w, "# HELP reload_unhealthy_pods Number of pods not reloaded in the last check because they were unhealthy.")
+	fmt.Fprintln(w, "# TYPE reload_unhealthy_pods gauge")
+	for _, s := range report.Targets {
+		fmt.Fprintf(w, "reload_unhealthy_pods{cluster=%s,target=%s} %d\n", strconv.Quote(s.Cluster), strconv.Quote(s.Target), len(s.UnhealthyPods))
+	}
+
+	fmt.Fprintln(w, "# HELP reload_config_drifted_pods Number of pods running a config differing from the fluentd ConfigMap in the last check.")
+	fmt.Fprintln(w, "# TYPE reload_config_drifted_pods gauge")
+	for _, s := range report.Targets {
+		fmt.Fprintf(w, "reload_config_drifted_pods{cluster=%s,target=%s} %d\n", strconv.Quote(s.Cluster), strconv.Quote(s.Target), len(s.DriftedPods))
+	}
+
+	fmt.Fprintln(w, "# HELP reload_open_circuits Number of pods not reloaded because their reloads kept failing.")
+	fmt.Fprintln(w, "# TYPE reload_open_circuits gauge")
+	for _, s := range report.Targets {
+		fmt.Fprintf(w, "reload_open_circuits{cluster=%s,target=%s} %d\n", strconv.Quote(s.Cluster), strconv.Quote(s.Target), len(s.OpenCircuits))
+	}
+
+	fmt.Fprintln(w, "# HELP last_run_info ID of the last check, matching the run ID in the logs, events and X-Request-ID of fluentd calls.")
+	fmt.Fprintln(w, "# TYPE last_run_info gauge")
+	for _, s := range report.Targets {
+		if s.RunID != "" {
+			fmt.Fprintf(w, "last_run_info{cluster=%s,target=%s,run_id=%s} 1\n", strconv.Quote(s.Cluster), strconv.Quote(s.Target), strconv.Quote(s.RunID))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP check_failed Whether the last check failed, by error class.")
+	fmt.Fprintln(w, "# TYPE check_failed gauge")
+	for _, s := range report.Targets {
+		for _, class := range []string{reloader.ErrorClassRetriable, reloader.ErrorClassTerminal} {
+			failed := 0
+			if s.ErrorClass == class {
+				failed = 1
+			}
+			fmt.Fprintf(w, "check_failed{cluster=%s,target=%s,class=%s} %d\n", strconv.Quote(s.Cluster), strconv.Quote(s.Target), strconv.Quote(class), failed)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP cert_expiry_warning Whether the served certificate expires soon and cert-manager has not renewed it.")
+	fmt.Fprintln(w, "# TYPE cert_expiry_warning gauge")
+	for _, s := range report.Targets {
+		if s.ServedNotAfter.IsZero() {
+			continue
+		}
+
+		warning := 0
+		if s.ExpiryWarning {
+			warning = 1
+		}
+		fmt.Fprintf(w, "cert_expiry_warning{cluster=%s,target=%s} %d\n", strconv.Quote(s.Cluster), strconv.Quote(s.Target), warning)
+	}
+}
+
+// targetStatuses returns reloader.Statuses followed by the other counted
+// statuses, so a status missing from the list is still exported
+func targetStatuses(counts map[string]int) []string {
+	known := map[string]bool{}
+	for _, status := range reloader.Statuses {
+		known[status] = true
+	}
+
+	var unknown []string
+	for status := range counts {
+		if !known[status] {
+			unknown = append(unknown, status)
+		}
+	}
+	sort.Strings(unknown)
+
+	return append(append([]string{}, reloader.Statuses...), unknown...)
+}
+
+// pushMetrics pushes the metrics of a one-shot run to a Prometheus Pushgateway,
+// replacing the metrics previously pushed for the job
+func pushMetrics(gatewayURL, job string, report reloader.Report, cfg reloader.Config, duration time.Duration) error {
+	var body bytes.Buffer
+	writeMetrics(&body, report, cfg)
+	apiClientMetrics.write(&body, false)
+
+	counts := map[string]int{}
+	for _, s := range report.Targets {
+		counts[s.Status]++
+	}
+	fmt.Fprintln(&body, "# HELP fluentd_reloader_targets Number of targets by status in the last run.")
+	fmt.Fprintln(&body, "# TYPE fluentd_reloader_targets gauge")
+	for _, status := range targetStatuses(counts) {
+		fmt.Fprintf(&body, "fluentd_reloader_targets{status=%s} %d\n", strconv.Quote(status), counts[status])
+	}
+	fmt.Fprintln(&body, "# HELP fluentd_reloader_run_duration_seconds Duration of the last run.")
+	fmt.Fprintln(&body, "# TYPE fluentd_reloader_run_duration_seconds gauge")
+	fmt.Fprintf(&body, "fluentd_reloader_run_duration_seconds %f\n", duration.Seconds())
+	fmt.Fprintln(&body, "# HELP fluentd_reloader_last_run_timestamp_seconds Time of the last run.")
+	fmt.Fprintln(&body, "# TYPE fluentd_reloader_last_run_timestamp_seconds gauge")
+	fmt.Fprintf(&body, "fluentd_reloader_last_run_timestamp_seconds %d\n", report.GeneratedAt.Unix())
+
+	endpoint := fmt.Sprintf("%s/metrics/job/%s", gatewayURL, url.PathEscape(job))
+	req, err := http.NewRequest(http.MethodPut, endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create push request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to push metrics: %s", resp.Status)
+	}
+
+	return nil
+}
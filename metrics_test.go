@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donchev7/fluentd-reloader/pkg/reloader"
+)
+
+func TestTargetStatuses(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[string]int
+		want   []string
+	}{
+		{name: "no targets", want: reloader.Statuses},
+		{
+			name:   "known statuses",
+			counts: map[string]int{reloader.StatusInSync: 2, reloader.StatusReloaded: 1},
+			want:   reloader.Statuses,
+		},
+		{
+			name:   "unknown statuses are appended sorted",
+			counts: map[string]int{"unknown-b": 1, reloader.StatusError: 1, "unknown-a": 1},
+			want:   append(append([]string{}, reloader.Statuses...), "unknown-a", "unknown-b"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targetStatuses(tt.counts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targetStatuses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []reloader.TargetReport
+		// want are the fluentd_reloader_targets values by status
+		want map[string]int
+	}{
+		{name: "no targets", want: map[string]int{reloader.StatusInSync: 0, reloader.StatusError: 0}},
+		{
+			name: "counted statuses",
+			targets: []reloader.TargetReport{
+				{Target: "a", Status: reloader.StatusInSync},
+				{Target: "b", Status: reloader.StatusInSync},
+				{Target: "c", Status: reloader.StatusReloaded},
+			},
+			want: map[string]int{reloader.StatusInSync: 2, reloader.StatusReloaded: 1, reloader.StatusError: 0},
+		},
+		{
+			name:    "unknown status",
+			targets: []reloader.TargetReport{{Target: "a", Status: "unknown"}},
+			want:    map[string]int{"unknown": 1, reloader.StatusInSync: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, path, body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				method, path, body = r.Method, r.URL.Path, string(b)
+			}))
+			defer srv.Close()
+
+			report := reloader.Report{GeneratedAt: time.Now(), Targets: tt.targets}
+			if err := pushMetrics(srv.URL, "fluentd reloader", report, reloader.Config{}, time.Second); err != nil {
+				t.Fatalf("pushMetrics() error = %v", err)
+			}
+
+			if method != http.MethodPut {
+				t.Errorf("method = %s, want PUT", method)
+			}
+			if path != "/metrics/job/fluentd reloader" {
+				t.Errorf("path = %s, want /metrics/job/fluentd reloader", path)
+			}
+			for _, status := range reloader.Statuses {
+				if !strings.Contains(body, "fluentd_reloader_targets{status="+strconv.Quote(status)+"}") {
+					t.Errorf("status %s is not exported:\n%s", status, body)
+				}
+			}
+			for status, count := range tt.want {
+				line := "fluentd_reloader_targets{status=" + strconv.Quote(status) + "} " + strconv.Itoa(count) + "\n"
+				if !strings.Contains(body, line) {
+					t.Errorf("body does not contain %q:\n%s", line, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/donchev7/fluentd-reloader/pkg/reloader"
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats of the check and force-reload subcommands
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// validOutput reports whether format is a supported output format, empty prints nothing
+func validOutput(format string) bool {
+	switch format {
+	case "", outputTable, outputJSON, outputYAML:
+		return true
+	}
+
+	return false
+}
+
+// writeOutput prints the report in the given format
+func writeOutput(w io.Writer, format string, r reloader.Report) error {
+	switch format {
+	case outputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case outputYAML:
+		b, err := yaml.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		_, err = w.Write(b)
+		return err
+	case outputTable:
+		return writeTable(w, r)
+	}
+
+	return nil
+}
+
+// writeTable prints a line per target followed by a line per reload action
+func writeTable(w io.Writer, r reloader.Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLUSTER\tTARGET\tSTATUS\tSERVED NOT AFTER\tEXPECTED NOT AFTER\tPODS\tERROR")
+	for _, s := range r.Targets {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", dash(s.Cluster), dash(s.Target), s.Status,
+			formatTime(s.ServedNotAfter), formatTime(s.ExpectedNotAfter), len(s.DiscoveredPods), dash(s.Error))
+	}
+
+	actions := false
+	for _, s := range r.Targets {
+		actions = actions || len(s.Actions) > 0
+	}
+	if actions {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "CLUSTER\tTARGET\tPOD\tOUTCOME\tDURATION\tERROR")
+		for _, s := range r.Targets {
+			for _, a := range s.Actions {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", dash(s.Cluster), dash(s.Target), a.Target, a.Outcome, dash(a.Duration), dash(a.Error))
+			}
+		}
+	}
+
+	return tw.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	// keep multi-line errors on their row
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// pauser suspends reloads while checks and metrics continue, it is toggled
+// through the authenticated /admin/pause and /admin/resume endpoints
+type pauser struct {
+	token  string
+	paused atomic.Bool
+}
+
+func (p *pauser) isPaused() bool {
+	return p.paused.Load()
+}
+
+// handler sets the pause state to paused for POST requests with the admin token
+func (p *pauser) handler(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(p.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if p.paused.Swap(paused) != paused {
+			if paused {
+				log.Println("Reloads paused through the admin API")
+			} else {
+				log.Println("Reloads resumed through the admin API")
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
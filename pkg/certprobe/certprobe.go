@@ -0,0 +1,289 @@
+// Package certprobe connects to TLS endpoints and reports the certificates they serve.
+package certprobe
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Options configure a probe, the zero value dials ServerName:443 directly once
+// with a 10s timeout
+type Options struct {
+	// Address is the host:port to connect to instead of ServerName:443
+	Address string
+	// Proxy selects the HTTP CONNECT proxy, spoken to over TLS for https URLs,
+	// or for socks5 and socks5h URLs the SOCKS5 proxy, nil connects directly
+	Proxy func(*http.Request) (*url.URL, error)
+	// TLSConfig is cloned for every attempt, its ServerName is overridden
+	TLSConfig *tls.Config
+	// Timeout bounds connecting and the TLS handshake of each attempt
+	Timeout time.Duration
+	// Attempts is the number of connection attempts, waiting a second longer
+	// after every failed attempt
+	Attempts int
+	// AllowHostnameMismatch still verifies the chain but returns a certificate
+	// not valid for ServerName with HostnameMismatch set instead of failing
+	AllowHostnameMismatch bool
+}
+
+// Result describes what the endpoint served
+type Result struct {
+	// State is the TLS connection state including the stapled OCSP response
+	State tls.ConnectionState
+	// Certificate is the leaf certificate
+	Certificate *x509.Certificate
+	// Chain are the certificates served after the leaf
+	Chain    []*x509.Certificate
+	Issuer   string
+	Serial   string
+	DNSNames []string
+	NotAfter time.Time
+	// HostnameMismatch is set when the certificate is not valid for the server
+	// name and Options.AllowHostnameMismatch is set
+	HostnameMismatch bool
+}
+
+// ErrHostnameMismatch is returned when the served certificate is not valid for
+// the server name, it is not retried
+var ErrHostnameMismatch = errors.New("hostname doesn't match with certificate")
+
+// Probe connects to the endpoint of serverName and returns the served certificates
+func Probe(ctx context.Context, serverName string, opts Options) (Result, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Attempts <= 0 {
+		opts.Attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		result, err := probeOnce(ctx, serverName, opts)
+		if err == nil || errors.Is(err, ErrHostnameMismatch) || attempt >= opts.Attempts {
+			return result, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
+
+func probeOnce(ctx context.Context, serverName string, opts Options) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	conn, err := dial(ctx, serverName, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	mismatch := false
+	if err := conn.VerifyHostname(serverName); err != nil {
+		if !opts.AllowHostnameMismatch {
+			return Result{}, fmt.Errorf("%w: %v", ErrHostnameMismatch, err)
+		}
+		mismatch = true
+	}
+
+	state := conn.ConnectionState()
+	cert := state.PeerCertificates[0]
+	return Result{
+		State:            state,
+		Certificate:      cert,
+		Chain:            state.PeerCertificates[1:],
+		Issuer:           cert.Issuer.String(),
+		Serial:           cert.SerialNumber.String(),
+		DNSNames:         cert.DNSNames,
+		NotAfter:         cert.NotAfter,
+		HostnameMismatch: mismatch,
+	}, nil
+}
+
+// dial opens the TLS connection, tunneling through an HTTP CONNECT proxy when
+// the proxy selects one for the address
+func dial(ctx context.Context, serverName string, opts Options) (*tls.Conn, error) {
+	address := opts.Address
+	if address == "" {
+		address = net.JoinHostPort(serverName, "443")
+	}
+	tlsConfig := &tls.Config{}
+	if opts.TLSConfig != nil {
+		tlsConfig = opts.TLSConfig.Clone()
+	}
+	tlsConfig.ServerName = serverName
+	if opts.AllowHostnameMismatch && !tlsConfig.InsecureSkipVerify {
+		// the hostname is checked after the handshake, the chain still has to verify
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = verifyChain(tlsConfig.RootCAs)
+	}
+
+	var proxyURL *url.URL
+	if opts.Proxy != nil {
+		var err error
+		proxyURL, err = opts.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: address}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to select proxy: %w", err)
+		}
+	}
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if proxyURL == nil {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+		}
+	} else if proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h" {
+		conn, err = dialSOCKS5(ctx, dialer, address, proxyURL)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		conn, err = dialProxy(ctx, dialer, proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		if err := connect(ctx, conn, address, proxyURL); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		var hostnameErr x509.HostnameError
+		if errors.As(err, &hostnameErr) {
+			return nil, fmt.Errorf("%w: %v", ErrHostnameMismatch, err)
+		}
+		return nil, fmt.Errorf("failed TLS handshake with %s: %w", address, err)
+	}
+
+	return tlsConn, nil
+}
+
+// verifyChain verifies the served chain against roots without checking the
+// hostname, the system roots are used when roots is nil
+func verifyChain(roots *x509.CertPool) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("no certificate was served")
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+		return err
+	}
+}
+
+// proxyAddress returns the host:port of the proxy, adding the default port of
+// its scheme when the URL has none
+func proxyAddress(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+
+	port := "80"
+	switch proxyURL.Scheme {
+	case "https":
+		port = "443"
+	case "socks5", "socks5h":
+		port = "1080"
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// dialProxy connects to the HTTP proxy, https proxies are spoken to over TLS
+func dialProxy(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL) (net.Conn, error) {
+	address := proxyAddress(proxyURL)
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", address, err)
+	}
+	if proxyURL.Scheme != "https" {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed TLS handshake with proxy %s: %w", address, err)
+	}
+
+	return tlsConn, nil
+}
+
+// dialSOCKS5 connects to address through the SOCKS5 proxy, which resolves the
+// hostname of the address
+func dialSOCKS5(ctx context.Context, forward *net.Dialer, address string, proxyURL *url.URL) (net.Conn, error) {
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", proxyAddress(proxyURL), auth, forward)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup SOCKS5 proxy %s: %w", proxyURL.Host, err)
+	}
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s through SOCKS5 proxy %s: %w", address, proxyURL.Host, err)
+	}
+
+	return conn, nil
+}
+
+// connect asks the proxy to tunnel conn to address
+func connect(ctx context.Context, conn net.Conn, address string, proxyURL *url.URL) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("failed to send CONNECT to proxy: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("failed to read CONNECT response from proxy: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy refused CONNECT to %s: %s", address, resp.Status)
+	}
+
+	return nil
+}
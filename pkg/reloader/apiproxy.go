@@ -0,0 +1,85 @@
+package reloader
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// apiProxyTransport sends the requests to fluentd pods through the pod proxy
+// subresource of the API server, for clusters whose network policies block
+// connections from the reloader to the fluentd pods. The host of the request
+// is the pod name and the container port.
+type apiProxyTransport struct {
+	apiServer *url.URL
+	namespace string
+	base      http.RoundTripper
+}
+
+func newAPIProxyTransport(cfg Config) (http.RoundTripper, error) {
+	base, err := rest.TransportFor(cfg.RESTConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create api server transport: %w", err)
+	}
+	apiServer, _, err := rest.DefaultServerURL(cfg.RESTConfig.Host, "", schema.GroupVersion{}, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse api server url: %w", err)
+	}
+
+	return apiProxyTransport{apiServer: apiServer, namespace: cfg.Namespace, base: base}, nil
+}
+
+func (t apiProxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	pod, port, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pod of %s: %w", req.URL, err)
+	}
+	name := pod + ":" + port
+	if req.URL.Scheme == "https" {
+		name = "https:" + name
+	}
+
+	proxied := req.Clone(req.Context())
+	proxied.URL.Scheme = t.apiServer.Scheme
+	proxied.URL.Host = t.apiServer.Host
+	proxied.URL.Path = strings.TrimSuffix(t.apiServer.Path, "/") +
+		fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/proxy%s", t.namespace, name, req.URL.Path)
+	proxied.URL.RawPath = ""
+	proxied.Host = ""
+
+	return t.base.RoundTrip(proxied)
+}
+
+// podClient returns a client reaching the fluentd pods, through the API server
+// when reloading via the API proxy
+func podClient(cfg Config, transport http.RoundTripper) *http.Client {
+	client := &http.Client{Timeout: cfg.RPCTimeout, Transport: transport}
+	if cfg.ReloadVia != ReloadViaAPIProxy {
+		return client
+	}
+
+	// the rest config was checked by Validate, a transport that cannot be
+	// built fails every request instead
+	proxy, err := newAPIProxyTransport(cfg)
+	if err != nil {
+		client.Transport = errorTransport{err}
+		return client
+	}
+	client.Transport = proxy
+
+	return client
+}
+
+// errorTransport fails every request, it is used when the transport could not be built
+type errorTransport struct {
+	err error
+}
+
+func (t errorTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
@@ -0,0 +1,35 @@
+package reloader
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errMassReload is returned when more of the discovered pods would be reloaded
+// at once than MaxReloadPercent allows
+var errMassReload = errors.New("mass reload blocked")
+
+// checkBlastRadius refuses to reload more than MaxReloadPercent of the
+// discovered pods in one run unless AllowMassReload is set, so a broken
+// comparison cannot reload the whole fleet at once
+func checkBlastRadius(config Config, reason string, fluentdTargets []target, discovered int) error {
+	if config.MaxReloadPercent <= 0 || config.AllowMassReload || reason == ReasonForced || discovered == 0 {
+		return nil
+	}
+	if len(fluentdTargets)*100 <= config.MaxReloadPercent*discovered {
+		return nil
+	}
+
+	return terminal(
+		fmt.Errorf("%w: %d of %d discovered pods would be reloaded, more than %d%%", errMassReload, len(fluentdTargets), discovered, config.MaxReloadPercent),
+		"pass --allow-mass-reload or raise FLUENTD_MAX_RELOAD_PERCENT if the reload is intended")
+}
+
+// reloadFailedReason is the event reason recorded for a failed reload
+func reloadFailedReason(err error) string {
+	if errors.Is(err, errMassReload) {
+		return reasonMassReloadBlocked
+	}
+
+	return reasonReloadFailed
+}
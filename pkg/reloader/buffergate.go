@@ -0,0 +1,109 @@
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// monitorPlugins is the body of monitor_agent's /api/plugins.json
+type monitorPlugins struct {
+	Plugins []struct {
+		PluginID              string `json:"plugin_id"`
+		BufferQueueLength     *int   `json:"buffer_queue_length"`
+		BufferTotalQueuedSize *int64 `json:"buffer_total_queued_size"`
+		RetryCount            *int   `json:"retry_count"`
+		EmitRecords           *int64 `json:"emit_records"`
+	} `json:"plugins"`
+}
+
+// bufferGateEnabled reports whether pods are checked for deep buffers before reloading
+func (c Config) bufferGateEnabled() bool {
+	return c.MaxBufferQueueLength > 0 || c.MaxRetryCount > 0
+}
+
+// gateBuffers splits the targets into the ones that can be reloaded and the
+// ones whose buffer queue or retry count exceeds the thresholds, reloading
+// those could lose the buffered events. A pod whose monitor_agent cannot be
+// queried is gated as well.
+func gateBuffers(ctx context.Context, cfg Config, targets []target) ([]target, []ReloadAction) {
+	ready := make([]target, 0, len(targets))
+	var gated []ReloadAction
+	for _, t := range targets {
+		if err := checkBuffers(ctx, cfg, t); err != nil {
+			log.Printf("Not reloading %s: %v", t, err)
+			gated = append(gated, ReloadAction{Target: t.String(), Outcome: OutcomeSkipped, Error: err.Error()})
+			continue
+		}
+		ready = append(ready, t)
+	}
+
+	return ready, gated
+}
+
+// monitorURL returns the URL of path on the monitor_agent of the target
+func monitorURL(cfg Config, t target, path string) (string, error) {
+	// targets discovered through the headless service have no pod
+	host, _, err := net.SplitHostPort(t.host)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse host of %s: %w", t, err)
+	}
+	if t.pod != nil {
+		host = podIP(*t.pod, cfg.IPFamily)
+	}
+
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(host, strconv.Itoa(cfg.MonitorPort)), path), nil
+}
+
+// getMonitorPlugins reads the plugin metrics of the monitor_agent of the target
+func getMonitorPlugins(ctx context.Context, cfg Config, t target) (monitorPlugins, error) {
+	plugins := monitorPlugins{}
+	url, err := monitorURL(cfg, t, "/api/plugins.json")
+	if err != nil {
+		return plugins, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return plugins, fmt.Errorf("failed to create monitor request: %w", err)
+	}
+	setRunIDHeader(req)
+
+	client := podClient(cfg, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return plugins, fmt.Errorf("failed to query monitor_agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return plugins, statusError(fmt.Errorf("failed to query monitor_agent: %s", resp.Status), resp.StatusCode, "")
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&plugins); err != nil {
+		return plugins, fmt.Errorf("failed to parse monitor_agent response: %w", err)
+	}
+
+	return plugins, nil
+}
+
+func checkBuffers(ctx context.Context, cfg Config, t target) error {
+	plugins, err := getMonitorPlugins(ctx, cfg, t)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range plugins.Plugins {
+		if cfg.MaxBufferQueueLength > 0 && p.BufferQueueLength != nil && *p.BufferQueueLength > cfg.MaxBufferQueueLength {
+			return fmt.Errorf("buffer queue length %d of plugin %s exceeds %d", *p.BufferQueueLength, p.PluginID, cfg.MaxBufferQueueLength)
+		}
+		if cfg.MaxRetryCount > 0 && p.RetryCount != nil && *p.RetryCount > cfg.MaxRetryCount {
+			return fmt.Errorf("retry count %d of plugin %s exceeds %d", *p.RetryCount, p.PluginID, cfg.MaxRetryCount)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const canaryPollInterval = 2 * time.Second
+
+// reloadWithCanary reloads the first target and verifies it before reloading the
+// remaining targets, a failed canary skips the rest of the fleet
+func (a app) reloadWithCanary(ctx context.Context, cfg Config, reloader Reloader, pace pacer, expected time.Time, targets ...target) ([]ReloadAction, error) {
+	canary, rest := targets[0], targets[1:]
+	log.Println("Reloading canary", canary)
+
+	actions, err := reloadFluentdConfig(ctx, reloader, pace, canary)
+	if err == nil {
+		err = a.verifyCanary(ctx, cfg, canary, expected)
+	}
+	if err != nil {
+		for _, skipped := range rest {
+			actions = append(actions, ReloadAction{Target: skipped.String(), Outcome: OutcomeSkipped})
+		}
+
+		return actions, fmt.Errorf("canary %s failed, %d pods not reloaded: %w", canary, len(rest), err)
+	}
+
+	restActions, err := reloadFluentdConfig(ctx, reloader, pace, rest...)
+	return append(actions, restActions...), err
+}
+
+// verifyCanary waits until the canary pod serves the expected certificate and
+// its health endpoint responds
+func (a app) verifyCanary(ctx context.Context, cfg Config, canary target, expected time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.CanaryTimeout)
+	defer cancel()
+
+	var err error
+	for {
+		if err = a.checkCanary(ctx, cfg, canary, expected); err == nil {
+			log.Println("Canary", canary, "verified")
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to verify canary within %v: %w", cfg.CanaryTimeout, err)
+		case <-time.After(canaryPollInterval):
+		}
+	}
+}
+
+func (a app) checkCanary(ctx context.Context, cfg Config, canary target, expected time.Time) error {
+	// the pod is fetched again as its IP changes when the reload restarted it
+	pod, err := a.client.CoreV1().Pods(a.namespace).Get(ctx, canary.pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get canary pod: %w", err)
+	}
+	ip := podIP(*pod, cfg.IPFamily)
+	if ip == "" {
+		return fmt.Errorf("canary pod %s has no IP", pod.Name)
+	}
+
+	tlsConfig, err := probeTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	served, err := probe(ctx, cfg, cfg.ServiceURL, net.JoinHostPort(ip, strconv.Itoa(cfg.CanaryTLSPort)), ProxyNone, tlsConfig)
+	if err != nil {
+		return err
+	}
+	if !cfg.NotAfterMatches(served.NotAfter, expected) {
+		return fmt.Errorf("canary serves a certificate expiring %v instead of %v", served.NotAfter, expected)
+	}
+
+	if cfg.CanaryHealthPort == 0 {
+		return nil
+	}
+
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(ip, strconv.Itoa(cfg.CanaryHealthPort)), cfg.CanaryHealthPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create health request: %w", err)
+	}
+	setRunIDHeader(req)
+
+	client := podClient(cfg, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to check canary health: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(fmt.Errorf("canary health check returned %s", resp.Status), resp.StatusCode, "")
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package reloader
+
+import (
+	"fmt"
+	"sync"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// certManagerVersions are the cert-manager.io versions whose Certificates can be
+// read, in order of preference. The fields the reloader uses are the same in
+// all of them.
+var certManagerVersions = []string{"v1", "v1beta1", "v1alpha3", "v1alpha2"}
+
+var (
+	certManagerVersionsMu sync.Mutex
+	// discoveredVersions caches the version per client
+	discoveredVersions = map[kubernetes.Interface]string{}
+)
+
+// certManagerVersion returns the preferred cert-manager.io version the cluster
+// serves, older clusters may still only serve v1alpha2
+func certManagerVersion(client kubernetes.Interface) (string, error) {
+	certManagerVersionsMu.Lock()
+	defer certManagerVersionsMu.Unlock()
+	if version, ok := discoveredVersions[client]; ok {
+		return version, nil
+	}
+
+	groups, err := client.Discovery().ServerGroups()
+	if err != nil {
+		return "", fmt.Errorf("failed to discover API groups: %w", err)
+	}
+
+	for _, group := range groups.Groups {
+		if group.Name != cmapi.SchemeGroupVersion.Group {
+			continue
+		}
+
+		served := map[string]bool{}
+		for _, v := range group.Versions {
+			served[v.Version] = true
+		}
+		// the server's preferred version wins when it is one we can read
+		candidates := append([]string{group.PreferredVersion.Version}, certManagerVersions...)
+		for _, version := range candidates {
+			if served[version] && supportedCertManagerVersion(version) {
+				discoveredVersions[client] = version
+				return version, nil
+			}
+		}
+
+		return "", fmt.Errorf("cert-manager serves none of the supported versions %v", certManagerVersions)
+	}
+
+	return "", fmt.Errorf("the %s API is not installed, set FLUENTD_SECRET_NAME to compare against a TLS secret instead", cmapi.SchemeGroupVersion.Group)
+}
+
+// forgetCertManagerVersion drops the cached version, e.g. after cert-manager was upgraded
+func forgetCertManagerVersion(client kubernetes.Interface) {
+	certManagerVersionsMu.Lock()
+	defer certManagerVersionsMu.Unlock()
+	delete(discoveredVersions, client)
+}
+
+func supportedCertManagerVersion(version string) bool {
+	for _, v := range certManagerVersions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// certificatesPath returns the API path of the certificates in the namespace
+func certificatesPath(client kubernetes.Interface, namespace string) (string, error) {
+	version, err := certManagerVersion(client)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s/certificates", cmapi.SchemeGroupVersion.Group, version, namespace), nil
+}
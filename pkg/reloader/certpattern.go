@@ -0,0 +1,89 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// annotations on a Certificate matched by a cert name pattern, mapping it to its fluentd pods
+const (
+	selectorAnnotation   = "fluentd-reloader.io/selector"
+	serviceURLAnnotation = "fluentd-reloader.io/service-url"
+)
+
+// IsCertNamePattern reports whether the cert name matches several
+// Certificates, either as a glob like fluentd-*-tls or as a regular
+// expression enclosed in slashes like /^fluentd-.+-tls$/
+func IsCertNamePattern(name string) bool {
+	return strings.ContainsAny(name, "*?[") || isRegexpPattern(name)
+}
+
+func isRegexpPattern(name string) bool {
+	return len(name) > 1 && strings.HasPrefix(name, "/") && strings.HasSuffix(name, "/")
+}
+
+// certNameMatcher returns the match function of a cert name pattern
+func certNameMatcher(pattern string) (func(string) bool, error) {
+	if isRegexpPattern(pattern) {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cert name pattern %s: %w", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid cert name pattern %s: %w", pattern, err)
+	}
+	return func(name string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}, nil
+}
+
+// certPatternTargets returns a target for every Certificate matching the cert
+// name pattern. The fluentd pods and the service URL of a Certificate are read
+// from its annotations and fall back to the config.
+func certPatternTargets(ctx context.Context, cfg Config) ([]Config, error) {
+	match, err := certNameMatcher(cfg.CertName)
+	if err != nil {
+		return nil, terminal(err, "fix FLUENTD_CERT_NAME")
+	}
+
+	certificates, err := listCertificates(ctx, cfg.Client, cfg.CertNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []Config{}
+	for _, cert := range certificates {
+		if !match(cert.Name) {
+			continue
+		}
+
+		target := cfg
+		target.Name, target.CertName = cert.Name, cert.Name
+		if selector := cert.Annotations[selectorAnnotation]; selector != "" {
+			target.Selector = selector
+		}
+		if serviceURL := cert.Annotations[serviceURLAnnotation]; serviceURL != "" {
+			target.ServiceURL, target.ServiceURLs = serviceURL, nil
+		}
+		if target.ServiceURL == "" && target.CheckMode != CheckModeSecretRevision {
+			log.Printf("Certificate %s matches %s but has no %s annotation, skipping it", cert.Name, cfg.CertName, serviceURLAnnotation)
+			continue
+		}
+
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		return nil, terminal(fmt.Errorf("no certificate in namespace %s matches %s", cfg.CertNamespace, cfg.CertName),
+			"check FLUENTD_CERT_NAME and FLUENTD_CERT_NAMESPACE")
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,151 @@
+package reloader
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CertSource provides the certificate fluentd is expected to serve. Sources
+// without cert-manager describe it as a Certificate with Status.NotAfter set,
+// so every source goes through the same comparison and reload pipeline.
+type CertSource interface {
+	// Certificate returns the expected certificate
+	Certificate(ctx context.Context) (cmapi.Certificate, error)
+	// Chain returns the expected certificate chain, the leaf comes first
+	Chain(ctx context.Context, cert cmapi.Certificate) ([]*x509.Certificate, error)
+}
+
+// certSource returns the configured source: an injected one, a file, a plain
+// TLS secret or the cert-manager Certificate
+func (a app) certSource() CertSource {
+	switch {
+	case a.source != nil:
+		return a.source
+	case a.certFile != "":
+		return fileSource{path: a.certFile}
+	case a.secretName != "":
+		return secretSource{a}
+	default:
+		return certManagerSource{a}
+	}
+}
+
+func (a app) getCertificate(ctx context.Context) (cmapi.Certificate, error) {
+	return a.certSource().Certificate(ctx)
+}
+
+func (a app) getCertificateChain(ctx context.Context, cert cmapi.Certificate) ([]*x509.Certificate, error) {
+	return a.certSource().Chain(ctx, cert)
+}
+
+// certManagerSource reads cert-manager Certificates and their secrets
+type certManagerSource struct {
+	app app
+}
+
+func (s certManagerSource) Certificate(ctx context.Context) (cmapi.Certificate, error) {
+	a := s.app
+	certificates, err := listCertificates(ctx, a.client, a.certNamespace)
+	if err != nil {
+		return cmapi.Certificate{}, err
+	}
+
+	for _, cert := range certificates {
+		if strings.EqualFold(cert.Name, a.certName) {
+			return cert, nil
+		}
+
+		log.Printf("Certificate %s is not fluentd cerificate", cert.Name)
+	}
+
+	return cmapi.Certificate{}, terminal(fmt.Errorf("failed to find fluentd certificate %s in namespace %s", a.certName, a.certNamespace),
+		"check FLUENTD_CERT_NAME and FLUENTD_CERT_NAMESPACE")
+}
+
+// listCertificates returns the cert-manager Certificates of the namespace
+func listCertificates(ctx context.Context, client kubernetes.Interface, namespace string) ([]cmapi.Certificate, error) {
+	uri, err := certificatesPath(client, namespace)
+	if err != nil {
+		return nil, err
+	}
+	b, err := client.Discovery().RESTClient().Get().AbsPath(uri).DoRaw(ctx)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// the served versions changed since they were discovered
+			forgetCertManagerVersion(client)
+		}
+
+		return nil, fmt.Errorf("failed to get certificates: %w", err)
+	}
+
+	// decoded as JSON as the older versions are not registered in a scheme
+	certificates := cmapi.CertificateList{}
+	if err := json.Unmarshal(b, &certificates); err != nil {
+		return nil, fmt.Errorf("failed to parse certificates: %w", err)
+	}
+
+	return certificates.Items, nil
+}
+
+func (s certManagerSource) Chain(ctx context.Context, cert cmapi.Certificate) ([]*x509.Certificate, error) {
+	return s.app.getSecretCertificates(ctx, cert)
+}
+
+// secretSource reads plain TLS secrets, used when certificates are not issued
+// by cert-manager
+type secretSource struct {
+	app app
+}
+
+func (s secretSource) Certificate(ctx context.Context) (cmapi.Certificate, error) {
+	return s.app.certificateFromSecret(ctx)
+}
+
+func (s secretSource) Chain(ctx context.Context, cert cmapi.Certificate) ([]*x509.Certificate, error) {
+	return s.app.getSecretCertificates(ctx, cert)
+}
+
+// fileSource reads a PEM file, e.g. written by a Vault agent sidecar
+type fileSource struct {
+	path string
+}
+
+func (s fileSource) Certificate(ctx context.Context) (cmapi.Certificate, error) {
+	cert := cmapi.Certificate{ObjectMeta: metav1.ObjectMeta{Name: filepath.Base(s.path)}}
+	certs, err := s.Chain(ctx, cert)
+	if err != nil {
+		return cmapi.Certificate{}, err
+	}
+	notAfter := metav1.NewTime(certs[0].NotAfter)
+	cert.Status.NotAfter = &notAfter
+
+	return cert, nil
+}
+
+func (s fileSource) Chain(_ context.Context, _ cmapi.Certificate) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s has no certificate", s.path)
+	}
+
+	return certs, nil
+}
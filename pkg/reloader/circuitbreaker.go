@@ -0,0 +1,158 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// circuit counts the consecutive failed reloads of a pod, once open the pod
+// is not reloaded again until the cooldown passed
+type circuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+// circuits outlive a single check so failures are counted across checks
+var circuits = struct {
+	sync.Mutex
+	pods map[string]*circuit
+}{pods: map[string]*circuit{}}
+
+func circuitKey(a app, cfg Config, t target) string {
+	return cfg.Cluster + "/" + a.namespace + "/" + t.String()
+}
+
+// gateCircuits splits the targets into the ones that can be reloaded and the
+// ones with an open circuit, which are restarted when CircuitRestart is set
+// and skipped otherwise
+func gateCircuits(a app, cfg Config, targets []target) ([]target, []target, []ReloadAction) {
+	circuits.Lock()
+	defer circuits.Unlock()
+
+	now := time.Now()
+	closed := make([]target, 0, len(targets))
+	var restart []target
+	var skipped []ReloadAction
+	for _, t := range targets {
+		c := circuits.pods[circuitKey(a, cfg, t)]
+		if c == nil || !now.Before(c.openUntil) {
+			closed = append(closed, t)
+			continue
+		}
+
+		if cfg.CircuitRestart && t.pod != nil {
+			log.Printf("Circuit of %s is open, restarting it instead", t)
+			restart = append(restart, t)
+			continue
+		}
+		log.Printf("Circuit of %s is open until %v, not reloading it", t, c.openUntil)
+		skipped = append(skipped, ReloadAction{
+			Target:  t.String(),
+			Outcome: OutcomeCircuitOpen,
+			Error:   fmt.Sprintf("circuit open until %s after %d failed reloads", c.openUntil.UTC().Format(time.RFC3339), c.failures),
+		})
+	}
+
+	return closed, restart, skipped
+}
+
+// recordCircuits counts the failed reloads of every target and opens the
+// circuit of the ones failing CircuitFailures times in a row
+func recordCircuits(ctx context.Context, a app, cfg Config, targets []target, actions []ReloadAction) {
+	byName := make(map[string]target, len(targets))
+	for _, t := range targets {
+		byName[t.String()] = t
+	}
+
+	circuits.Lock()
+	defer circuits.Unlock()
+
+	for _, action := range actions {
+		t, ok := byName[action.Target]
+		if !ok {
+			continue
+		}
+		key := circuitKey(a, cfg, t)
+
+		switch action.Outcome {
+		case OutcomeReloaded:
+			delete(circuits.pods, key)
+		case OutcomeFailed:
+			c := circuits.pods[key]
+			if c == nil {
+				c = &circuit{}
+				circuits.pods[key] = c
+			}
+			c.failures++
+			if c.failures < cfg.CircuitFailures {
+				continue
+			}
+
+			c.openUntil = time.Now().Add(cfg.CircuitCooldown)
+			log.Printf("Reloading %s failed %d times in a row, opening its circuit until %v", t, c.failures, c.openUntil)
+			if t.pod != nil {
+				message := fmt.Sprintf("Reloading fluentd failed %d times in a row, not reloading it until %s: %s",
+					c.failures, c.openUntil.UTC().Format(time.RFC3339), action.Error)
+				if err := a.recordPodEvent(ctx, *t.pod, reasonCircuitOpened, message); err != nil {
+					log.Printf("Failed to record the open circuit of %s: %v", t, err)
+				}
+			}
+		}
+	}
+}
+
+// openCircuits returns the targets whose circuit is open
+func openCircuits(a app, cfg Config, targets []target) []string {
+	circuits.Lock()
+	defer circuits.Unlock()
+
+	now := time.Now()
+	var open []string
+	for _, t := range targets {
+		if c := circuits.pods[circuitKey(a, cfg, t)]; c != nil && now.Before(c.openUntil) {
+			open = append(open, t.String())
+		}
+	}
+
+	return open
+}
+
+// recordPodEvent creates a warning event on the fluentd pod
+func (a app) recordPodEvent(ctx context.Context, pod corev1.Pod, reason, message string) error {
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pod.Name + "-",
+			Namespace:    pod.Namespace,
+			Annotations:  runIDAnnotations(ctx),
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion:      "v1",
+			Kind:            "Pod",
+			Name:            pod.Name,
+			Namespace:       pod.Namespace,
+			UID:             pod.UID,
+			ResourceVersion: pod.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: "fluentd-reloader"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	_, err := a.client.CoreV1().Events(pod.Namespace).Create(ctx, event, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create event for pod %s: %w", pod.Name, err)
+	}
+
+	return nil
+}
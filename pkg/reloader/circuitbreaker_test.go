@@ -0,0 +1,78 @@
+package reloader
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCircuits(t *testing.T) {
+	tests := []struct {
+		name    string
+		restart bool
+		// outcomes are the reload outcomes of fluentd-0 in consecutive checks
+		outcomes []string
+		wantOpen bool
+	}{
+		{name: "reloaded", outcomes: []string{OutcomeReloaded}},
+		{name: "below the threshold", outcomes: []string{OutcomeFailed, OutcomeFailed}},
+		{name: "failing in a row", outcomes: []string{OutcomeFailed, OutcomeFailed, OutcomeFailed}, wantOpen: true},
+		{name: "reload resets the failures", outcomes: []string{OutcomeFailed, OutcomeFailed, OutcomeReloaded, OutcomeFailed}},
+		{name: "skipped reloads are not counted", outcomes: []string{OutcomeFailed, OutcomeSkipped, OutcomeFailed}},
+		{name: "restart", restart: true, outcomes: []string{OutcomeFailed, OutcomeFailed, OutcomeFailed}, wantOpen: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			a := app{namespace: "logging", client: client}
+			// every case has its own cluster so the circuits do not leak between them
+			cfg := Config{Cluster: t.Name(), CircuitFailures: 3, CircuitCooldown: time.Hour, CircuitRestart: tt.restart}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "fluentd-0", Namespace: "logging"}}
+			targets := []target{{host: "10.0.0.1:24444", pod: pod}, {host: "10.0.0.2:24444"}}
+
+			for _, outcome := range tt.outcomes {
+				recordCircuits(context.Background(), a, cfg, targets, []ReloadAction{{Target: "fluentd-0", Outcome: outcome}})
+			}
+
+			closed, restart, skipped := gateCircuits(a, cfg, targets)
+			open := openCircuits(a, cfg, targets)
+			events, err := client.CoreV1().Events("logging").List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.wantOpen {
+				if len(closed) != 2 || len(restart) != 0 || len(skipped) != 0 || len(open) != 0 {
+					t.Errorf("gateCircuits() = %v, %v, %v, open %v, want every target closed", closed, restart, skipped, open)
+				}
+				if len(events.Items) != 0 {
+					t.Errorf("recorded %d events, want none", len(events.Items))
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(open, []string{"fluentd-0"}) {
+				t.Errorf("openCircuits() = %v, want [fluentd-0]", open)
+			}
+			if len(closed) != 1 || closed[0].pod != nil {
+				t.Errorf("gateCircuits() closed = %v, want only the target without a pod", closed)
+			}
+			if tt.restart {
+				if len(restart) != 1 || len(skipped) != 0 {
+					t.Errorf("gateCircuits() = restart %v, skipped %v, want fluentd-0 restarted", restart, skipped)
+				}
+			} else if len(skipped) != 1 || skipped[0].Outcome != OutcomeCircuitOpen || len(restart) != 0 {
+				t.Errorf("gateCircuits() = restart %v, skipped %v, want fluentd-0 skipped", restart, skipped)
+			}
+			if len(events.Items) != 1 || events.Items[0].Reason != reasonCircuitOpened {
+				t.Errorf("recorded %v, want one %s event", events.Items, reasonCircuitOpened)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// StatusCleanedUp means the state the reloader recorded for the target was removed
+const StatusCleanedUp = "cleaned-up"
+
+// Cleanup removes the annotations the reloader recorded on the fluentd pods, the
+// Certificates and the StatefulSets of every configured target and deletes the
+// report history ConfigMap, so uninstalling the reloader or dropping a target
+// leaves no orphaned state behind. Events are left to expire.
+func Cleanup(ctx context.Context, cfg Config) (Report, error) {
+	if err := cfg.Validate(); err != nil {
+		return Report{}, err
+	}
+
+	cfg = cfg.withDefaults()
+	report, err := runTargets(ctx, cfg, cleanup)
+
+	if cfg.ReportHistoryConfigMap != "" {
+		err := cfg.Client.CoreV1().ConfigMaps(cfg.Namespace).Delete(ctx, cfg.ReportHistoryConfigMap, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return report, fmt.Errorf("failed to delete configmap %s: %w", cfg.ReportHistoryConfigMap, err)
+		}
+		log.Printf("Deleted report history configmap %s", cfg.ReportHistoryConfigMap)
+	}
+
+	return report, err
+}
+
+// cleanup removes the annotations recorded for a single target
+func cleanup(ctx context.Context, app app, config Config) (TargetReport, error) {
+	s := TargetReport{}
+
+	pods, err := app.getFluentdPods(ctx)
+	if err != nil {
+		return s, err
+	}
+	for _, pod := range pods {
+		if err := app.removePodAnnotations(ctx, pod.Name, pod.Annotations); err != nil {
+			return s, err
+		}
+	}
+
+	if app.certName != "" && app.certFile == "" && app.source == nil {
+		cert, err := app.getCertificate(ctx)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return s, err
+		}
+		if err == nil {
+			remove := removedAnnotations(cert.Annotations, lastVerifiedAnnotation, lastReloadAnnotation)
+			if len(remove) > 0 {
+				if err := app.annotateCertificate(ctx, cert, remove); err != nil {
+					return s, err
+				}
+				log.Printf("Removed %d annotations from certificate %s", len(remove), cert.Name)
+			}
+		}
+	}
+
+	if app.statefulSet != "" {
+		if err := app.removeStatefulSetAnnotations(ctx); err != nil {
+			return s, err
+		}
+	}
+
+	if config.StatusResource {
+		fr, err := app.deleteFluentdReload(ctx, fluentdReloadName(config))
+		if err != nil {
+			return s, err
+		}
+		if fr != nil {
+			if err := app.releaseFluentdReload(ctx, *fr); err != nil {
+				return s, err
+			}
+		}
+	}
+
+	s.Status = StatusCleanedUp
+	return s, nil
+}
+
+// removedAnnotations returns a merge patch of the given annotations that are set,
+// a null value removes an annotation
+func removedAnnotations(annotations map[string]string, keys ...string) map[string]interface{} {
+	remove := map[string]interface{}{}
+	for _, key := range keys {
+		if _, ok := annotations[key]; ok {
+			remove[key] = nil
+		}
+	}
+
+	return remove
+}
+
+func annotationsPatch(annotations map[string]interface{}) ([]byte, error) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build annotation patch: %w", err)
+	}
+
+	return patch, nil
+}
+
+func (a app) removePodAnnotations(ctx context.Context, name string, annotations map[string]string) error {
+	remove := removedAnnotations(annotations, certFingerprintAnnotation, lastReloadAnnotation, runIDAnnotation)
+	if len(remove) == 0 {
+		return nil
+	}
+	patch, err := annotationsPatch(remove)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.client.CoreV1().Pods(a.namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to remove annotations from pod %s: %w", name, err)
+	}
+	log.Printf("Removed %d annotations from pod %s", len(remove), name)
+
+	return nil
+}
+
+func (a app) removeStatefulSetAnnotations(ctx context.Context) error {
+	sts, err := a.client.AppsV1().StatefulSets(a.namespace).Get(ctx, a.statefulSet, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get statefulset %s: %w", a.statefulSet, err)
+	}
+
+	remove := removedAnnotations(sts.Annotations, certInSyncAnnotation, certSyncConditionAnnotation)
+	if len(remove) == 0 {
+		return nil
+	}
+	patch, err := annotationsPatch(remove)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.client.AppsV1().StatefulSets(a.namespace).Patch(ctx, a.statefulSet, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to remove annotations from statefulset %s: %w", a.statefulSet, err)
+	}
+	log.Printf("Removed %d annotations from statefulset %s", len(remove), a.statefulSet)
+
+	return nil
+}
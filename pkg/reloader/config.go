@@ -0,0 +1,740 @@
+package reloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Version is the reloader version sent in the default User-Agent, it is set at
+// build time with -ldflags "-X github.com/donchev7/fluentd-reloader/pkg/reloader.Version=..."
+var Version = "dev"
+
+// ReloadVia values select how fluentd pods are reached
+const (
+	ReloadViaPodIP   = "pod-ip"
+	ReloadViaPodDNS  = "pod-dns"
+	ReloadViaService = "service"
+	// ReloadViaAPIProxy calls fluentd through the pod proxy of the API server
+	ReloadViaAPIProxy = "api-proxy"
+)
+
+// IPFamily values select the preferred pod IP on dual-stack clusters
+const (
+	IPFamilyIPv4 = "ipv4"
+	IPFamilyIPv6 = "ipv6"
+)
+
+// HTTPClient sends the reload requests, *http.Client satisfies it
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Config describes a reloader target and how it is checked and reloaded.
+// Zero values are replaced by the defaults documented on each field.
+type Config struct {
+	// Client is used for all Kubernetes API calls
+	Client kubernetes.Interface
+	// RESTConfig is needed by the exec-signal strategy and the probe port-forward
+	RESTConfig *rest.Config
+	// PodCache serves the fluentd pods from informers instead of listing them
+	PodCache *PodCache
+	// Daemon checks the targets every interval, serving the pods from a PodCache
+	Daemon bool
+	// WatchEvents also checks the targets when their Certificate or secret changes
+	WatchEvents bool
+	// Paused suspends reloads while it returns true, targets are still checked
+	Paused func() bool
+	// JobTemplate makes Watch create a job from it for every check instead of
+	// checking the target itself, the job runs the one-shot reloader
+	JobTemplate *batchv1.Job
+	// HTTPClient sends the fluentd RPC requests, defaults to a client using RPCTimeout and RPCProxy
+	HTTPClient HTTPClient
+
+	// Cluster names the cluster in logs and reports when several are checked
+	Cluster string
+	// Name identifies the target in logs and reports, defaults to CertName
+	Name       string
+	ServiceURL string
+	// ServiceURLs are probed in addition to ServiceURL, e.g. per load balancer,
+	// fluentd is reloaded when any of them serves a stale certificate
+	ServiceURLs []string
+	CertName    string
+	// SecretName compares against a plain TLS secret in CertNamespace instead
+	// of a cert-manager Certificate, for certificates from an external PKI
+	SecretName string
+	// SecretManager is SecretManagerExternalSecrets or SecretManagerSealedSecrets
+	// when SecretName is written by one of them, the secret is only compared
+	// once it is synced or SecretSyncTimeout passed
+	SecretManager     string
+	SecretSyncTimeout time.Duration
+	// CertFile compares against a PEM file instead, e.g. written by a Vault agent sidecar
+	CertFile string
+	// CertSource overrides the source of the expected certificate
+	CertSource CertSource
+	// CertNamespace is the namespace of the Certificate, defaults to Namespace.
+	// CertName may also be given as namespace/name, and as a glob or a regular
+	// expression enclosed in slashes to check every matching Certificate.
+	CertNamespace string
+	Namespace     string
+	// Selector is the label selector of the fluentd pods, defaults to app=<Namespace>
+	Selector string
+	// ReleaseName selects the pods of a Helm release by the standard
+	// app.kubernetes.io/instance label, Selector narrows it further e.g. by
+	// app.kubernetes.io/component
+	ReleaseName string
+	// SelectorFromService selects the fluentd pods with the selector of this
+	// Service, given as name or namespace/name, or SelectorFromServiceURL to
+	// derive the Service from ServiceURL. Selector narrows it further.
+	SelectorFromService string
+	// StatefulSetName discovers the fluentd pods by their owning StatefulSet
+	// instead of Selector
+	StatefulSetName string
+	// ForwarderSelector selects the forwarder pods, e.g. of a DaemonSet, that are
+	// reloaded in a second wave after the aggregators so they reconnect with TLS
+	ForwarderSelector string
+	// ForwarderNamespace defaults to Namespace
+	ForwarderNamespace string
+	// ForwarderRPCPort defaults to RPCPort
+	ForwarderRPCPort int
+	// ForwarderReloadStrategy defaults to ReloadStrategy
+	ForwarderReloadStrategy string
+	// ForwarderWaveDelay is waited after the aggregators were reloaded
+	ForwarderWaveDelay time.Duration
+	// FieldSelector, NodeName and Zone narrow the pods matched by the selector,
+	// e.g. to status.phase=Running or to the aggregators of one zone
+	FieldSelector string
+	NodeName      string
+	Zone          string
+	// Shards splits the fluentd pods between replicas by hashing the pod names,
+	// every replica only reloads the pods of its ShardIndex
+	Shards     int
+	ShardIndex int
+	// ReloadOnHostnameMismatch reloads fluentd when it serves a certificate not
+	// valid for the service URL, e.g. of an old domain, instead of failing the
+	// check, as long as the expected certificate is valid for it
+	ReloadOnHostnameMismatch bool
+	// ConfigMapName is the ConfigMap holding the fluentd config, pods running a
+	// different config are reloaded even when they serve the right certificate
+	ConfigMapName string
+	// ConfigMapKey is the key of the fluentd config in the ConfigMap
+	ConfigMapKey string
+	// ReportHistoryConfigMap stores the reports of the last ReportHistoryLimit
+	// runs in a ConfigMap in Namespace
+	ReportHistoryConfigMap string
+	ReportHistoryLimit     int
+	// CircuitFailures opens the circuit of a pod after this many failed reloads
+	// in a row, it is not reloaded again for CircuitCooldown, disabled when 0
+	CircuitFailures int
+	CircuitCooldown time.Duration
+	// CircuitRestart restarts pods with an open circuit with the pod-delete
+	// strategy instead of skipping them
+	CircuitRestart bool
+	// ReloadWindow defers reloads outside of it, reloads are always allowed when nil
+	ReloadWindow *ReloadWindow
+	// SyncCondition annotates the StatefulSet of StatefulSetName with the outcome
+	// of every check
+	SyncCondition bool
+	// StatusResource writes the outcome of every check to the status of the
+	// FluentdReload named after the target, creating it when missing
+	StatusResource bool
+	// TargetsConfigMap lists the targets to check instead of this single target
+	TargetsConfigMap string
+
+	// RPCMethod is GET or POST, defaults to GET
+	RPCMethod string
+	// RPCTimeout defaults to 5s
+	RPCTimeout time.Duration
+	// RPCHeaders are added to every fluentd RPC request, e.g. for routing
+	// through an ingress
+	RPCHeaders http.Header
+	// UserAgent of the fluentd RPC requests, defaults to fluentd-reloader/Version
+	UserAgent string
+	// RPCPort defaults to 24444
+	RPCPort int
+	// RPCWorkers reloads every worker of a multi-worker fluentd on its own
+	// endpoint, listening on the RPC port plus the worker id
+	RPCWorkers int
+	// RPCPortName takes precedence over RPCPort and is looked up in the pod spec
+	RPCPortName string
+	// RunDeadline bounds the whole run, zero means no deadline
+	RunDeadline time.Duration
+	// CheckMode defaults to CheckModeTLSProbe
+	CheckMode string
+	// ReloadVia defaults to ReloadViaPodIP
+	ReloadVia string
+	// HeadlessService is the governing service of the fluentd statefulset
+	HeadlessService string
+	// IPFamily is the preferred pod IP family on dual-stack clusters
+	IPFamily string
+	// ReloadURLTemplate is a text/template of the fluentd RPC reload URL evaluated
+	// per pod, with .Host, .PodName, .PodIP, .Port, .Labels and .Annotations
+	ReloadURLTemplate string
+	// ReloadStrategy defaults to StrategyFluentdRPC
+	ReloadStrategy string
+	// VerifyConfigDump confirms every fluentd RPC reload by reading the running
+	// config with config.getDump and records its hash in the report
+	VerifyConfigDump bool
+	// FallbackStrategy is StrategyExecSignal or StrategyPodDelete and reloads
+	// fluentd builds that support neither config.gracefulReload nor config.reload
+	FallbackStrategy string
+	// ContainerName is the fluentd container used by the exec-signal strategy,
+	// to read MountedCertPath and to execute hook commands
+	ContainerName string
+	// PreReloadHook and PostReloadHook run before and after every pod is reloaded
+	PreReloadHook  *ReloadHook
+	PostReloadHook *ReloadHook
+	// MountedCertPath is the certificate file of the secret volume in the fluentd
+	// container, when set it is read before reloading and pods that do not have
+	// the expected certificate mounted yet are not reloaded
+	MountedCertPath string
+	// DisruptionWait bounds how long the pod-delete strategy waits for the
+	// disruption budget to allow evicting a pod, defaults to 5m
+	DisruptionWait time.Duration
+	// ReloadSignal is sent by the exec-signal strategy, defaults to USR2
+	ReloadSignal string
+
+	// AnnotatePods records the reloaded certificate on the fluentd pods
+	AnnotatePods bool
+	// RecordHistory creates events and annotations on the certificate
+	RecordHistory bool
+	// OrderedReload reloads the pods in descending StatefulSet ordinal order,
+	// pods with an ordinal below ReloadPartition are not reloaded
+	OrderedReload   bool
+	ReloadPartition int
+	// ReloadPause is waited between reloading two pods
+	ReloadPause time.Duration
+	// AdaptivePacing lengthens the pause up to MaxReloadPause while the buffers
+	// of the pods still to be reloaded grow, read from their monitor_agent
+	AdaptivePacing bool
+	// MaxReloadPause bounds the adaptive pause, defaults to 2m
+	MaxReloadPause time.Duration
+	// MaxReloadPercent aborts a run that would reload more than this share of
+	// the discovered pods unless AllowMassReload is set, 0 disables the guard
+	MaxReloadPercent int
+	AllowMassReload  bool
+
+	// ForwardCheck verifies the forward input of every reloaded target accepts
+	// connections again, on ForwardPort which defaults to 24224
+	ForwardCheck bool
+	ForwardPort  int
+	// ForwardTLS enables TLS for the forward check
+	ForwardTLS bool
+	// ForwardCheckTimeout defaults to 30s
+	ForwardCheckTimeout time.Duration
+
+	// Canary reloads and verifies a single pod before reloading the others
+	Canary bool
+	// CanaryTLSPort is the pod port serving the certificate, defaults to 24224
+	CanaryTLSPort int
+	// CanaryHealthPort enables the canary health check, e.g. 24220 for monitor_agent
+	CanaryHealthPort int
+	// CanaryHealthPath defaults to /api/plugins.json
+	CanaryHealthPath string
+	// CanaryTimeout bounds the canary verification, defaults to 1m
+	CanaryTimeout time.Duration
+
+	// MaxBufferQueueLength and MaxRetryCount skip the reload of pods whose
+	// output plugins exceed them according to monitor_agent, zero disables the check
+	MaxBufferQueueLength int
+	MaxRetryCount        int
+	// MonitorPort is the monitor_agent port, defaults to 24220
+	MonitorPort int
+	// SkipUnhealthy skips the reload of pods that are not ready or whose
+	// monitor_agent does not answer
+	SkipUnhealthy bool
+
+	// CheckOCSP reloads fluentd when the stapled OCSP response of the served
+	// certificate reports it revoked
+	CheckOCSP bool
+	// CRLURL is a CRL the served certificate is checked against
+	CRLURL string
+
+	// NotAfterTolerance is how far the served and the expected expiry may differ
+	// and still match, absorbing clock skew and rounding, defaults to 5m
+	NotAfterTolerance time.Duration
+	// StrictNotAfter requires the served and the expected expiry to be equal
+	StrictNotAfter bool
+
+	// PropagationWait is how long kubelet gets to update the secret volumes before
+	// fluentd is reloaded. With MountedCertPath the mounted files are checked until
+	// then, otherwise the reload waits until the secret changed this long ago.
+	PropagationWait time.Duration
+	// RenewalWait is how long to wait for a pending cert-manager renewal to
+	// finish before reloading, zero skips the reload until the next run
+	RenewalWait time.Duration
+	// ExpiryWarning flags the served certificate in the report when it expires
+	// within this period and cert-manager has not renewed it, zero disables it
+	ExpiryWarning time.Duration
+	// TriggerRenewal asks cert-manager to renew the certificate when the served
+	// one expires within ExpiryWarning and no renewal is pending
+	TriggerRenewal bool
+	// DependentCerts are further cert-manager certificates the fluentd config
+	// references, e.g. a client CA bundle, given as name or namespace/name. A
+	// reload is postponed while one of them is due to be renewed along with the
+	// certificate, for at most DependentCertsWait after the certificate was renewed
+	DependentCerts []string
+	// DependentCertsWait defaults to 10m
+	DependentCertsWait time.Duration
+	// ComparePublicKey also compares the served public key with the one in the
+	// certificate's secret, catching re-keyed certificates with overlapping validity
+	ComparePublicKey bool
+	// CompareChain also compares the served intermediates with the chain in the
+	// certificate's secret, catching rotated intermediate CAs
+	CompareChain bool
+
+	// RPCClientCert and RPCClientKey are the files of the client certificate
+	// presented to a fluentd RPC endpoint requiring mutual TLS, they are loaded
+	// again when they change. Setting them or RPCCAFile calls the RPC over HTTPS.
+	RPCClientCert string
+	RPCClientKey  string
+	// RPCCAFile verifies the RPC server certificate instead of the system roots
+	RPCCAFile string
+	// RPCServerName is the name the RPC server certificate is verified for,
+	// needed when the RPC is called by pod IP
+	RPCServerName string
+
+	// RPCProtocol is RPCProtocolAuto, RPCProtocolHTTP1 or RPCProtocolHTTP2,
+	// defaults to RPCProtocolAuto
+	RPCProtocol string
+	// RPCProxy and ProbeProxy are ProxyNone, ProxyFromEnvironment or a proxy URL,
+	// they default to ProxyNone and ProxyFromEnvironment
+	RPCProxy   string
+	ProbeProxy string
+	// ProbeProxyUsername and ProbeProxyPassword authenticate the TLS probe at
+	// its proxy when the proxy URL has no credentials
+	ProbeProxyUsername string
+	ProbeProxyPassword string
+	// ProbeMinTLSVersion fails the probe when fluentd negotiates an older TLS
+	// version, one of 1.0, 1.1, 1.2 or 1.3
+	ProbeMinTLSVersion string
+	// ProbeCipherSuites are the cipher suite names the probe accepts for TLS 1.2
+	// and older, TLS 1.3 suites are not configurable
+	ProbeCipherSuites []string
+	// ProbePortForward is the fluentd service the TLS probe is port-forwarded to
+	ProbePortForward string
+	// ProbeTimeout bounds connecting and the TLS handshake of a probe, defaults to 10s
+	ProbeTimeout time.Duration
+	// ProbeAttempts is how often a failing probe is tried, defaults to 3
+	ProbeAttempts int
+	// ProbeAddress is the host:port the TLS probe of ServiceURL dials instead of
+	// ServiceURL:443, ServiceURL is still the expected server name
+	ProbeAddress string
+	// ProbeRootCAs verify the certificate the probe is served instead of the system roots
+	ProbeRootCAs *x509.CertPool
+	// ProbePort is the port the service URLs serve the certificate on, defaults to 443
+	ProbePort int
+
+	// Profiles enables named profiles of the targets ConfigMap, every target
+	// is checked once per profile with its settings
+	Profiles []string
+	// Profile is the name of the profile applied to the target
+	Profile string
+}
+
+// releaseSelector selects the pods of a Helm release by the recommended
+// app.kubernetes.io/instance label, narrowed down by selector when set
+func releaseSelector(release, selector string) string {
+	if selector == "" {
+		return "app.kubernetes.io/instance=" + release
+	}
+
+	return "app.kubernetes.io/instance=" + release + "," + selector
+}
+
+// withDefaults returns a copy of the config with the defaults applied
+func (c Config) withDefaults() Config {
+	c.PreReloadHook = c.PreReloadHook.withDefaults()
+	c.PostReloadHook = c.PostReloadHook.withDefaults()
+	if c.CertNamespace == "" {
+		c.CertNamespace, c.CertName = splitCertName(c.CertName, c.Namespace)
+	}
+	if c.ReleaseName != "" {
+		// expanded into the selector once so applying the defaults again keeps it
+		c.Selector = releaseSelector(c.ReleaseName, c.Selector)
+		c.ReleaseName = ""
+	}
+	if c.Selector == "" && c.SelectorFromService == "" {
+		c.Selector = fmt.Sprintf("app=%s", c.Namespace)
+	}
+	if c.Name == "" {
+		c.Name = c.CertName
+	}
+	if c.Name == "" {
+		c.Name = c.SecretName
+	}
+	if c.Name == "" {
+		c.Name = c.CertFile
+	}
+	if c.RPCMethod == "" {
+		c.RPCMethod = http.MethodGet
+	}
+	c.RPCMethod = strings.ToUpper(c.RPCMethod)
+	if c.RPCTimeout == 0 {
+		c.RPCTimeout = 5 * time.Second
+	}
+	if c.UserAgent == "" {
+		c.UserAgent = "fluentd-reloader/" + Version
+	}
+	if c.ProbePort == 0 {
+		c.ProbePort = 443
+	}
+	if c.RPCPort == 0 {
+		c.RPCPort = 24444
+	}
+	if c.CheckMode == "" {
+		c.CheckMode = CheckModeTLSProbe
+	}
+	if c.ReloadVia == "" {
+		c.ReloadVia = ReloadViaPodIP
+	}
+	if c.ReloadStrategy == "" {
+		c.ReloadStrategy = StrategyFluentdRPC
+	}
+	if c.ForwarderNamespace == "" {
+		c.ForwarderNamespace = c.Namespace
+	}
+	if c.ForwarderRPCPort == 0 {
+		c.ForwarderRPCPort = c.RPCPort
+	}
+	if c.ForwarderReloadStrategy == "" {
+		c.ForwarderReloadStrategy = c.ReloadStrategy
+	}
+	if c.DisruptionWait == 0 {
+		c.DisruptionWait = 5 * time.Minute
+	}
+	if c.StrictNotAfter {
+		c.NotAfterTolerance = 0
+	} else if c.NotAfterTolerance == 0 {
+		c.NotAfterTolerance = 5 * time.Minute
+	}
+	if c.MaxReloadPause == 0 {
+		c.MaxReloadPause = 2 * time.Minute
+	}
+	if c.MonitorPort == 0 {
+		c.MonitorPort = 24220
+	}
+	if c.ReloadSignal == "" {
+		c.ReloadSignal = "USR2"
+	}
+	if c.ForwardPort == 0 {
+		c.ForwardPort = 24224
+	}
+	if c.ForwardCheckTimeout == 0 {
+		c.ForwardCheckTimeout = 30 * time.Second
+	}
+	if c.CanaryTLSPort == 0 {
+		c.CanaryTLSPort = 24224
+	}
+	if c.CanaryHealthPath == "" {
+		c.CanaryHealthPath = "/api/plugins.json"
+	}
+	if c.CanaryTimeout == 0 {
+		c.CanaryTimeout = time.Minute
+	}
+	if c.RPCProtocol == "" {
+		c.RPCProtocol = RPCProtocolAuto
+	}
+	if c.RPCProxy == "" {
+		c.RPCProxy = ProxyNone
+	}
+	if c.ProbeProxy == "" {
+		c.ProbeProxy = ProxyFromEnvironment
+	}
+	if c.ProbeTimeout == 0 {
+		c.ProbeTimeout = 10 * time.Second
+	}
+	if c.ProbeAttempts == 0 {
+		c.ProbeAttempts = 3
+	}
+	if c.DependentCertsWait == 0 {
+		c.DependentCertsWait = 10 * time.Minute
+	}
+	if c.ConfigMapKey == "" {
+		c.ConfigMapKey = "fluent.conf"
+	}
+	if c.SecretSyncTimeout == 0 {
+		c.SecretSyncTimeout = 2 * time.Minute
+	}
+	if c.ReportHistoryLimit == 0 {
+		c.ReportHistoryLimit = 10
+	}
+	if c.CircuitCooldown == 0 {
+		c.CircuitCooldown = time.Hour
+	}
+
+	return c
+}
+
+// reloadsPaused reports whether reloads are currently suspended
+func (c Config) reloadsPaused() bool {
+	return c.Paused != nil && c.Paused()
+}
+
+// NotAfterMatches reports whether the served expiry matches the expected one
+// within the configured tolerance
+func (c Config) NotAfterMatches(served, expected time.Time) bool {
+	c = c.withDefaults()
+	diff := served.Sub(expected)
+	if diff < 0 {
+		diff = -diff
+	}
+
+	return diff <= c.NotAfterTolerance
+}
+
+// splitCertName splits a namespace/name certificate reference, names without
+// a namespace are in namespace
+func splitCertName(certName, namespace string) (string, string) {
+	// a leading slash starts a regular expression pattern, not a namespace
+	if i := strings.Index(certName, "/"); i > 0 {
+		return certName[:i], certName[i+1:]
+	}
+
+	return namespace, certName
+}
+
+// Validate reports the first invalid setting of the config
+func (c Config) Validate() error {
+	c = c.withDefaults()
+
+	if c.TargetsConfigMap == "" && c.CertName == "" && c.SecretName == "" && c.CertFile == "" && c.CertSource == nil {
+		return fmt.Errorf("certificate or secret name, certificate file or source is required without a targets configmap")
+	}
+
+	if c.TargetsConfigMap == "" && IsCertNamePattern(c.CertName) {
+		if c.CertSource != nil || c.SecretName != "" || c.CertFile != "" {
+			return fmt.Errorf("a cert name pattern matches cert-manager certificates and cannot be used with a secret name, certificate file or source")
+		}
+		if _, err := certNameMatcher(c.CertName); err != nil {
+			return err
+		}
+	}
+
+	if len(c.Profiles) > 0 && c.TargetsConfigMap == "" {
+		return fmt.Errorf("profiles are defined in the targets configmap and cannot be used without it")
+	}
+
+	if c.TriggerRenewal {
+		if c.ExpiryWarning <= 0 {
+			return fmt.Errorf("triggering renewals requires an expiry warning period")
+		}
+		if c.CertSource != nil || c.SecretName != "" || c.CertFile != "" {
+			return fmt.Errorf("triggering renewals requires a cert-manager certificate and cannot be used with a secret name, certificate file or source")
+		}
+	}
+
+	switch c.CheckMode {
+	case CheckModeTLSProbe:
+		if c.TargetsConfigMap == "" && c.ServiceURL == "" && !IsCertNamePattern(c.CertName) {
+			return fmt.Errorf("service url is required without a targets configmap or a cert name pattern")
+		}
+	case CheckModeSecretRevision:
+		if c.ReloadVia == ReloadViaService {
+			return fmt.Errorf("check mode %s requires pods and cannot be used when reloading via %s", c.CheckMode, c.ReloadVia)
+		}
+		if c.Canary && c.ServiceURL == "" && c.TargetsConfigMap == "" {
+			return fmt.Errorf("canary reloads verify the certificate served for the service url, which check mode %s needs then", c.CheckMode)
+		}
+	default:
+		return fmt.Errorf("check mode must be %s or %s, got %s", CheckModeTLSProbe, CheckModeSecretRevision, c.CheckMode)
+	}
+
+	return c.validateReload()
+}
+
+// validateReload reports the first invalid setting of the config needed to reload fluentd
+func (c Config) validateReload() error {
+	if c.Client == nil {
+		return fmt.Errorf("a kubernetes client is required")
+	}
+	if c.Namespace == "" {
+		return fmt.Errorf("namespace is required")
+	}
+	if (c.SecretName != "" || c.CertFile != "") && c.RecordHistory {
+		return fmt.Errorf("history is recorded on the certificate and cannot be used with a secret name or certificate file")
+	}
+
+	for _, hook := range []*ReloadHook{c.PreReloadHook, c.PostReloadHook} {
+		if hook == nil {
+			continue
+		}
+		if err := hook.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.execHooks() && c.ReloadVia == ReloadViaService {
+		return fmt.Errorf("hook commands are executed in the fluentd pods and cannot be used with reload via service")
+	}
+
+	if c.FieldSelector != "" {
+		if _, err := parsePodFieldSelector(c.FieldSelector); err != nil {
+			return err
+		}
+	}
+	if (c.FieldSelector != "" || c.NodeName != "" || c.Zone != "") && c.ReloadVia == ReloadViaService {
+		return fmt.Errorf("field selector, node and zone filters need the fluentd pods and cannot be used with reload via service")
+	}
+
+	if c.Shards < 0 || (c.Shards > 1 && (c.ShardIndex < 0 || c.ShardIndex >= c.Shards)) {
+		return fmt.Errorf("shard index must be between 0 and %d, got %d", c.Shards-1, c.ShardIndex)
+	}
+	if c.Shards > 1 && c.ReloadVia == ReloadViaService {
+		return fmt.Errorf("sharding needs the fluentd pods and cannot be used with reload via service")
+	}
+
+	if c.SecretManager != "" {
+		if c.SecretName == "" {
+			return fmt.Errorf("secret manager %s needs a secret name", c.SecretManager)
+		}
+		if c.SecretManager != SecretManagerExternalSecrets && c.SecretManager != SecretManagerSealedSecrets {
+			return fmt.Errorf("secret manager must be %s or %s, got %s", SecretManagerExternalSecrets, SecretManagerSealedSecrets, c.SecretManager)
+		}
+	}
+
+	if c.ConfigMapName != "" && c.CheckMode != CheckModeTLSProbe {
+		return fmt.Errorf("config drift detection is only supported with the %s check mode", CheckModeTLSProbe)
+	}
+
+	if c.ReportHistoryLimit < 0 {
+		return fmt.Errorf("report history limit must not be negative, got %d", c.ReportHistoryLimit)
+	}
+
+	if c.CircuitFailures < 0 {
+		return fmt.Errorf("circuit failures must not be negative, got %d", c.CircuitFailures)
+	}
+	if c.CircuitRestart && c.CircuitFailures == 0 {
+		return fmt.Errorf("circuit restart needs circuit failures")
+	}
+	if c.CircuitRestart && c.ReloadVia == ReloadViaService {
+		return fmt.Errorf("circuit restart requires pods and cannot be used when reloading via %s", c.ReloadVia)
+	}
+
+	if c.RPCMethod != http.MethodGet && c.RPCMethod != http.MethodPost {
+		return fmt.Errorf("rpc method must be GET or POST, got %s", c.RPCMethod)
+	}
+
+	switch c.ReloadVia {
+	case ReloadViaPodIP:
+	case ReloadViaAPIProxy:
+		if c.RESTConfig == nil {
+			return fmt.Errorf("reloading via %s requires a rest config", c.ReloadVia)
+		}
+		if c.ForwardCheck {
+			return fmt.Errorf("the forward check connects to the pods and cannot be used when reloading via %s", c.ReloadVia)
+		}
+	case ReloadViaPodDNS, ReloadViaService:
+		if c.HeadlessService == "" {
+			return fmt.Errorf("headless service must be set when reloading via %s", c.ReloadVia)
+		}
+	default:
+		return fmt.Errorf("reload via must be one of %s, %s, %s or %s, got %s", ReloadViaPodIP, ReloadViaPodDNS, ReloadViaService, ReloadViaAPIProxy, c.ReloadVia)
+	}
+
+	if c.IPFamily != "" && c.IPFamily != IPFamilyIPv4 && c.IPFamily != IPFamilyIPv6 {
+		return fmt.Errorf("ip family must be %s or %s, got %s", IPFamilyIPv4, IPFamilyIPv6, c.IPFamily)
+	}
+
+	if _, ok := reloaders[c.ReloadStrategy]; !ok {
+		return fmt.Errorf("reload strategy %s is not supported", c.ReloadStrategy)
+	}
+	if _, ok := reloaders[c.ForwarderReloadStrategy]; !ok {
+		return fmt.Errorf("forwarder reload strategy %s is not supported", c.ForwarderReloadStrategy)
+	}
+	if c.ForwarderSelector != "" && c.ForwarderReloadStrategy == StrategyExecSignal && c.RESTConfig == nil {
+		return fmt.Errorf("forwarder reload strategy %s requires a rest config", StrategyExecSignal)
+	}
+	if c.FallbackStrategy != "" {
+		if c.ReloadStrategy != StrategyFluentdRPC {
+			return fmt.Errorf("fallback strategy requires the %s reload strategy", StrategyFluentdRPC)
+		}
+		if c.FallbackStrategy != StrategyExecSignal && c.FallbackStrategy != StrategyPodDelete {
+			return fmt.Errorf("fallback strategy must be %s or %s, got %s", StrategyExecSignal, StrategyPodDelete, c.FallbackStrategy)
+		}
+	}
+	if c.VerifyConfigDump && (c.ReloadStrategy != StrategyFluentdRPC || c.ReloadURLTemplate != "") {
+		return fmt.Errorf("config dump verification requires the %s reload strategy without a reload url template", StrategyFluentdRPC)
+	}
+	if c.VerifyConfigDump && c.FallbackStrategy != "" {
+		return fmt.Errorf("config dump verification cannot be combined with a fallback strategy")
+	}
+	if c.RPCWorkers > 1 && c.ReloadURLTemplate != "" {
+		return fmt.Errorf("per worker reloads cannot be combined with a reload url template")
+	}
+	if c.ReloadURLTemplate != "" {
+		if c.ReloadStrategy != StrategyFluentdRPC {
+			return fmt.Errorf("reload url template requires the %s reload strategy", StrategyFluentdRPC)
+		}
+		if _, err := parseReloadURLTemplate(c.ReloadURLTemplate); err != nil {
+			return err
+		}
+	}
+	if c.ReloadVia == ReloadViaService && (c.ReloadStrategy == StrategyExecSignal || c.ReloadStrategy == StrategyPodDelete) {
+		return fmt.Errorf("reload strategy %s requires pods and cannot be used when reloading via %s", c.ReloadStrategy, c.ReloadVia)
+	}
+	if c.ReloadVia == ReloadViaService && c.FallbackStrategy != "" {
+		return fmt.Errorf("fallback strategy %s requires pods and cannot be used when reloading via %s", c.FallbackStrategy, c.ReloadVia)
+	}
+	if c.OrderedReload && c.ReloadVia == ReloadViaService {
+		return fmt.Errorf("ordered reloads require pods and cannot be used when reloading via %s", c.ReloadVia)
+	}
+	if c.ReloadPartition < 0 {
+		return fmt.Errorf("reload partition must not be negative, got %d", c.ReloadPartition)
+	}
+	if c.ForwardCheck && (c.ReloadStrategy == StrategyPodDelete || c.FallbackStrategy == StrategyPodDelete) {
+		return fmt.Errorf("the forward check cannot be used with the %s reload strategy", StrategyPodDelete)
+	}
+	if c.bufferGateEnabled() && c.ReloadVia == ReloadViaService {
+		return fmt.Errorf("the buffer check requires pods and cannot be used when reloading via %s", c.ReloadVia)
+	}
+	if c.Canary && c.ReloadVia == ReloadViaService {
+		return fmt.Errorf("canary reloads require pods and cannot be used when reloading via %s", c.ReloadVia)
+	}
+	if (c.ReloadStrategy == StrategyExecSignal || c.FallbackStrategy == StrategyExecSignal) && c.RESTConfig == nil {
+		return fmt.Errorf("reload strategy %s requires a rest config", StrategyExecSignal)
+	}
+	if c.ProbePortForward != "" && c.RESTConfig == nil {
+		return fmt.Errorf("probe port-forward requires a rest config")
+	}
+	if (c.RPCClientCert == "") != (c.RPCClientKey == "") {
+		return fmt.Errorf("rpc client certificate and key must be set together")
+	}
+	if c.RPCClientCert != "" {
+		if _, err := tls.LoadX509KeyPair(c.RPCClientCert, c.RPCClientKey); err != nil {
+			return fmt.Errorf("failed to load rpc client certificate: %w", err)
+		}
+	}
+	if c.RPCCAFile != "" {
+		if _, err := loadCertPool(c.RPCCAFile); err != nil {
+			return err
+		}
+	}
+	if c.SyncCondition && c.StatefulSetName == "" && c.TargetsConfigMap == "" {
+		return fmt.Errorf("sync condition requires a statefulset name")
+	}
+	if c.ProbePortForward != "" && c.ProbeAddress != "" {
+		return fmt.Errorf("probe port-forward and probe address are mutually exclusive")
+	}
+
+	if _, err := proxyFunc(c.RPCProxy); err != nil {
+		return fmt.Errorf("rpc proxy: %w", err)
+	}
+	if err := validRPCProtocol(c); err != nil {
+		return err
+	}
+	if err := validProbeProxy(c.ProbeProxy); err != nil {
+		return fmt.Errorf("probe proxy: %w", err)
+	}
+	if _, err := probeTLSConfig(c); err != nil {
+		return fmt.Errorf("probe: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package reloader
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() Config {
+		return Config{
+			Client:     fake.NewSimpleClientset(),
+			Namespace:  "logging",
+			ServiceURL: "fluentd.logging.svc",
+			CertName:   "fluentd-tls",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		// wantErr is part of the error, empty when the config is valid
+		wantErr string
+	}{
+		{name: "valid", modify: func(c *Config) {}},
+		{
+			name:    "no certificate",
+			modify:  func(c *Config) { c.CertName = "" },
+			wantErr: "certificate or secret name",
+		},
+		{
+			name:    "no service url",
+			modify:  func(c *Config) { c.ServiceURL = "" },
+			wantErr: "service url is required",
+		},
+		{
+			name:   "cert name pattern without service url",
+			modify: func(c *Config) { c.ServiceURL, c.CertName = "", "fluentd-*-tls" },
+		},
+		{
+			name:    "cert name pattern with secret",
+			modify:  func(c *Config) { c.CertName, c.SecretName = "fluentd-*-tls", "fluentd-tls" },
+			wantErr: "cert name pattern",
+		},
+		{
+			name:    "profiles without targets configmap",
+			modify:  func(c *Config) { c.Profiles = []string{"aggregator"} },
+			wantErr: "profiles",
+		},
+		{
+			name:    "trigger renewal without expiry warning",
+			modify:  func(c *Config) { c.TriggerRenewal = true },
+			wantErr: "expiry warning",
+		},
+		{
+			name:   "trigger renewal",
+			modify: func(c *Config) { c.TriggerRenewal, c.ExpiryWarning = true, 24*time.Hour },
+		},
+		{
+			name:    "unknown check mode",
+			modify:  func(c *Config) { c.CheckMode = "poll" },
+			wantErr: "check mode must be",
+		},
+		{
+			name:    "secret revision canary without service url",
+			modify:  func(c *Config) { c.CheckMode, c.Canary, c.ServiceURL = CheckModeSecretRevision, true, "" },
+			wantErr: "canary",
+		},
+		{
+			name: "secret revision canary with targets configmap",
+			modify: func(c *Config) {
+				c.CheckMode, c.Canary, c.ServiceURL, c.TargetsConfigMap = CheckModeSecretRevision, true, "", "targets"
+			},
+		},
+		{
+			name:    "no client",
+			modify:  func(c *Config) { c.Client = nil },
+			wantErr: "kubernetes client",
+		},
+		{
+			name:    "sync condition without statefulset",
+			modify:  func(c *Config) { c.SyncCondition = true },
+			wantErr: "statefulset name",
+		},
+		{
+			name: "probe port-forward and probe address",
+			modify: func(c *Config) {
+				c.RESTConfig = &rest.Config{}
+				c.ProbePortForward, c.ProbeAddress = "fluentd", "127.0.0.1:443"
+			},
+			wantErr: "mutually exclusive",
+		},
+		{
+			name:    "invalid shard index",
+			modify:  func(c *Config) { c.Shards, c.ShardIndex = 2, 2 },
+			wantErr: "shard index",
+		},
+		{
+			name:    "unknown secret manager",
+			modify:  func(c *Config) { c.SecretName, c.CertName, c.SecretManager = "fluentd-tls", "", "vault" },
+			wantErr: "secret manager must be",
+		},
+		{
+			name:    "unknown probe proxy scheme",
+			modify:  func(c *Config) { c.ProbeProxy = "ftp://proxy:21" },
+			wantErr: "ftp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.modify(&c)
+
+			err := c.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() error = %v, want none", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("Validate() error = nil, want one containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("Validate() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
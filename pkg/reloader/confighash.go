@@ -0,0 +1,83 @@
+package reloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// configHasher is implemented by reloaders that can read the running config,
+// reloads are then confirmed by reading it again instead of trusting the RPC response
+type configHasher interface {
+	ConfigHash(ctx context.Context, t target) (string, error)
+}
+
+// dumpResponse is the body of fluentd's config.getDump RPC endpoint
+type dumpResponse struct {
+	OK   bool   `json:"ok"`
+	Conf string `json:"conf"`
+}
+
+// verifyingRPCReloader confirms fluentd RPC reloads with config.getDump
+type verifyingRPCReloader struct {
+	fluentdRPCReloader
+}
+
+// ConfigHash returns the SHA-256 of the config fluentd is running
+func (r verifyingRPCReloader) ConfigHash(ctx context.Context, t target) (string, error) {
+	conf, err := r.configDump(ctx, t)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(conf))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// configDump returns the config fluentd is running, a pod that moved to a new
+// IP is looked up again like for the reload
+func (r fluentdRPCReloader) configDump(ctx context.Context, t target) (string, error) {
+	conf, err := r.getDump(ctx, t)
+	if moved, ok := r.movedTarget(ctx, t, err); ok {
+		return r.getDump(ctx, moved)
+	}
+
+	return conf, err
+}
+
+func (r fluentdRPCReloader) getDump(ctx context.Context, t target) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/api/config.getDump", r.scheme, t.host), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	setRunIDHeader(req)
+	for name, values := range r.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("User-Agent", r.userAgent)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get config dump: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError(fmt.Errorf("failed to get config dump: %s", resp.Status), resp.StatusCode, "")
+	}
+
+	dump := dumpResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&dump); err != nil {
+		return "", fmt.Errorf("failed to parse config dump: %w", err)
+	}
+	if !dump.OK {
+		return "", fmt.Errorf("fluentd on %s did not return its config", t)
+	}
+
+	return dump.Conf, nil
+}
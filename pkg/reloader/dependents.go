@@ -0,0 +1,42 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+)
+
+// pendingDependent returns the first dependent certificate that is expected to
+// be renewed together with the renewed certificate but has not been yet, so
+// fluentd is reloaded once for all of them. An empty name means the reload
+// can go ahead, which it always does once DependentCertsWait passed since the
+// certificate was renewed.
+func (a app) pendingDependent(ctx context.Context, cfg Config, renewed cmapi.Certificate) (string, error) {
+	if len(cfg.DependentCerts) == 0 || renewed.Status.NotBefore == nil {
+		return "", nil
+	}
+
+	deadline := renewed.Status.NotBefore.Add(cfg.DependentCertsWait)
+	if time.Now().After(deadline) {
+		return "", nil
+	}
+
+	for _, name := range cfg.DependentCerts {
+		dependent := a
+		dependent.certNamespace, dependent.certName = splitCertName(name, cfg.CertNamespace)
+		cert, err := certManagerSource{dependent}.Certificate(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to get dependent certificate %s: %w", name, err)
+		}
+
+		// a dependent due before the deadline belongs to the same rotation
+		renewalTime := cert.Status.RenewalTime
+		if renewalPending(cert) || (renewalTime != nil && renewalTime.Time.Before(deadline)) {
+			return name, nil
+		}
+	}
+
+	return "", nil
+}
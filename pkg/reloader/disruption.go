@@ -0,0 +1,60 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const disruptionPollInterval = 5 * time.Second
+
+// waitForDisruptionBudget waits until every PodDisruptionBudget selecting the pod
+// allows a disruption, so the reload never takes down more pods than the budget allows
+func (a app) waitForDisruptionBudget(ctx context.Context, pod corev1.Pod) error {
+	for {
+		blocking, err := a.blockingDisruptionBudget(ctx, pod)
+		if err != nil {
+			return err
+		}
+		if blocking == "" {
+			return nil
+		}
+
+		log.Printf("Disruption budget %s allows no disruption of %s, waiting", blocking, pod.Name)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("disruption budget %s did not allow evicting %s: %w", blocking, pod.Name, ctx.Err())
+		case <-time.After(disruptionPollInterval):
+		}
+	}
+}
+
+// blockingDisruptionBudget returns the name of a budget selecting the pod that
+// allows no disruption, or an empty name
+func (a app) blockingDisruptionBudget(ctx context.Context, pod corev1.Pod) (string, error) {
+	pdbs, err := a.client.PolicyV1().PodDisruptionBudgets(pod.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list disruption budgets: %w", err)
+	}
+
+	for _, pdb := range pdbs.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse selector of disruption budget %s: %w", pdb.Name, err)
+		}
+		if selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+
+		if pdb.Status.DisruptionsAllowed < 1 {
+			return pdb.Name, nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,68 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// driftedTargets returns the targets whose running config differs from the
+// config in the ConfigMap, e.g. because a reload after a config change was
+// never applied
+func (a app) driftedTargets(ctx context.Context, cfg Config, targets []target) ([]target, error) {
+	cm, err := a.client.CoreV1().ConfigMaps(a.namespace).Get(ctx, cfg.ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fluentd configmap %s: %w", cfg.ConfigMapName, err)
+	}
+	conf, ok := cm.Data[cfg.ConfigMapKey]
+	if !ok {
+		return nil, fmt.Errorf("fluentd configmap %s has no key %s", cfg.ConfigMapName, cfg.ConfigMapKey)
+	}
+	expected := normalizeConfig(conf)
+
+	r := fluentdRPCReloader{
+		client:    rpcClient(cfg),
+		scheme:    cfg.rpcScheme(),
+		headers:   cfg.RPCHeaders,
+		userAgent: cfg.UserAgent,
+		ipFamily:  cfg.IPFamily,
+	}
+	if cfg.ReloadVia == ReloadViaPodIP {
+		r.app = &a
+	}
+	var drifted []target
+	for _, t := range targets {
+		running, err := r.configDump(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		if normalizeConfig(running) != expected {
+			log.Printf("Config of %s differs from configmap %s", t, cfg.ConfigMapName)
+			drifted = append(drifted, t)
+		}
+	}
+
+	return drifted, nil
+}
+
+// normalizeConfig drops what differs between the config file and the dump
+// fluentd serializes from it: comments, indentation, blank lines and the
+// <ROOT> element wrapping the dump
+func normalizeConfig(conf string) string {
+	lines := []string{}
+	for _, line := range strings.Split(conf, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 && !strings.Contains(line[:i], "\"") {
+			line = line[:i]
+		}
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" || line == "<ROOT>" || line == "</ROOT>" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,116 @@
+package reloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Error classes of failed checks
+const (
+	// ErrorClassRetriable errors may pass on a retry, e.g. timeouts, 5xx
+	// responses and API throttling
+	ErrorClassRetriable = "retriable"
+	// ErrorClassTerminal errors need a change of the configuration or the
+	// cluster, e.g. a missing certificate or an invalid selector
+	ErrorClassTerminal = "terminal"
+)
+
+// terminalError marks an error retrying cannot fix, hint tells the user what to change
+type terminalError struct {
+	err  error
+	hint string
+}
+
+func (e terminalError) Error() string {
+	if e.hint == "" {
+		return e.err.Error()
+	}
+
+	return e.err.Error() + ", " + e.hint
+}
+
+func (e terminalError) Unwrap() error {
+	return e.err
+}
+
+func terminal(err error, hint string) error {
+	return terminalError{err: err, hint: hint}
+}
+
+// retriableError marks an error that may pass on a retry, after is how long
+// the server asked to wait before retrying
+type retriableError struct {
+	err   error
+	after time.Duration
+}
+
+func (e retriableError) Error() string {
+	return e.err.Error()
+}
+
+func (e retriableError) Unwrap() error {
+	return e.err
+}
+
+func retriable(err error) error {
+	return retriableError{err: err}
+}
+
+func retriableAfter(err error, after time.Duration) error {
+	return retriableError{err: err, after: after}
+}
+
+// statusError classifies the error of an HTTP response, throttling and 5xx
+// responses are retriable, hint tells the user what to change for the others
+func statusError(err error, code int, hint string) error {
+	if code == http.StatusTooManyRequests || code >= 500 {
+		return retriable(err)
+	}
+
+	return terminal(err, hint)
+}
+
+// retryAfter returns how long the server asked to wait before retrying err
+func retryAfter(err error) time.Duration {
+	var retriableErr retriableError
+	if errors.As(err, &retriableErr) {
+		return retriableErr.after
+	}
+
+	return 0
+}
+
+// ErrorClass classifies err as ErrorClassRetriable or ErrorClassTerminal, errors
+// that are neither marked nor network errors, timeouts or API errors known to
+// be transient are terminal
+func ErrorClass(err error) string {
+	var terminalErr terminalError
+	var retriableErr retriableError
+	switch {
+	case errors.As(err, &terminalErr):
+		return ErrorClassTerminal
+	case errors.As(err, &retriableErr):
+		return ErrorClassRetriable
+	case apierrors.IsTooManyRequests(err), apierrors.IsServerTimeout(err), apierrors.IsTimeout(err),
+		apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsConflict(err):
+		return ErrorClassRetriable
+	case apierrors.IsNotFound(err), apierrors.IsForbidden(err), apierrors.IsUnauthorized(err),
+		apierrors.IsInvalid(err), apierrors.IsBadRequest(err), apierrors.IsMethodNotSupported(err):
+		return ErrorClassTerminal
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// the connection failed, was reset or timed out
+		return ErrorClassRetriable
+	}
+
+	return ErrorClassTerminal
+}
@@ -0,0 +1,50 @@
+package reloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestErrorClass(t *testing.T) {
+	certificates := schema.GroupResource{Group: "cert-manager.io", Resource: "certificates"}
+	refused := &url.Error{Op: "Get", URL: "http://10.0.0.1:24444", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "terminal", err: terminal(errors.New("no certificate"), "fix FLUENTD_CERT_NAME"), want: ErrorClassTerminal},
+		{name: "wrapped terminal", err: fmt.Errorf("check failed: %w", terminal(errors.New("no certificate"), "")), want: ErrorClassTerminal},
+		{name: "retriable", err: retriable(errors.New("draining")), want: ErrorClassRetriable},
+		{name: "throttled", err: apierrors.NewTooManyRequests("slow down", 1), want: ErrorClassRetriable},
+		{name: "api unavailable", err: apierrors.NewServiceUnavailable("down"), want: ErrorClassRetriable},
+		{name: "conflict", err: apierrors.NewConflict(certificates, "fluentd-tls", errors.New("modified")), want: ErrorClassRetriable},
+		{name: "certificate not found", err: apierrors.NewNotFound(certificates, "fluentd-tls"), want: ErrorClassTerminal},
+		{name: "forbidden", err: apierrors.NewForbidden(certificates, "fluentd-tls", errors.New("denied")), want: ErrorClassTerminal},
+		{name: "connection refused", err: fmt.Errorf("failed to send request: %w", refused), want: ErrorClassRetriable},
+		{name: "deadline exceeded", err: fmt.Errorf("probe failed: %w", context.DeadlineExceeded), want: ErrorClassRetriable},
+		{name: "connection closed", err: fmt.Errorf("failed to read response body: %w", io.ErrUnexpectedEOF), want: ErrorClassRetriable},
+		{name: "unclassified", err: errors.New("fluentd on fluentd-0 did not acknowledge the reload"), want: ErrorClassTerminal},
+		{name: "5xx response", err: statusError(errors.New("503"), http.StatusServiceUnavailable, ""), want: ErrorClassRetriable},
+		{name: "429 response", err: statusError(errors.New("429"), http.StatusTooManyRequests, ""), want: ErrorClassRetriable},
+		{name: "4xx response", err: statusError(errors.New("401"), http.StatusUnauthorized, ""), want: ErrorClassTerminal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorClass(tt.err); got != tt.want {
+				t.Errorf("ErrorClass() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	lastVerifiedAnnotation = "fluentd-reloader.io/last-verified"
+
+	reasonEndpointVerified  = "EndpointVerified"
+	reasonReloaded          = "FluentdReloaded"
+	reasonReloadFailed      = "FluentdReloadFailed"
+	reasonRevoked           = "ServedCertificateRevoked"
+	reasonExpiring          = "CertificateExpiringWithoutRenewal"
+	reasonCircuitOpened     = "FluentdReloadCircuitOpened"
+	reasonMassReloadBlocked = "FluentdMassReloadBlocked"
+	reasonRenewalTriggered  = "CertificateRenewalTriggered"
+)
+
+// recordCertificateEvent creates an event on the certificate so auditors can see
+// when the served certificate was verified and fluentd was reloaded
+func (a app) recordCertificateEvent(ctx context.Context, cert cmapi.Certificate, eventType, reason, message string) error {
+	apiVersion := cert.APIVersion
+	if apiVersion == "" {
+		apiVersion = cmapi.SchemeGroupVersion.String()
+	}
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: cert.Name + "-",
+			Namespace:    cert.Namespace,
+			Annotations:  runIDAnnotations(ctx),
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion:      apiVersion,
+			Kind:            cmapi.CertificateKind,
+			Name:            cert.Name,
+			Namespace:       cert.Namespace,
+			UID:             cert.UID,
+			ResourceVersion: cert.ResourceVersion,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: "fluentd-reloader"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	_, err := a.client.CoreV1().Events(cert.Namespace).Create(ctx, event, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create event for certificate %s: %w", cert.Name, err)
+	}
+
+	return nil
+}
+
+// annotateCertificate merges the given annotations into the certificate, nil
+// values remove an annotation
+func (a app) annotateCertificate(ctx context.Context, cert cmapi.Certificate, annotations map[string]interface{}) error {
+	patch, err := annotationsPatch(annotations)
+	if err != nil {
+		return err
+	}
+
+	uri, err := certificatesPath(a.client, cert.Namespace)
+	if err != nil {
+		return fmt.Errorf("failed to annotate certificate %s: %w", cert.Name, err)
+	}
+	err = a.client.Discovery().RESTClient().Patch(types.MergePatchType).AbsPath(uri, cert.Name).Body(patch).Do(ctx).Error()
+	if err != nil {
+		return fmt.Errorf("failed to annotate certificate %s: %w", cert.Name, err)
+	}
+
+	return nil
+}
+
+// recordHistory records the outcome of a check on the certificate, failures are
+// only logged as they must not fail the run
+func (a app) recordHistory(ctx context.Context, cert cmapi.Certificate, eventType, reason, message string) {
+	if err := a.recordCertificateEvent(ctx, cert, eventType, reason, message); err != nil {
+		log.Println(err)
+	}
+
+	annotations := map[string]interface{}{}
+	now := time.Now().UTC().Format(time.RFC3339)
+	switch reason {
+	case reasonEndpointVerified:
+		annotations[lastVerifiedAnnotation] = now
+	case reasonReloaded:
+		annotations[lastReloadAnnotation] = now
+	default:
+		return
+	}
+
+	if err := a.annotateCertificate(ctx, cert, annotations); err != nil {
+		log.Println(err)
+	}
+}
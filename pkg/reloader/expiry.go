@@ -0,0 +1,22 @@
+package reloader
+
+import (
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+)
+
+// expiringWithoutRenewal reports whether the served certificate expires within
+// the configured warning period while cert-manager neither issued nor is
+// issuing a newer certificate, e.g. because the issuer is misconfigured
+func expiringWithoutRenewal(cfg Config, certificate cmapi.Certificate, servedNotAfter time.Time) bool {
+	if cfg.ExpiryWarning <= 0 || time.Until(servedNotAfter) > cfg.ExpiryWarning {
+		return false
+	}
+	if renewalPending(certificate) {
+		return false
+	}
+
+	expected := certificate.Status.NotAfter
+	return expected == nil || !expected.Time.After(servedNotAfter.Add(cfg.NotAfterTolerance))
+}
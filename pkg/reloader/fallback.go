@@ -0,0 +1,69 @@
+package reloader
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// fallbackReloader reloads with the fallback strategy when fluentd does not
+// support RPC reloads, e.g. older builds without config.gracefulReload
+type fallbackReloader struct {
+	primary  Reloader
+	fallback Reloader
+	strategy string
+}
+
+// newReloader returns the reloader of the configured strategy, wrapped with
+// the fallback strategy and the reload hooks when they are configured
+func newReloader(a app, cfg Config) Reloader {
+	r := reloaders[cfg.ReloadStrategy](a, cfg)
+	if cfg.FallbackStrategy != "" {
+		r = fallbackReloader{primary: r, fallback: reloaders[cfg.FallbackStrategy](a, cfg), strategy: cfg.FallbackStrategy}
+	}
+	if cfg.PreReloadHook != nil || cfg.PostReloadHook != nil {
+		r = hookedReloader{Reloader: r, app: a, container: cfg.ContainerName, pre: cfg.PreReloadHook, post: cfg.PostReloadHook}
+	}
+
+	return r
+}
+
+func (r fallbackReloader) Reload(ctx context.Context, t target) error {
+	_, err := r.ReloadWorkers(ctx, t)
+	return err
+}
+
+func (r fallbackReloader) ReloadWorkers(ctx context.Context, t target) ([]WorkerResult, error) {
+	results, _, err := r.reloadOrFallback(ctx, t)
+	return results, err
+}
+
+func (r fallbackReloader) reloadOrFallback(ctx context.Context, t target) ([]WorkerResult, bool, error) {
+	results, _, err := reloadOrFallback(ctx, r.primary, t)
+	if !errors.Is(err, errRPCUnsupported) {
+		return results, false, err
+	}
+
+	log.Printf("Falling back to the %s reload strategy on %s: %v", r.strategy, t, err)
+	return nil, true, r.fallback.Reload(ctx, t)
+}
+
+// fallbackReporter is implemented by reloaders that can fall back to another
+// strategy and report whether they did
+type fallbackReporter interface {
+	reloadOrFallback(ctx context.Context, t target) ([]WorkerResult, bool, error)
+}
+
+// reloadOrFallback reloads t and reports the worker results and whether the
+// reloader fell back to its fallback strategy
+func reloadOrFallback(ctx context.Context, r Reloader, t target) ([]WorkerResult, bool, error) {
+	if fr, ok := r.(fallbackReporter); ok {
+		return fr.reloadOrFallback(ctx, t)
+	}
+	if wr, ok := r.(workerReloader); ok {
+		results, err := wr.ReloadWorkers(ctx, t)
+		return results, false, err
+	}
+
+	return nil, false, r.Reload(ctx, t)
+}
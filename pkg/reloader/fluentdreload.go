@@ -0,0 +1,330 @@
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// the FluentdReload custom resource of k8s/fluentdreload-crd.yaml
+const (
+	fluentdReloadAPIVersion = "fluentd-reloader.io/v1alpha1"
+	fluentdReloadKind       = "FluentdReload"
+	fluentdReloadResource   = "fluentdreloads"
+	// fluentdReloadFinalizer keeps a deleted FluentdReload until the state
+	// recorded for its target was removed
+	fluentdReloadFinalizer = "fluentd-reloader.io/cleanup"
+)
+
+// fluentdReload is a FluentdReload, encoded as JSON as its type is not generated
+type fluentdReload struct {
+	APIVersion        string `json:"apiVersion"`
+	Kind              string `json:"kind"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              fluentdReloadSpec   `json:"spec"`
+	Status            fluentdReloadStatus `json:"status,omitempty"`
+}
+
+// fluentdReloadSpec names the target and where its state is recorded, so it
+// can be cleaned up after the target was dropped from the configuration
+type fluentdReloadSpec struct {
+	// Target names the checked target
+	Target          string `json:"target,omitempty"`
+	Selector        string `json:"selector,omitempty"`
+	CertNamespace   string `json:"certNamespace,omitempty"`
+	CertName        string `json:"certName,omitempty"`
+	StatefulSetName string `json:"statefulSetName,omitempty"`
+}
+
+type fluentdReloadList struct {
+	Items []fluentdReload `json:"items"`
+}
+
+// newFluentdReloadSpec returns the spec of the FluentdReload of a target
+func newFluentdReloadSpec(config Config) fluentdReloadSpec {
+	spec := fluentdReloadSpec{Target: config.Name, Selector: config.Selector, StatefulSetName: config.StatefulSetName}
+	if config.CertFile == "" && config.CertSource == nil {
+		spec.CertNamespace, spec.CertName = config.CertNamespace, config.CertName
+	}
+
+	return spec
+}
+
+// fluentdReloadStatus is the outcome of the last check of the target, shown by
+// the printer columns of kubectl get fluentdreloads
+type fluentdReloadStatus struct {
+	// Synced is True, False or Unknown like the CertInSync condition
+	Synced         string       `json:"synced,omitempty"`
+	Status         string       `json:"status,omitempty"`
+	Reason         string       `json:"reason,omitempty"`
+	Message        string       `json:"message,omitempty"`
+	RunID          string       `json:"runId,omitempty"`
+	LastCheck      *metav1.Time `json:"lastCheck,omitempty"`
+	LastReload     *metav1.Time `json:"lastReload,omitempty"`
+	ServedExpiry   *metav1.Time `json:"servedExpiry,omitempty"`
+	ExpectedExpiry *metav1.Time `json:"expectedExpiry,omitempty"`
+}
+
+// fluentdReloadName is the name of the FluentdReload of a target, the target
+// name or else its certificate or secret name
+func fluentdReloadName(config Config) string {
+	name := config.Name
+	for _, n := range []string{config.CertName, config.SecretName, "fluentd"} {
+		if name != "" {
+			break
+		}
+		name = n
+	}
+
+	name = strings.ToLower(strings.ReplaceAll(name, "/", "."))
+	if len(name) > 253 {
+		name = name[:253]
+	}
+
+	return strings.Trim(name, "-.")
+}
+
+// newFluentdReloadStatus returns the status of a check's report, lastReload is
+// only set when the check reloaded fluentd so the merge patch keeps the previous one
+func newFluentdReloadStatus(s TargetReport, checkErr error) fluentdReloadStatus {
+	condition := newSyncCondition(s, checkErr)
+	checked := metav1.NewTime(condition.LastProbeTime)
+	status := fluentdReloadStatus{
+		Synced:    condition.Status,
+		Status:    s.Status,
+		Reason:    condition.Reason,
+		Message:   condition.Message,
+		RunID:     s.RunID,
+		LastCheck: &checked,
+	}
+	if checkErr != nil && status.Status == "" {
+		status.Status = StatusError
+	}
+	if s.Status == StatusReloaded {
+		status.LastReload = &checked
+	}
+	if !s.ServedNotAfter.IsZero() {
+		status.ServedExpiry = &metav1.Time{Time: s.ServedNotAfter.UTC()}
+	}
+	if !s.ExpectedNotAfter.IsZero() {
+		status.ExpectedExpiry = &metav1.Time{Time: s.ExpectedNotAfter.UTC()}
+	}
+
+	return status
+}
+
+func (a app) fluentdReloadsURI() string {
+	return fmt.Sprintf("/apis/%s/namespaces/%s/%s", fluentdReloadAPIVersion, a.namespace, fluentdReloadResource)
+}
+
+// getFluentdReload returns nil when the FluentdReload does not exist
+func (a app) getFluentdReload(ctx context.Context, name string) (*fluentdReload, error) {
+	b, err := a.client.Discovery().RESTClient().Get().AbsPath(a.fluentdReloadsURI(), name).DoRaw(ctx)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fluentdreload %s: %w", name, err)
+	}
+
+	fr := &fluentdReload{}
+	if err := json.Unmarshal(b, fr); err != nil {
+		return nil, fmt.Errorf("failed to decode fluentdreload %s: %w", name, err)
+	}
+
+	return fr, nil
+}
+
+// recordFluentdReload writes the outcome of the check to the status of the
+// target's FluentdReload, creating the resource when it is missing
+func (a app) recordFluentdReload(ctx context.Context, config Config, s TargetReport, checkErr error) error {
+	name := fluentdReloadName(config)
+	client := a.client.Discovery().RESTClient()
+	spec := newFluentdReloadSpec(config)
+
+	existing, err := a.getFluentdReload(ctx, name)
+	switch {
+	case err != nil:
+		return err
+	case existing == nil:
+		body, err := json.Marshal(fluentdReload{
+			APIVersion: fluentdReloadAPIVersion,
+			Kind:       fluentdReloadKind,
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: a.namespace, Finalizers: []string{fluentdReloadFinalizer}},
+			Spec:       spec,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode fluentdreload %s: %w", name, err)
+		}
+		err = client.Post().AbsPath(a.fluentdReloadsURI()).Body(body).Do(ctx).Error()
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create fluentdreload %s: %w", name, err)
+		}
+	case existing.Spec != spec || !hasFinalizer(existing.Finalizers):
+		// the resource version makes the patch fail instead of dropping a finalizer added meanwhile
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"finalizers":      withFinalizer(existing.Finalizers),
+				"resourceVersion": existing.ResourceVersion,
+			},
+			"spec": spec,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode fluentdreload %s: %w", name, err)
+		}
+		err = client.Patch(types.MergePatchType).AbsPath(a.fluentdReloadsURI(), name).Body(patch).Do(ctx).Error()
+		if err != nil {
+			return fmt.Errorf("failed to update fluentdreload %s: %w", name, err)
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"status": newFluentdReloadStatus(s, checkErr)})
+	if err != nil {
+		return fmt.Errorf("failed to encode fluentdreload status: %w", err)
+	}
+	err = client.Patch(types.MergePatchType).AbsPath(a.fluentdReloadsURI(), name, "status").Body(patch).Do(ctx).Error()
+	if err != nil {
+		return fmt.Errorf("failed to update the status of fluentdreload %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// finalizeFluentdReload removes the state recorded for the target of a deleted
+// FluentdReload, from the spec so it works for dropped targets too, and then
+// releases the resource
+func (a app) finalizeFluentdReload(ctx context.Context, fr fluentdReload) error {
+	if !hasFinalizer(fr.Finalizers) {
+		return nil
+	}
+
+	target := app{
+		namespace:     a.namespace,
+		certNamespace: fr.Spec.CertNamespace,
+		certName:      fr.Spec.CertName,
+		selector:      fr.Spec.Selector,
+		statefulSet:   fr.Spec.StatefulSetName,
+		client:        a.client,
+	}
+	if target.selector != "" || target.statefulSet != "" {
+		if _, err := cleanup(ctx, target, Config{}); err != nil {
+			return fmt.Errorf("failed to clean up target of fluentdreload %s: %w", fr.Name, err)
+		}
+	}
+
+	return a.releaseFluentdReload(ctx, fr)
+}
+
+// releaseFluentdReload removes the finalizer of the reloader
+func (a app) releaseFluentdReload(ctx context.Context, fr fluentdReload) error {
+	finalizers := []string{}
+	for _, f := range fr.Finalizers {
+		if f != fluentdReloadFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"finalizers": finalizers, "resourceVersion": fr.ResourceVersion},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode fluentdreload %s: %w", fr.Name, err)
+	}
+
+	err = a.client.Discovery().RESTClient().Patch(types.MergePatchType).AbsPath(a.fluentdReloadsURI(), fr.Name).Body(patch).Do(ctx).Error()
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to remove the finalizer of fluentdreload %s: %w", fr.Name, err)
+	}
+	log.Printf("Cleaned up fluentdreload %s", fr.Name)
+
+	return nil
+}
+
+// deleteFluentdReload deletes a FluentdReload and returns it as deleted, nil
+// when it is already gone
+func (a app) deleteFluentdReload(ctx context.Context, name string) (*fluentdReload, error) {
+	err := a.client.Discovery().RESTClient().Delete().AbsPath(a.fluentdReloadsURI(), name).Do(ctx).Error()
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete fluentdreload %s: %w", name, err)
+	}
+
+	return a.getFluentdReload(ctx, name)
+}
+
+// pruneFluentdReloads deletes and finalizes the FluentdReloads of targets
+// dropped from the targets ConfigMap or no longer matching the cert name
+// pattern, in the namespaces of the configured targets
+func pruneFluentdReloads(ctx context.Context, cfg Config, targets []Config) {
+	if !cfg.StatusResource || (cfg.TargetsConfigMap == "" && !IsCertNamePattern(cfg.CertName)) {
+		return
+	}
+
+	configured := map[string]map[string]bool{}
+	for _, target := range targets {
+		if configured[target.Namespace] == nil {
+			configured[target.Namespace] = map[string]bool{}
+		}
+		configured[target.Namespace][fluentdReloadName(target)] = true
+	}
+
+	for namespace, names := range configured {
+		a := app{namespace: namespace, client: cfg.Client}
+		b, err := a.client.Discovery().RESTClient().Get().AbsPath(a.fluentdReloadsURI()).DoRaw(ctx)
+		if err != nil {
+			log.Printf("Failed to list fluentdreloads in namespace %s: %v", namespace, err)
+			continue
+		}
+		list := fluentdReloadList{}
+		if err := json.Unmarshal(b, &list); err != nil {
+			log.Printf("Failed to decode fluentdreloads of namespace %s: %v", namespace, err)
+			continue
+		}
+
+		for _, fr := range list.Items {
+			// the resources of single targets have no target name and are left alone
+			if names[fr.Name] || fr.Spec.Target == "" || !hasFinalizer(fr.Finalizers) {
+				continue
+			}
+
+			log.Printf("Target %s of fluentdreload %s was dropped, cleaning it up", fr.Spec.Target, fr.Name)
+			deleted := &fr
+			if fr.DeletionTimestamp == nil {
+				if deleted, err = a.deleteFluentdReload(ctx, fr.Name); err != nil || deleted == nil {
+					if err != nil {
+						log.Println(err)
+					}
+					continue
+				}
+			}
+			if err := a.finalizeFluentdReload(ctx, *deleted); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+func hasFinalizer(finalizers []string) bool {
+	for _, f := range finalizers {
+		if f == fluentdReloadFinalizer {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withFinalizer(finalizers []string) []string {
+	if hasFinalizer(finalizers) {
+		return finalizers
+	}
+
+	return append(append([]string{}, finalizers...), fluentdReloadFinalizer)
+}
@@ -0,0 +1,318 @@
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fluentdReloadServer is an API server storing FluentdReloads in memory, a
+// fluentd pod is listed and its patches are recorded
+type fluentdReloadServer struct {
+	mu      sync.Mutex
+	objects map[string]map[string]interface{}
+	// podPatches are the merge patches of the fluentd pod
+	podPatches []string
+}
+
+func newFluentdReloadServer(t *testing.T, objects ...fluentdReload) (*fluentdReloadServer, kubernetes.Interface) {
+	s := &fluentdReloadServer{objects: map[string]map[string]interface{}{}}
+	for _, fr := range objects {
+		fr.APIVersion, fr.Kind, fr.Namespace = fluentdReloadAPIVersion, fluentdReloadKind, "logging"
+		s.objects[fr.Name] = toObject(t, fr)
+	}
+
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, client
+}
+
+func (s *fluentdReloadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	if strings.HasPrefix(r.URL.Path, "/api/v1/namespaces/logging/pods") {
+		s.servePods(w, r, body)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/apis/fluentd-reloader.io/v1alpha1/namespaces/logging/fluentdreloads"), "/")
+	name := ""
+	if len(parts) > 1 {
+		name = parts[1]
+	}
+	obj := s.objects[name]
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		items := []map[string]interface{}{}
+		for _, obj := range s.objects {
+			items = append(items, obj)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	case r.Method == http.MethodPost:
+		created := map[string]interface{}{}
+		_ = json.Unmarshal(body, &created)
+		s.objects[created["metadata"].(map[string]interface{})["name"].(string)] = created
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(created)
+	case obj == nil:
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(metav1.Status{
+			TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound,
+		})
+	case r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(obj)
+	case r.Method == http.MethodPatch:
+		patch := map[string]interface{}{}
+		_ = json.Unmarshal(body, &patch)
+		if len(parts) > 2 {
+			// the status subresource only takes the status
+			patch = map[string]interface{}{"status": patch["status"]}
+		}
+		mergePatch(obj, patch)
+		s.collect(name)
+		_ = json.NewEncoder(w).Encode(obj)
+	case r.Method == http.MethodDelete:
+		obj["metadata"].(map[string]interface{})["deletionTimestamp"] = metav1.Now().UTC().Format("2006-01-02T15:04:05Z")
+		s.collect(name)
+		_ = json.NewEncoder(w).Encode(obj)
+	}
+}
+
+// collect removes a deleted FluentdReload once its finalizers are gone
+func (s *fluentdReloadServer) collect(name string) {
+	metadata := s.objects[name]["metadata"].(map[string]interface{})
+	finalizers, _ := metadata["finalizers"].([]interface{})
+	if metadata["deletionTimestamp"] != nil && len(finalizers) == 0 {
+		delete(s.objects, name)
+	}
+}
+
+func (s *fluentdReloadServer) servePods(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.Method == http.MethodPatch {
+		s.podPatches = append(s.podPatches, string(body))
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind":       "PodList",
+		"apiVersion": "v1",
+		"metadata":   map[string]interface{}{},
+		"items": []map[string]interface{}{{
+			"metadata": map[string]interface{}{
+				"name":        "fluentd-0",
+				"namespace":   "logging",
+				"labels":      map[string]string{"app": "fluentd", "statefulset.kubernetes.io/pod-name": "fluentd-0"},
+				"annotations": map[string]string{certFingerprintAnnotation: "abc"},
+			},
+		}},
+	})
+}
+
+func (s *fluentdReloadServer) get(t *testing.T, name string) *fluentdReload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj := s.objects[name]
+	if obj == nil {
+		return nil
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := &fluentdReload{}
+	if err := json.Unmarshal(b, fr); err != nil {
+		t.Fatal(err)
+	}
+
+	return fr
+}
+
+func toObject(t *testing.T, v interface{}) map[string]interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		t.Fatal(err)
+	}
+
+	return obj
+}
+
+// mergePatch applies a JSON merge patch to obj
+func mergePatch(obj, patch map[string]interface{}) {
+	for key, value := range patch {
+		nested, ok := value.(map[string]interface{})
+		switch {
+		case value == nil:
+			delete(obj, key)
+		case ok:
+			target, _ := obj[key].(map[string]interface{})
+			if target == nil {
+				target = map[string]interface{}{}
+				obj[key] = target
+			}
+			mergePatch(target, nested)
+		default:
+			obj[key] = value
+		}
+	}
+}
+
+func TestRecordFluentdReload(t *testing.T) {
+	config := Config{Name: "tenant-a", Namespace: "logging", Selector: "app=fluentd", CertNamespace: "logging", CertName: "tenant-a-tls"}
+	spec := newFluentdReloadSpec(config)
+
+	tests := []struct {
+		name           string
+		existing       []fluentdReload
+		wantFinalizers []string
+	}{
+		{name: "created", wantFinalizers: []string{fluentdReloadFinalizer}},
+		{
+			name: "finalizer added",
+			existing: []fluentdReload{{
+				ObjectMeta: metav1.ObjectMeta{Name: "tenant-a", Finalizers: []string{"example.com/keep"}},
+				Spec:       spec,
+			}},
+			wantFinalizers: []string{"example.com/keep", fluentdReloadFinalizer},
+		},
+		{
+			name: "spec updated",
+			existing: []fluentdReload{{
+				ObjectMeta: metav1.ObjectMeta{Name: "tenant-a", Finalizers: []string{fluentdReloadFinalizer}},
+				Spec:       fluentdReloadSpec{Target: "tenant-a", Selector: "app=old"},
+			}},
+			wantFinalizers: []string{fluentdReloadFinalizer},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client := newFluentdReloadServer(t, tt.existing...)
+			a := app{namespace: "logging", client: client}
+
+			err := a.recordFluentdReload(context.Background(), config, TargetReport{Status: StatusReloaded}, nil)
+			if err != nil {
+				t.Fatalf("recordFluentdReload() error = %v", err)
+			}
+
+			fr := srv.get(t, "tenant-a")
+			if fr == nil {
+				t.Fatal("fluentdreload tenant-a does not exist")
+			}
+			if !reflect.DeepEqual(fr.Finalizers, tt.wantFinalizers) {
+				t.Errorf("finalizers = %v, want %v", fr.Finalizers, tt.wantFinalizers)
+			}
+			if fr.Spec != spec {
+				t.Errorf("spec = %+v, want %+v", fr.Spec, spec)
+			}
+			if fr.Status.Status != StatusReloaded || fr.Status.LastReload == nil {
+				t.Errorf("status = %+v, want a reload recorded", fr.Status)
+			}
+		})
+	}
+}
+
+func TestFinalizeFluentdReload(t *testing.T) {
+	deleted := metav1.Now()
+	tests := []struct {
+		name       string
+		finalizers []string
+		spec       fluentdReloadSpec
+		// wantFinalizers are left on the resource, nil when it is gone
+		wantFinalizers []string
+		wantPodPatches int
+	}{
+		{
+			name:       "target cleaned up",
+			finalizers: []string{fluentdReloadFinalizer},
+			spec:       fluentdReloadSpec{Target: "tenant-a", Selector: "app=fluentd"},
+			// the annotations of the fluentd pod are removed
+			wantPodPatches: 1,
+		},
+		{
+			name:           "other finalizers are kept",
+			finalizers:     []string{"example.com/keep", fluentdReloadFinalizer},
+			spec:           fluentdReloadSpec{Target: "tenant-a"},
+			wantFinalizers: []string{"example.com/keep"},
+		},
+		{
+			name:           "released by another reloader",
+			finalizers:     []string{"example.com/keep"},
+			spec:           fluentdReloadSpec{Target: "tenant-a", Selector: "app=fluentd"},
+			wantFinalizers: []string{"example.com/keep"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fr := fluentdReload{
+				ObjectMeta: metav1.ObjectMeta{Name: "tenant-a", Finalizers: tt.finalizers, DeletionTimestamp: &deleted},
+				Spec:       tt.spec,
+			}
+			srv, client := newFluentdReloadServer(t, fr)
+			a := app{namespace: "logging", client: client}
+
+			if err := a.finalizeFluentdReload(context.Background(), fr); err != nil {
+				t.Fatalf("finalizeFluentdReload() error = %v", err)
+			}
+
+			got := srv.get(t, "tenant-a")
+			switch {
+			case tt.wantFinalizers == nil && got != nil:
+				t.Errorf("fluentdreload still exists with finalizers %v", got.Finalizers)
+			case tt.wantFinalizers != nil && got == nil:
+				t.Errorf("fluentdreload is gone, want finalizers %v", tt.wantFinalizers)
+			case got != nil && !reflect.DeepEqual(got.Finalizers, tt.wantFinalizers):
+				t.Errorf("finalizers = %v, want %v", got.Finalizers, tt.wantFinalizers)
+			}
+			if len(srv.podPatches) != tt.wantPodPatches {
+				t.Errorf("pod was patched %d times, want %d", len(srv.podPatches), tt.wantPodPatches)
+			}
+		})
+	}
+}
+
+func TestPruneFluentdReloads(t *testing.T) {
+	resource := func(name, target string) fluentdReload {
+		return fluentdReload{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Finalizers: []string{fluentdReloadFinalizer}},
+			Spec:       fluentdReloadSpec{Target: target},
+		}
+	}
+	srv, client := newFluentdReloadServer(t,
+		resource("tenant-a", "tenant-a"),
+		resource("tenant-b", "tenant-b"),
+		// written for a single target configured through the environment
+		resource("fluentd-tls", ""),
+	)
+
+	cfg := Config{StatusResource: true, TargetsConfigMap: "targets", Client: client}
+	pruneFluentdReloads(context.Background(), cfg, []Config{{Name: "tenant-a", Namespace: "logging"}})
+
+	for name, want := range map[string]bool{"tenant-a": true, "tenant-b": false, "fluentd-tls": true} {
+		if got := srv.get(t, name) != nil; got != want {
+			t.Errorf("fluentdreload %s exists = %v, want %v", name, got, want)
+		}
+	}
+}
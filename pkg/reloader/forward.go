@@ -0,0 +1,86 @@
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const forwardPollInterval = 2 * time.Second
+
+// heloPrefix starts the HELO message fluentd's forward input sends when
+// shared key authentication is enabled, a msgpack array of two with "HELO"
+var heloPrefix = []byte{0x92, 0xa4, 'H', 'E', 'L', 'O'}
+
+// checkForwardInputs waits until the forward input of every target accepts connections again
+func checkForwardInputs(ctx context.Context, cfg Config, targets []target) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.ForwardCheckTimeout)
+	defer cancel()
+
+	for _, t := range targets {
+		host, _, err := net.SplitHostPort(t.host)
+		if err != nil {
+			return fmt.Errorf("failed to parse host of %s: %w", t, err)
+		}
+		address := net.JoinHostPort(host, strconv.Itoa(cfg.ForwardPort))
+
+		for {
+			if err = checkForward(ctx, cfg, address); err == nil {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("forward input of %s is not accepting connections after the reload: %w", t, err)
+			case <-time.After(forwardPollInterval):
+			}
+		}
+		log.Println("Forward input of", t, "is accepting connections")
+	}
+
+	return nil
+}
+
+// checkForward connects to a forward input and, when the input greets the client,
+// verifies the greeting is a fluentd HELO
+func checkForward(ctx context.Context, cfg Config, address string) error {
+	dialer := &net.Dialer{Timeout: cfg.RPCTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	if cfg.ForwardTLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: cfg.ServiceURL})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return fmt.Errorf("failed TLS handshake with %s: %w", address, err)
+		}
+		conn = tlsConn
+	}
+
+	// without shared key authentication the input stays silent until the client sends events
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		return err
+	}
+	greeting := make([]byte, len(heloPrefix))
+	_, err = io.ReadFull(conn, greeting)
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return nil
+	case err != nil:
+		return fmt.Errorf("forward input %s closed the connection: %w", address, err)
+	case !bytes.Equal(greeting, heloPrefix):
+		return fmt.Errorf("forward input %s sent an unexpected greeting %x", address, greeting)
+	}
+
+	return nil
+}
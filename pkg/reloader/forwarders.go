@@ -0,0 +1,62 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// forwarderConfig returns the config reloading the forwarders of the target
+func forwarderConfig(cfg Config) Config {
+	f := cfg
+	f.Namespace = cfg.ForwarderNamespace
+	f.Selector = cfg.ForwarderSelector
+	f.StatefulSetName = ""
+	if cfg.ReloadVia != ReloadViaAPIProxy {
+		f.ReloadVia = ReloadViaPodIP
+	}
+	f.RPCPort = cfg.ForwarderRPCPort
+	f.RPCPortName = ""
+	f.RPCWorkers = 0
+	f.ReloadURLTemplate = ""
+	f.ReloadStrategy = cfg.ForwarderReloadStrategy
+	f.FallbackStrategy = ""
+	f.VerifyConfigDump = false
+	// the hooks drain the aggregators
+	f.PreReloadHook, f.PostReloadHook = nil, nil
+
+	return f
+}
+
+// reloadForwarders reloads the forwarder pods after the aggregators were
+// reloaded, so they establish new TLS connections to the aggregators
+func reloadForwarders(ctx context.Context, a app, cfg Config, s *TargetReport) error {
+	if cfg.ForwarderWaveDelay > 0 {
+		log.Printf("Waiting %v before reloading the forwarders", cfg.ForwarderWaveDelay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cfg.ForwarderWaveDelay):
+		}
+	}
+
+	fcfg := forwarderConfig(cfg)
+	fa := a
+	fa.namespace, fa.selector, fa.statefulSet = fcfg.Namespace, fcfg.Selector, ""
+	// the pods are listed from the API, the pod cache only serves the aggregators
+	fa.podCache = nil
+	targets, _, err := fa.getFluentdTargets(ctx, fcfg)
+	if err != nil {
+		return fmt.Errorf("failed to get forwarder pods: %w", err)
+	}
+
+	log.Printf("Reloading %d forwarders", len(targets))
+	actions, err := reloadFluentdConfig(ctx, newReloader(fa, fcfg), fixedPause(fcfg.ReloadPause), targets...)
+	s.ForwarderActions = actions
+	if err != nil {
+		return fmt.Errorf("failed to reload forwarders: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// gateHealth splits the targets into the ones that can be reloaded and the
+// unhealthy ones, those pick up the new certificate when they are restarted
+// so reloading them would only fail
+func gateHealth(ctx context.Context, cfg Config, targets []target) ([]target, []ReloadAction) {
+	ready := make([]target, 0, len(targets))
+	var unhealthy []ReloadAction
+	for _, t := range targets {
+		if err := checkHealth(ctx, cfg, t); err != nil {
+			log.Printf("Not reloading unhealthy %s: %v", t, err)
+			unhealthy = append(unhealthy, ReloadAction{Target: t.String(), Outcome: OutcomeUnhealthy, Error: err.Error()})
+			continue
+		}
+		ready = append(ready, t)
+	}
+
+	return ready, unhealthy
+}
+
+// checkHealth checks the pod is ready and its monitor_agent answers, a plain
+// HTTP endpoint every fluentd version serves
+func checkHealth(ctx context.Context, cfg Config, t target) error {
+	if t.pod != nil && !podReady(*t.pod) {
+		return fmt.Errorf("pod is not ready")
+	}
+
+	url, err := monitorURL(cfg, t, "/api/plugins.json")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create health request: %w", err)
+	}
+	setRunIDHeader(req)
+
+	client := podClient(cfg, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("monitor_agent is not reachable: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(fmt.Errorf("monitor_agent answered %s", resp.Status), resp.StatusCode, "")
+	}
+
+	return nil
+}
+
+func podReady(pod corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
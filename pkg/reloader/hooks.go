@@ -0,0 +1,202 @@
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// HookFailure values decide what happens when a reload hook fails
+const (
+	// HookFailureAbort fails the reload of the pod, a failed pre-reload hook
+	// leaves the pod unreloaded
+	HookFailureAbort = "abort"
+	// HookFailureContinue only logs the failure
+	HookFailureContinue = "continue"
+)
+
+// hook phases, sent to webhooks
+const (
+	hookPreReload  = "pre-reload"
+	hookPostReload = "post-reload"
+)
+
+// ReloadHook runs before or after fluentd is reloaded on a pod, e.g. to drain
+// the pod from a load balancer and enable it again. It either calls URL or
+// executes Command in the fluentd container.
+type ReloadHook struct {
+	URL     string
+	Command []string
+	// Timeout defaults to 10s
+	Timeout time.Duration
+	// FailurePolicy is HookFailureAbort or HookFailureContinue, defaults to HookFailureAbort
+	FailurePolicy string
+}
+
+// hookRequest is the body POSTed to a webhook
+type hookRequest struct {
+	Phase     string `json:"phase"`
+	Target    string `json:"target"`
+	Pod       string `json:"pod,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	RunID     string `json:"runId,omitempty"`
+}
+
+func (h *ReloadHook) withDefaults() *ReloadHook {
+	if h == nil {
+		return nil
+	}
+
+	hook := *h
+	if hook.Timeout == 0 {
+		hook.Timeout = 10 * time.Second
+	}
+	if hook.FailurePolicy == "" {
+		hook.FailurePolicy = HookFailureAbort
+	}
+
+	return &hook
+}
+
+// Validate checks that the hook either calls a URL or executes a command
+func (h ReloadHook) Validate() error {
+	if (h.URL == "") == (len(h.Command) == 0) {
+		return fmt.Errorf("a reload hook needs either a url or a command")
+	}
+	switch h.FailurePolicy {
+	case "", HookFailureAbort, HookFailureContinue:
+	default:
+		return fmt.Errorf("invalid hook failure policy %q, must be %s or %s", h.FailurePolicy, HookFailureAbort, HookFailureContinue)
+	}
+
+	return nil
+}
+
+// execHooks reports whether a hook executes a command in the fluentd container
+func (c Config) execHooks() bool {
+	return (c.PreReloadHook != nil && len(c.PreReloadHook.Command) > 0) ||
+		(c.PostReloadHook != nil && len(c.PostReloadHook.Command) > 0)
+}
+
+// hookedReloader runs the pre-reload and post-reload hooks around every reload
+type hookedReloader struct {
+	Reloader
+	app       app
+	container string
+	pre       *ReloadHook
+	post      *ReloadHook
+}
+
+func (r hookedReloader) Reload(ctx context.Context, t target) error {
+	_, err := r.ReloadWorkers(ctx, t)
+	return err
+}
+
+func (r hookedReloader) ReloadWorkers(ctx context.Context, t target) ([]WorkerResult, error) {
+	results, _, err := r.reloadOrFallback(ctx, t)
+	return results, err
+}
+
+func (r hookedReloader) reloadOrFallback(ctx context.Context, t target) ([]WorkerResult, bool, error) {
+	if err := r.runHook(ctx, r.pre, hookPreReload, t); err != nil {
+		return nil, false, err
+	}
+
+	results, fellBack, err := reloadOrFallback(ctx, r.Reloader, t)
+	if err != nil {
+		// the post-reload hook still runs, e.g. to enable the pod in the load balancer again
+		if hookErr := r.runHook(ctx, r.post, hookPostReload, t); hookErr != nil {
+			log.Println(hookErr)
+		}
+		return results, fellBack, err
+	}
+
+	return results, fellBack, r.runHook(ctx, r.post, hookPostReload, t)
+}
+
+// ConfigHash keeps the config dump of the wrapped reloader available
+func (r hookedReloader) ConfigHash(ctx context.Context, t target) (string, error) {
+	hasher, ok := r.Reloader.(configHasher)
+	if !ok {
+		return "", fmt.Errorf("reload strategy cannot read the config of %s", t)
+	}
+
+	return hasher.ConfigHash(ctx, t)
+}
+
+// runHook runs the hook of the phase, the error is nil when the failure policy continues
+func (r hookedReloader) runHook(ctx context.Context, hook *ReloadHook, phase string, t target) error {
+	if hook == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	var err error
+	if hook.URL != "" {
+		err = callWebhook(ctx, hook.URL, phase, t)
+	} else {
+		err = r.execHook(ctx, hook.Command, t)
+	}
+	if err == nil {
+		log.Printf("Ran the %s hook of %s", phase, t)
+		return nil
+	}
+
+	err = fmt.Errorf("%s hook of %s failed: %w", phase, t, err)
+	if hook.FailurePolicy == HookFailureContinue {
+		log.Printf("%v, continuing", err)
+		return nil
+	}
+
+	return err
+}
+
+func (r hookedReloader) execHook(ctx context.Context, command []string, t target) error {
+	if t.pod == nil {
+		return fmt.Errorf("target %s is not a pod, cannot exec into it", t)
+	}
+
+	out, err := r.app.execInPod(ctx, *t.pod, r.container, command...)
+	if len(out) > 0 {
+		log.Printf("Hook output: %s", bytes.TrimSpace(out))
+	}
+
+	return err
+}
+
+// callWebhook POSTs the phase and the pod to the webhook, any 2xx status passes
+func callWebhook(ctx context.Context, url, phase string, t target) error {
+	body := hookRequest{Phase: phase, Target: t.String(), RunID: RunID(ctx)}
+	if t.pod != nil {
+		body.Pod, body.Namespace = t.pod.Name, t.pod.Namespace
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode hook request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setRunIDHeader(req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(fmt.Errorf("webhook answered %s", resp.Status), resp.StatusCode, "")
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+)
+
+// checkExpectedHostnames returns an error unless the expected certificate is
+// valid for every host served a mismatching certificate. Only then a reload
+// fixes the mismatch, otherwise every check would reload fluentd again.
+func (a app) checkExpectedHostnames(ctx context.Context, certificate cmapi.Certificate, hosts []string) error {
+	chain, err := a.getCertificateChain(ctx, certificate)
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		if err := chain[0].VerifyHostname(host); err != nil {
+			return terminal(fmt.Errorf("neither the served nor the expected certificate %s is valid for %s, it is issued for %s",
+				certificate.Name, host, strings.Join(chain[0].DNSNames, ", ")), "add the hostname to the certificate")
+		}
+	}
+
+	return nil
+}
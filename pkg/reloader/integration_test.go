@@ -0,0 +1,448 @@
+package reloader
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const testServiceURL = "fluentd.logging.svc"
+
+// envtestConfig is set when KUBEBUILDER_ASSETS points at the etcd and
+// kube-apiserver binaries, the integration tests then also run against them
+var envtestConfig *rest.Config
+
+func TestMain(m *testing.M) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		os.Exit(m.Run())
+	}
+
+	env := &envtest.Environment{}
+	cfg, err := env.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start envtest: %v\n", err)
+		os.Exit(1)
+	}
+	envtestConfig = cfg
+	code := m.Run()
+	if err := env.Stop(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to stop envtest: %v\n", err)
+	}
+	os.Exit(code)
+}
+
+// testCluster is the API the reloader runs against, evicted reports whether
+// the pod was evicted
+type testCluster struct {
+	client  kubernetes.Interface
+	evicted func(t *testing.T, namespace, name string) bool
+}
+
+// testClusters returns a fake clientset and, with KUBEBUILDER_ASSETS, an
+// envtest API server, each scenario gets its own namespace
+func testClusters(t *testing.T) map[string]func(t *testing.T) testCluster {
+	clusters := map[string]func(t *testing.T) testCluster{
+		"fake": func(t *testing.T) testCluster {
+			client := fake.NewSimpleClientset()
+			return testCluster{client: client, evicted: func(t *testing.T, namespace, name string) bool {
+				for _, action := range client.Actions() {
+					create, ok := action.(k8stesting.CreateAction)
+					if ok && create.GetSubresource() == "eviction" && create.GetNamespace() == namespace {
+						if eviction, ok := create.GetObject().(metav1.Object); ok && eviction.GetName() == name {
+							return true
+						}
+					}
+				}
+				return false
+			}}
+		},
+	}
+	if envtestConfig != nil {
+		clusters["envtest"] = func(t *testing.T) testCluster {
+			client, err := kubernetes.NewForConfig(envtestConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return testCluster{client: client, evicted: func(t *testing.T, namespace, name string) bool {
+				pod, err := client.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+				if apierrors.IsNotFound(err) {
+					return true
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				return pod.DeletionTimestamp != nil
+			}}
+		}
+	}
+
+	return clusters
+}
+
+// testPKI issues the certificates fluentd serves from a test CA
+type testPKI struct {
+	ca    *x509.Certificate
+	key   *ecdsa.PrivateKey
+	roots *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	return &testPKI{ca: ca, key: key, roots: roots}
+}
+
+// issue returns a certificate for the fluentd service expiring at notAfter
+func (p *testPKI) issue(t *testing.T, serial int64, notAfter time.Time) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: testServiceURL},
+		DNSNames:     []string{testServiceURL},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter.Truncate(time.Second),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, &key.PublicKey, p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// servingEndpoint stands in for the fluentd service, serving a certificate
+// that is swapped when fluentd reloads
+type servingEndpoint struct {
+	listener net.Listener
+
+	mu   sync.Mutex
+	cert tls.Certificate
+}
+
+func newServingEndpoint(t *testing.T, cert tls.Certificate) *servingEndpoint {
+	e := &servingEndpoint{cert: cert}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			return &e.cert, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.listener = listener
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+
+	return e
+}
+
+func (e *servingEndpoint) serve(cert tls.Certificate) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cert = cert
+}
+
+// fluentdStub is the RPC endpoint of every fluentd pod, it records the calls
+// and answers them with the queued status codes, 200 once they are used up
+type fluentdStub struct {
+	server *httptest.Server
+	// onReload is called for every successful reload
+	onReload func()
+
+	mu        sync.Mutex
+	calls     []string
+	responses map[string][]int
+}
+
+func newFluentdStub(t *testing.T) *fluentdStub {
+	s := &fluentdStub{responses: map[string][]int{}}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.calls = append(s.calls, r.Host+r.URL.Path)
+		status := http.StatusOK
+		if queued := s.responses[r.URL.Path]; len(queued) > 0 {
+			status, s.responses[r.URL.Path] = queued[0], queued[1:]
+		}
+		onReload := s.onReload
+		s.mu.Unlock()
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		if onReload != nil {
+			onReload()
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	t.Cleanup(s.server.Close)
+
+	return s
+}
+
+// respond queues status codes for the calls of an endpoint
+func (s *fluentdStub) respond(path string, statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[path] = append(s.responses[path], statuses...)
+}
+
+func (s *fluentdStub) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.calls...)
+}
+
+// client sends the requests to the pods to the stub, keeping the pod address as Host
+func (s *fluentdStub) client() HTTPClient {
+	stub := strings.TrimPrefix(s.server.URL, "http://")
+	return &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.Host = req.URL.Host
+		req.URL.Host = stub
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// createFluentd creates the TLS secret with the expected certificate and
+// running fluentd pods of a StatefulSet with the given IPs
+func createFluentd(t *testing.T, client kubernetes.Interface, namespace string, expected tls.Certificate, ips ...string) {
+	ctx := context.Background()
+	_, err := client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: expected.Certificate[0]})
+	_, err = client.CoreV1().Secrets(namespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "fluentd-tls"},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: crt, corev1.TLSPrivateKeyKey: []byte("unused")},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ip := range ips {
+		name := fmt.Sprintf("fluentd-%d", i)
+		pod, err := client.CoreV1().Pods(namespace).Create(ctx, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"app": "fluentd", "statefulset.kubernetes.io/pod-name": name},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "fluentd", Image: "fluentd"}}},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pod.Status = corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      ip,
+			PodIPs:     []corev1.PodIP{{IP: ip}},
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		}
+		if _, err := client.CoreV1().Pods(namespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRunIntegration(t *testing.T) {
+	const (
+		graceful = "/api/config.gracefulReload"
+		reload   = "/api/config.reload"
+	)
+
+	tests := []struct {
+		name string
+		pods []string
+		// stub queues the responses of the fluentd RPC endpoints
+		stub     func(s *fluentdStub)
+		fallback string
+		// want are the recorded RPC calls
+		want        []string
+		wantReason  string
+		wantEvicted bool
+	}{
+		{
+			name: "mismatch is reloaded and verified",
+			pods: []string{"10.0.0.1", "10.0.0.2"},
+			want: []string{"10.0.0.1:24444" + graceful, "10.0.0.2:24444" + graceful},
+		},
+		{
+			name: "failed reload is retried",
+			pods: []string{"10.0.0.1"},
+			stub: func(s *fluentdStub) { s.respond(graceful, http.StatusServiceUnavailable) },
+			want: []string{"10.0.0.1:24444" + graceful, "10.0.0.1:24444" + graceful},
+		},
+		{
+			name: "missing graceful reload falls back to config.reload",
+			pods: []string{"10.0.0.1"},
+			stub: func(s *fluentdStub) { s.respond(graceful, http.StatusNotFound) },
+			want: []string{"10.0.0.1:24444" + graceful, "10.0.0.1:24444" + reload},
+		},
+		{
+			name: "unsupported RPC falls back to deleting the pod",
+			pods: []string{"10.0.0.1"},
+			stub: func(s *fluentdStub) {
+				s.respond(graceful, http.StatusNotFound)
+				s.respond(reload, http.StatusNotFound)
+			},
+			fallback:    StrategyPodDelete,
+			want:        []string{"10.0.0.1:24444" + graceful, "10.0.0.1:24444" + reload},
+			wantReason:  ReasonFallbackStrategy,
+			wantEvicted: true,
+		},
+	}
+
+	for clusterName, newCluster := range testClusters(t) {
+		for i, tt := range tests {
+			t.Run(clusterName+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				cluster := newCluster(t)
+				namespace := fmt.Sprintf("logging-%d-%d", i, time.Now().UnixNano()%100000)
+
+				pki := newTestPKI(t)
+				stale := pki.issue(t, 2, time.Now().Add(time.Hour))
+				renewed := pki.issue(t, 3, time.Now().Add(2*time.Hour))
+				endpoint := newServingEndpoint(t, stale)
+				stub := newFluentdStub(t)
+				if tt.stub != nil {
+					tt.stub(stub)
+				}
+				// fluentd serves the renewed certificate once it reloaded
+				stub.onReload = func() { endpoint.serve(renewed) }
+				if tt.wantEvicted {
+					stub.onReload = nil
+				}
+				createFluentd(t, cluster.client, namespace, renewed, tt.pods...)
+
+				cfg := Config{
+					Client:           cluster.client,
+					Namespace:        namespace,
+					ServiceURL:       testServiceURL,
+					SecretName:       "fluentd-tls",
+					Selector:         "app=fluentd",
+					ProbeAddress:     endpoint.listener.Addr().String(),
+					ProbeRootCAs:     pki.roots,
+					ProbeProxy:       ProxyNone,
+					RPCProxy:         ProxyNone,
+					HTTPClient:       stub.client(),
+					FallbackStrategy: tt.fallback,
+				}
+
+				report, err := Run(ctx, cfg)
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+				s := report.Targets[0]
+				if s.Status != StatusReloaded {
+					t.Fatalf("status = %s, want %s", s.Status, StatusReloaded)
+				}
+				if got := stub.recorded(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+					t.Errorf("RPC calls = %v, want %v", got, tt.want)
+				}
+				wantReason := tt.wantReason
+				if wantReason == "" {
+					wantReason = ReasonCertRotation
+				}
+				for _, action := range s.Actions {
+					if action.Outcome != OutcomeReloaded || action.Reason != wantReason {
+						t.Errorf("action %s: outcome %s reason %s, want %s %s", action.Target, action.Outcome, action.Reason, OutcomeReloaded, wantReason)
+					}
+				}
+				if tt.wantEvicted {
+					if !cluster.evicted(t, namespace, "fluentd-0") {
+						t.Errorf("pod fluentd-0 was not evicted")
+					}
+					return
+				}
+
+				// the next check verifies fluentd serves the renewed certificate
+				report, err = Run(ctx, cfg)
+				if err != nil {
+					t.Fatalf("second Run() error = %v", err)
+				}
+				if got := report.Targets[0].Status; got != StatusInSync {
+					t.Errorf("status after the reload = %s, want %s", got, StatusInSync)
+				}
+			})
+		}
+	}
+}
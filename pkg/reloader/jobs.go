@@ -0,0 +1,166 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	jobTargetLabel = "fluentd-reloader.io/target"
+
+	// defaultJobTTL removes finished jobs unless the template sets its own TTL
+	defaultJobTTL = int32(time.Hour / time.Second)
+)
+
+// spawnJob creates a job from the job template checking the target with the
+// one-shot reloader, the job's backoff limit takes care of retries. No job is
+// created while one for the target is still running.
+func spawnJob(ctx context.Context, cfg Config) (Report, error) {
+	ctx, runID := ensureRunID(ctx)
+	s := TargetReport{Target: cfg.Name, Cluster: cfg.Cluster, RunID: runID}
+	report := Report{Targets: []TargetReport{s}}
+	if cfg.reloadsPaused() {
+		log.Printf("Reloads are paused, not creating a job for target %s", cfg.Name)
+		report.Targets[0].Status = StatusReloadPaused
+		report.GeneratedAt = time.Now().UTC()
+		return report, nil
+	}
+	if cfg.reloadsDeferred() {
+		log.Printf("Outside of the reload window, not creating a job for target %s", cfg.Name)
+		report.Targets[0].Status = StatusReloadDeferred
+		report.GeneratedAt = time.Now().UTC()
+		return report, nil
+	}
+	namespace := cfg.JobTemplate.Namespace
+	if namespace == "" {
+		namespace = cfg.Namespace
+	}
+
+	selector := fmt.Sprintf("%s=%s", jobTargetLabel, jobLabelValue(cfg.Name))
+	jobs, err := cfg.Client.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return report, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	for _, job := range jobs.Items {
+		if job.Status.CompletionTime == nil && !jobFailed(job) {
+			log.Printf("Job %s is still checking target %s", job.Name, cfg.Name)
+			report.Targets[0].Status = StatusDelegated
+			report.GeneratedAt = time.Now().UTC()
+			return report, nil
+		}
+	}
+
+	job := cfg.JobTemplate.DeepCopy()
+	job.Namespace = namespace
+	if job.GenerateName == "" {
+		job.GenerateName = strings.TrimSuffix(job.Name, "-") + "-"
+	}
+	if job.GenerateName == "-" {
+		job.GenerateName = "fluentd-reloader-"
+	}
+	job.Name = ""
+	if job.Labels == nil {
+		job.Labels = map[string]string{}
+	}
+	job.Labels[jobTargetLabel] = jobLabelValue(cfg.Name)
+	if job.Spec.TTLSecondsAfterFinished == nil {
+		ttl := defaultJobTTL
+		job.Spec.TTLSecondsAfterFinished = &ttl
+	}
+
+	// the job continues the run so its logs and reloads carry the same ID
+	env := append(jobEnv(cfg), corev1.EnvVar{Name: "FLUENTD_RUN_ID", Value: runID})
+	for i := range job.Spec.Template.Spec.Containers {
+		container := &job.Spec.Template.Spec.Containers[i]
+		container.Env = mergeEnv(container.Env, env)
+	}
+
+	created, err := cfg.Client.BatchV1().Jobs(namespace).Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return report, fmt.Errorf("failed to create job: %w", err)
+	}
+	log.Printf("Run %s created job %s to check target %s", runID, created.Name, cfg.Name)
+
+	report.Targets[0].Status = StatusDelegated
+	report.GeneratedAt = time.Now().UTC()
+	return report, nil
+}
+
+// jobEnv returns the environment selecting the single target the job checks
+func jobEnv(cfg Config) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{Name: "FLUENTD_TARGETS_CONFIGMAP", Value: ""},
+		{Name: "FLUENTD_NAMESPACE", Value: cfg.Namespace},
+		{Name: "FLUENTD_SERVICE_URL", Value: strings.Join(append([]string{cfg.ServiceURL}, cfg.ServiceURLs...), ",")},
+		{Name: "FLUENTD_CERT_NAME", Value: cfg.CertName},
+		{Name: "FLUENTD_CERT_NAMESPACE", Value: cfg.CertNamespace},
+		{Name: "FLUENTD_SECRET_NAME", Value: cfg.SecretName},
+		{Name: "FLUENTD_SELECTOR", Value: cfg.Selector},
+		{Name: "FLUENTD_STATEFULSET_NAME", Value: cfg.StatefulSetName},
+		{Name: "FLUENTD_DEPENDENT_CERTS", Value: strings.Join(cfg.DependentCerts, ",")},
+		{Name: "FLUENTD_PROFILES", Value: ""},
+	}
+	if cfg.Profile == "" {
+		return env
+	}
+
+	// the job gets the settings of the profile as it doesn't read the profiles
+	return append(env,
+		corev1.EnvVar{Name: "PROBE_PORT", Value: strconv.Itoa(cfg.ProbePort)},
+		corev1.EnvVar{Name: "FLUENTD_RPC_PORT", Value: strconv.Itoa(cfg.RPCPort)},
+		corev1.EnvVar{Name: "FLUENTD_RPC_PORT_NAME", Value: cfg.RPCPortName},
+		corev1.EnvVar{Name: "FLUENTD_RELOAD_STRATEGY", Value: cfg.ReloadStrategy},
+		corev1.EnvVar{Name: "FLUENTD_RELOAD_PAUSE", Value: cfg.ReloadPause.String()},
+		corev1.EnvVar{Name: "FLUENTD_MAX_BUFFER_QUEUE_LENGTH", Value: strconv.Itoa(cfg.MaxBufferQueueLength)},
+		corev1.EnvVar{Name: "FLUENTD_MAX_RETRY_COUNT", Value: strconv.Itoa(cfg.MaxRetryCount)},
+		corev1.EnvVar{Name: "FLUENTD_CIRCUIT_FAILURES", Value: strconv.Itoa(cfg.CircuitFailures)},
+		corev1.EnvVar{Name: "FLUENTD_MAX_RELOAD_PERCENT", Value: strconv.Itoa(cfg.MaxReloadPercent)},
+	)
+}
+
+// mergeEnv overrides the variables of env with the ones of overrides
+func mergeEnv(env, overrides []corev1.EnvVar) []corev1.EnvVar {
+	merged := make([]corev1.EnvVar, 0, len(env)+len(overrides))
+	for _, e := range env {
+		overridden := false
+		for _, o := range overrides {
+			if e.Name == o.Name {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			merged = append(merged, e)
+		}
+	}
+
+	return append(merged, overrides...)
+}
+
+func jobFailed(job batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jobLabelValue turns a target name, which may contain a namespace, into a label value
+func jobLabelValue(name string) string {
+	value := strings.ReplaceAll(name, "/", ".")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+
+	return strings.Trim(value, "-_.")
+}
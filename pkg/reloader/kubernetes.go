@@ -0,0 +1,312 @@
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	certFingerprintAnnotation = "fluentd-reloader.io/cert-fingerprint"
+	lastReloadAnnotation      = "fluentd-reloader.io/last-reload"
+)
+
+type app struct {
+	namespace     string
+	certNamespace string
+	certName      string
+	secretName    string
+	certFile      string
+	source        CertSource
+	selector      string
+	statefulSet   string
+	client        kubernetes.Interface
+	podCache      *PodCache
+	restConfig    *rest.Config
+	shards        int
+	shardIndex    int
+	fieldSelector string
+	nodeName      string
+	zone          string
+}
+
+// get all pods matching the target's selector in the configured namespace
+// note that this will only work if the pods are created by a statefulset
+func (a app) getFluentdPods(ctx context.Context) ([]corev1.Pod, error) {
+	if a.statefulSet != "" {
+		return a.getStatefulSetPods(ctx)
+	}
+
+	pods, err := a.listPods(ctx, a.selector)
+	if err != nil {
+		return nil, err
+	}
+
+	fluentdPods := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if _, ok := pod.Labels["statefulset.kubernetes.io/pod-name"]; !ok {
+			log.Println("Pod is not from statefulset, skipping", pod.Name)
+			continue
+		}
+
+		fluentdPods = append(fluentdPods, pod)
+	}
+
+	return fluentdPods, nil
+}
+
+// getStatefulSetPods returns the pods controlled by the configured StatefulSet,
+// ignoring unrelated pods matching its selector
+func (a app) getStatefulSetPods(ctx context.Context) ([]corev1.Pod, error) {
+	sts, err := a.client.AppsV1().StatefulSets(a.namespace).Get(ctx, a.statefulSet, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get statefulset %s: %w", a.statefulSet, err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse selector of statefulset %s: %w", a.statefulSet, err)
+	}
+
+	pods, err := a.listPods(ctx, selector.String())
+	if err != nil {
+		return nil, err
+	}
+
+	fluentdPods := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || owner.UID != sts.UID {
+			log.Println("Pod is not owned by statefulset, skipping", pod.Name)
+			continue
+		}
+
+		fluentdPods = append(fluentdPods, pod)
+	}
+
+	return fluentdPods, nil
+}
+
+// target is a single fluentd instance a reload is sent to
+type target struct {
+	// host is the host:port of the fluentd RPC endpoint
+	host string
+	// pod is nil when the target was discovered through the headless service
+	pod *corev1.Pod
+}
+
+func (t target) String() string {
+	if t.pod != nil {
+		return t.pod.Name
+	}
+
+	return t.host
+}
+
+// Reasons pods are skipped when discovering the fluentd targets
+const (
+	skipTerminating = "terminating"
+	skipNoIP        = "no-ip"
+	skipNoRPCPort   = "no-rpc-port"
+	skipLabeled     = "skip-label"
+)
+
+// getFluentdTargets returns the targets the reloads are sent to depending on
+// the configured reload mode and the number of skipped pods by reason
+func (a app) getFluentdTargets(ctx context.Context, cfg Config) ([]target, map[string]int, error) {
+	if cfg.ReloadVia == ReloadViaService {
+		serviceDNS := fmt.Sprintf("%s.%s.svc", cfg.HeadlessService, a.namespace)
+		ips, err := net.DefaultResolver.LookupHost(ctx, serviceDNS)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve %s: %w", serviceDNS, err)
+		}
+
+		targets := make([]target, 0, len(ips))
+		for _, ip := range ips {
+			targets = append(targets, target{host: net.JoinHostPort(ip, strconv.Itoa(cfg.RPCPort))})
+		}
+
+		return targets, nil, nil
+	}
+
+	pods, err := a.getFluentdPods(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter, err := a.podFilter()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targets := make([]target, 0, len(pods))
+	skipped := map[string]int{}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			log.Printf("Pod %s is terminating, skipping", pod.Name)
+			skipped[skipTerminating]++
+			continue
+		}
+		if pod.Labels[skipLabel] == "true" {
+			log.Printf("Pod %s is labelled %s=true, skipping", pod.Name, skipLabel)
+			skipped[skipLabeled]++
+			continue
+		}
+		if !a.inShard(pod.Name) {
+			skipped[skipOtherShard]++
+			continue
+		}
+		reason, err := filter.skipReason(ctx, *pod)
+		if err != nil {
+			return nil, nil, err
+		}
+		if reason != "" {
+			skipped[reason]++
+			continue
+		}
+
+		port, ok := rpcPort(*pod, cfg)
+		if !ok {
+			log.Printf("Pod %s has no container port named %s, skipping", pod.Name, cfg.RPCPortName)
+			skipped[skipNoRPCPort]++
+			continue
+		}
+
+		host := podIP(*pod, cfg.IPFamily)
+		if host == "" {
+			log.Printf("Pod %s has no IP yet, skipping", pod.Name)
+			skipped[skipNoIP]++
+			continue
+		}
+		switch cfg.ReloadVia {
+		case ReloadViaPodDNS:
+			host = fmt.Sprintf("%s.%s.%s.svc", pod.Name, cfg.HeadlessService, a.namespace)
+		case ReloadViaAPIProxy:
+			// the api proxy transport resolves the pod by name
+			host = pod.Name
+		}
+
+		targets = append(targets, target{host: net.JoinHostPort(host, strconv.Itoa(port)), pod: pod})
+	}
+
+	return targets, skipped, nil
+}
+
+// podIP returns the pod IP matching the preferred family, falling back to the
+// primary pod IP when the pod has no IP of that family
+func podIP(pod corev1.Pod, family string) string {
+	for _, podIP := range pod.Status.PodIPs {
+		ip := net.ParseIP(podIP.IP)
+		if ip == nil {
+			continue
+		}
+
+		isIPv4 := ip.To4() != nil
+		if (family == IPFamilyIPv4 && isIPv4) || (family == IPFamilyIPv6 && !isIPv4) {
+			return podIP.IP
+		}
+	}
+
+	return pod.Status.PodIP
+}
+
+// rpcPort resolves the fluentd RPC port of the pod, either by the configured
+// container port name or falling back to the configured port number
+func rpcPort(pod corev1.Pod, cfg Config) (int, bool) {
+	if cfg.RPCPortName == "" {
+		return cfg.RPCPort, true
+	}
+
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == cfg.RPCPortName {
+				return int(port.ContainerPort), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// podOS returns the operating system of the node the pod runs on as declared by
+// its spec.os or its kubernetes.io/os node selector, windows pods need one of
+// them to be scheduled on windows nodes
+func podOS(pod corev1.Pod) string {
+	if pod.Spec.OS != nil {
+		return string(pod.Spec.OS.Name)
+	}
+	if name, ok := pod.Spec.NodeSelector[corev1.LabelOSStable]; ok {
+		return name
+	}
+
+	return "linux"
+}
+
+// annotatePods records the reloaded certificate and the reload time on the
+// given fluentd pods, which must all have been reloaded
+func (a app) annotatePods(ctx context.Context, certFingerprint string, reloaded []string) error {
+	if len(reloaded) == 0 {
+		return nil
+	}
+
+	annotations := map[string]string{
+		certFingerprintAnnotation: certFingerprint,
+		lastReloadAnnotation:      time.Now().UTC().Format(time.RFC3339),
+	}
+	if id := RunID(ctx); id != "" {
+		annotations[runIDAnnotation] = id
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build annotation patch: %w", err)
+	}
+
+	for _, name := range reloaded {
+		_, err = a.client.CoreV1().Pods(a.namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if apierrors.IsNotFound(err) {
+			// deleted by the pod-delete strategy, its replacement mounts the secret anyway
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to annotate pod %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// reloadedPods returns the pods the actions reloaded, targets discovered
+// through the headless service have no pod and are left out
+func reloadedPods(targets []target, actions []ReloadAction) []string {
+	pods := map[string]bool{}
+	for _, t := range targets {
+		if t.pod != nil {
+			pods[t.pod.Name] = true
+		}
+	}
+
+	reloaded := []string{}
+	for _, action := range actions {
+		if action.Outcome == OutcomeReloaded && pods[action.Target] {
+			reloaded = append(reloaded, action.Target)
+		}
+	}
+
+	return reloaded
+}
@@ -0,0 +1,66 @@
+package reloader
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReloadedPods(t *testing.T) {
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	targets := []target{
+		{host: "10.0.0.1:24444", pod: pod("fluentd-0")},
+		{host: "10.0.0.2:24444", pod: pod("fluentd-1")},
+		{host: "fluentd-2.fluentd.logging.svc:24444"},
+	}
+
+	tests := []struct {
+		name    string
+		actions []ReloadAction
+		want    []string
+	}{
+		{
+			name: "reloaded pods",
+			actions: []ReloadAction{
+				{Target: "fluentd-0", Outcome: OutcomeReloaded},
+				{Target: "fluentd-1", Outcome: OutcomeReloaded},
+			},
+			want: []string{"fluentd-0", "fluentd-1"},
+		},
+		{
+			name: "failed and skipped pods are left out",
+			actions: []ReloadAction{
+				{Target: "fluentd-0", Outcome: OutcomeFailed},
+				{Target: "fluentd-1", Outcome: OutcomeSkipped},
+			},
+			want: []string{},
+		},
+		{
+			name: "targets without a pod are left out",
+			actions: []ReloadAction{
+				{Target: "fluentd-0", Outcome: OutcomeReloaded},
+				{Target: "fluentd-2.fluentd.logging.svc:24444", Outcome: OutcomeReloaded},
+			},
+			want: []string{"fluentd-0"},
+		},
+		{
+			name: "unknown pods are left out",
+			actions: []ReloadAction{
+				{Target: "other", Outcome: OutcomeReloaded},
+			},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reloadedPods(targets, tt.actions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reloadedPods() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package reloader
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// breakerThreshold consecutive failures open the circuit of a target
+	breakerThreshold = 3
+	// maxBreakerIntervals caps the cooldown of an open circuit in check intervals
+	maxBreakerIntervals = 10
+)
+
+// Loop checks every target in its own goroutine every interval plus a random
+// splay until ctx is done, so a slow or hanging target never delays the checks
+// of the others. Each check is bounded by RunDeadline, defaulting to the
+// interval. After repeated retriable failures the circuit of a target opens and its
+// checks back off up to ten intervals until one succeeds again. The targets
+// are reloaded every interval, onReport is called with the report of every check.
+func Loop(ctx context.Context, cfg Config, interval, splay time.Duration, onReport func(Report)) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	cfg = cfg.withDefaults()
+	if cfg.RunDeadline == 0 {
+		cfg.RunDeadline = interval
+	}
+
+	var mu sync.Mutex
+	targets := map[string]Config{}
+	running := map[string]bool{}
+	current := func(name string) (Config, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		target, ok := targets[name]
+		if !ok {
+			delete(running, name)
+		}
+		return target, ok
+	}
+
+	for {
+		loaded, err := loadTargets(ctx, cfg)
+		if err != nil {
+			log.Printf("Failed to load targets: %v", err)
+		} else {
+			pruneFluentdReloads(ctx, cfg, loaded)
+			mu.Lock()
+			targets = map[string]Config{}
+			for _, target := range loaded {
+				target.TargetsConfigMap = ""
+				targets[target.Name] = target
+				if !running[target.Name] {
+					running[target.Name] = true
+					go loopTarget(ctx, target.Name, current, interval, splay, onReport)
+				}
+			}
+			mu.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// loopTarget checks a single target until it is removed or ctx is done, the
+// latest config of the target is looked up before every check
+func loopTarget(ctx context.Context, name string, current func(string) (Config, bool), interval, splay time.Duration, onReport func(Report)) {
+	failures := 0
+	for {
+		target, ok := current(name)
+		if !ok {
+			log.Printf("Target %s was removed, stopping its checks", name)
+			return
+		}
+
+		report, err := Run(ctx, target)
+		for i := range report.Targets {
+			report.Targets[i].Target = name
+		}
+		onReport(report)
+
+		wait := interval
+		switch {
+		case err == nil:
+			failures = 0
+		case ErrorClass(err) == ErrorClassTerminal:
+			// backing off cannot help, the configuration has to change
+			log.Printf("Check of %s failed: %v", name, err)
+		default:
+			failures++
+			log.Printf("Check of %s failed %d times in a row: %v", name, failures, err)
+		}
+		if failures >= breakerThreshold {
+			// the shift is capped so it cannot overflow
+			shift := failures - breakerThreshold + 1
+			if shift > 4 {
+				shift = 4
+			}
+			intervals := 1 << shift
+			if intervals > maxBreakerIntervals {
+				intervals = maxBreakerIntervals
+			}
+			wait = time.Duration(intervals) * interval
+			log.Printf("Circuit of %s is open, next check in %v", name, wait)
+		}
+		if untilOpen, ok := deferredFor(target, report); ok && untilOpen < wait {
+			wait = untilOpen
+		}
+		if splay > 0 {
+			wait += time.Duration(rand.Int63n(int64(splay)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// execInPod runs the command in the container of the pod and returns its stdout
+func (a app) execInPod(ctx context.Context, pod corev1.Pod, container string, command ...string) ([]byte, error) {
+	req := a.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(a.restConfig, http.MethodPost, req.URL())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create executor: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// mountedCertificate reads the certificate file mounted into the fluentd container
+func (a app) mountedCertificate(ctx context.Context, cfg Config, pod corev1.Pod) (*x509.Certificate, error) {
+	out, err := a.execInPod(ctx, pod, cfg.ContainerName, "cat", cfg.MountedCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s in pod %s: %w", cfg.MountedCertPath, pod.Name, err)
+	}
+
+	certs, err := parseCertificates(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s in pod %s: %w", cfg.MountedCertPath, pod.Name, err)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s in pod %s holds no certificate", cfg.MountedCertPath, pod.Name)
+	}
+
+	return certs[0], nil
+}
+
+// unpropagatedTargets returns the targets whose mounted certificate file is not
+// the expected certificate yet, kubelet syncs secret volumes with a delay and
+// reloading these pods would load the old certificate again. Targets that are
+// not pods and pods on windows nodes are not inspected.
+func (a app) unpropagatedTargets(ctx context.Context, cfg Config, targets []target, expected *x509.Certificate) ([]target, error) {
+	want := fingerprint(expected)
+	unpropagated := []target{}
+	for _, t := range targets {
+		if t.pod == nil || podOS(*t.pod) == "windows" {
+			continue
+		}
+
+		mounted, err := a.mountedCertificate(ctx, cfg, *t.pod)
+		if err != nil {
+			return nil, err
+		}
+		if fingerprint(mounted) != want {
+			log.Printf("%s has the certificate expiring %v mounted, kubelet has not propagated the secret yet", t, mounted.NotAfter)
+			unpropagated = append(unpropagated, t)
+		}
+	}
+
+	return unpropagated, nil
+}
+
+// secretPropagated checks the mounted certificate of the targets and records
+// the pods that do not have the expected certificate yet in the report
+func (a app) secretPropagated(ctx context.Context, cfg Config, targets []target, expected *x509.Certificate, s *TargetReport) (bool, error) {
+	unpropagated, err := a.unpropagatedTargets(ctx, cfg, targets, expected)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range unpropagated {
+		s.UnpropagatedPods = append(s.UnpropagatedPods, t.String())
+	}
+
+	return len(unpropagated) == 0, nil
+}
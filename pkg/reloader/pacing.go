@@ -0,0 +1,114 @@
+package reloader
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// minAdaptivePause is the first slowdown when no reload pause is configured
+const minAdaptivePause = 5 * time.Second
+
+// pacer returns how long to wait before reloading the next of the remaining targets
+type pacer func(ctx context.Context, remaining []target) time.Duration
+
+// fixedPause waits the same pause between all pods
+func fixedPause(pause time.Duration) pacer {
+	return func(context.Context, []target) time.Duration {
+		return pause
+	}
+}
+
+// bufferPressure sums the buffer metrics of the remaining pods
+type bufferPressure struct {
+	queuedBytes int64
+	retries     int
+	emitRecords int64
+	sampled     time.Time
+}
+
+// newPacer returns the pacer of the config. With AdaptivePacing the pause
+// doubles up to MaxReloadPause while the buffers of the remaining pods grow,
+// as reloads shift their traffic onto the other aggregators, and halves back
+// to ReloadPause once they stabilize.
+func newPacer(cfg Config) pacer {
+	if !cfg.AdaptivePacing {
+		return fixedPause(cfg.ReloadPause)
+	}
+
+	pause := cfg.ReloadPause
+	var last *bufferPressure
+	return func(ctx context.Context, remaining []target) time.Duration {
+		current := sampleBufferPressure(ctx, cfg, remaining)
+		if last != nil && current != nil {
+			switch {
+			case rising(*last, *current):
+				pause *= 2
+				if pause < minAdaptivePause {
+					pause = minAdaptivePause
+				}
+				if pause > cfg.MaxReloadPause {
+					pause = cfg.MaxReloadPause
+				}
+				log.Printf("Buffers of the remaining %d pods grow (%d bytes queued, %d retries, %.0f records/s emitted), slowing down to a pause of %v",
+					len(remaining), current.queuedBytes, current.retries, emitRate(*last, *current), pause)
+			case pause > cfg.ReloadPause:
+				pause /= 2
+				if pause < cfg.ReloadPause {
+					pause = cfg.ReloadPause
+				}
+				log.Printf("Buffers of the remaining %d pods are stable, speeding up to a pause of %v", len(remaining), pause)
+			}
+		}
+		if current != nil {
+			last = current
+		}
+
+		return pause
+	}
+}
+
+// rising reports whether the buffers grew by more than a tenth or the
+// outputs retried more since the last sample
+func rising(last, current bufferPressure) bool {
+	return current.queuedBytes > last.queuedBytes+last.queuedBytes/10 || current.retries > last.retries
+}
+
+func emitRate(last, current bufferPressure) float64 {
+	elapsed := current.sampled.Sub(last.sampled).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(current.emitRecords-last.emitRecords) / elapsed
+}
+
+// sampleBufferPressure sums the buffer metrics of the pods, pods whose
+// monitor_agent cannot be queried are left out, nil when none could be queried
+func sampleBufferPressure(ctx context.Context, cfg Config, targets []target) *bufferPressure {
+	var pressure *bufferPressure
+	for _, t := range targets {
+		plugins, err := getMonitorPlugins(ctx, cfg, t)
+		if err != nil {
+			log.Printf("Failed to read the buffers of %s: %v", t, err)
+			continue
+		}
+
+		if pressure == nil {
+			pressure = &bufferPressure{sampled: time.Now()}
+		}
+		for _, p := range plugins.Plugins {
+			if p.BufferTotalQueuedSize != nil {
+				pressure.queuedBytes += *p.BufferTotalQueuedSize
+			}
+			if p.RetryCount != nil {
+				pressure.retries += *p.RetryCount
+			}
+			if p.EmitRecords != nil {
+				pressure.emitRecords += *p.EmitRecords
+			}
+		}
+	}
+
+	return pressure
+}
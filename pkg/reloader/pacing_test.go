@@ -0,0 +1,100 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRising(t *testing.T) {
+	tests := []struct {
+		name          string
+		last, current bufferPressure
+		want          bool
+	}{
+		{name: "stable", last: bufferPressure{queuedBytes: 1000}, current: bufferPressure{queuedBytes: 1000}},
+		{name: "within a tenth", last: bufferPressure{queuedBytes: 1000}, current: bufferPressure{queuedBytes: 1100}},
+		{name: "grown by more than a tenth", last: bufferPressure{queuedBytes: 1000}, current: bufferPressure{queuedBytes: 1101}, want: true},
+		{name: "draining", last: bufferPressure{queuedBytes: 1000}, current: bufferPressure{queuedBytes: 10}},
+		{name: "more retries", last: bufferPressure{retries: 1}, current: bufferPressure{retries: 2}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rising(tt.last, tt.current); got != tt.want {
+				t.Errorf("rising() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPacer(t *testing.T) {
+	tests := []struct {
+		name     string
+		adaptive bool
+		// queued are the bytes queued by the remaining pods at every call, -1
+		// when monitor_agent fails
+		queued []int
+		want   []time.Duration
+	}{
+		{
+			name:   "fixed",
+			queued: []int{100, 1000, 10000},
+			want:   []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name:     "slows down while the buffers grow",
+			adaptive: true,
+			queued:   []int{100, 1000, 10000, 100000},
+			want:     []time.Duration{time.Second, 5 * time.Second, 8 * time.Second, 8 * time.Second},
+		},
+		{
+			name:     "speeds up once the buffers are stable",
+			adaptive: true,
+			queued:   []int{100, 1000, 1000, 1000, 1000},
+			want:     []time.Duration{time.Second, 5 * time.Second, 2500 * time.Millisecond, 1250 * time.Millisecond, time.Second},
+		},
+		{
+			name:     "keeps the pause without a sample",
+			adaptive: true,
+			queued:   []int{100, 1000, -1, 10000},
+			want:     []time.Duration{time.Second, 5 * time.Second, 5 * time.Second, 8 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				queued := tt.queued[call]
+				call++
+				if queued < 0 {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprintf(w, `{"plugins":[{"plugin_id":"object:1","buffer_total_queued_size":%d,"retry_count":0}]}`, queued)
+			}))
+			defer srv.Close()
+
+			_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+			monitorPort, _ := strconv.Atoi(port)
+			cfg := Config{
+				AdaptivePacing: tt.adaptive, ReloadPause: time.Second, MaxReloadPause: 8 * time.Second,
+				MonitorPort: monitorPort, RPCTimeout: 5 * time.Second,
+			}
+			remaining := []target{{host: net.JoinHostPort("127.0.0.1", "24444")}}
+
+			next := newPacer(cfg)
+			for i, want := range tt.want {
+				if got := next(context.Background(), remaining); got != want {
+					t.Errorf("pause %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/pager"
+)
+
+// podListPageSize is the number of pods requested per page when listing pods from the API
+const podListPageSize = 500
+
+// PodCache serves the fluentd pods from informers in daemon mode instead of
+// listing them on every check. An informer is started for a namespace and
+// selector the first time their pods are requested, it only lists and watches
+// the pods matching the selector.
+type PodCache struct {
+	ctx    context.Context
+	client kubernetes.Interface
+	resync time.Duration
+
+	mu   sync.Mutex
+	pods map[podCacheKey]*namespacePods
+}
+
+type podCacheKey struct {
+	namespace string
+	selector  string
+}
+
+type namespacePods struct {
+	informer cache.SharedIndexInformer
+	lister   listersv1.PodLister
+
+	mu sync.Mutex
+	// watchFailing is set while the watch of the informer is failing
+	watchFailing bool
+	// failedVersion is the resource version synced when the watch failed, the
+	// informer is fresh again once it synced another one
+	failedVersion string
+}
+
+// NewPodCache returns a cache whose informers stop when ctx is done
+func NewPodCache(ctx context.Context, client kubernetes.Interface, resync time.Duration) *PodCache {
+	return &PodCache{ctx: ctx, client: client, resync: resync, pods: map[podCacheKey]*namespacePods{}}
+}
+
+// list returns the pods of the namespace matching the selector, ok is false
+// while the cache is not synced or its watch is failing
+func (c *PodCache) list(namespace, selector string) ([]corev1.Pod, bool, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, false, terminal(fmt.Errorf("failed to parse selector %s: %w", selector, err), "check FLUENTD_SELECTOR")
+	}
+
+	n := c.namespace(namespace, parsed.String())
+	if n.stale() || !n.informer.HasSynced() {
+		return nil, false, nil
+	}
+
+	cached, err := n.lister.Pods(namespace).List(parsed)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pods := make([]corev1.Pod, 0, len(cached))
+	for _, pod := range cached {
+		pods = append(pods, *pod.DeepCopy())
+	}
+
+	return pods, true, nil
+}
+
+func (c *PodCache) namespace(namespace, selector string) *namespacePods {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := podCacheKey{namespace: namespace, selector: selector}
+	if n, ok := c.pods[key]; ok {
+		return n
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(c.client, c.resync, informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) { opts.LabelSelector = selector }))
+	pods := factory.Core().V1().Pods()
+	n := &namespacePods{informer: pods.Informer(), lister: pods.Lister()}
+
+	setFailing := func(failing bool) {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.watchFailing = failing
+	}
+	_ = n.informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		cache.DefaultWatchErrorHandler(r, err)
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.watchFailing, n.failedVersion = true, r.LastSyncResourceVersion()
+	})
+	n.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { setFailing(false) },
+		UpdateFunc: func(_, _ interface{}) { setFailing(false) },
+		DeleteFunc: func(interface{}) { setFailing(false) },
+	})
+
+	factory.Start(c.ctx.Done())
+	c.pods[key] = n
+	return n
+}
+
+// stale reports whether the watch of the informer failed, it is cleared once
+// the informer relisted or resumed watching, even when no pod changed
+func (n *namespacePods) stale() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.watchFailing && n.informer.LastSyncResourceVersion() != n.failedVersion {
+		n.watchFailing = false
+	}
+
+	return n.watchFailing
+}
+
+// listPods lists the pods matching the selector from the cache, falling back
+// to the API while the cache is stale
+func (a app) listPods(ctx context.Context, selector string) ([]corev1.Pod, error) {
+	if a.podCache != nil {
+		pods, ok, err := a.podCache.list(a.namespace, selector)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return pods, nil
+		}
+	}
+
+	// list in pages so a large namespace does not take one expensive request
+	// that the API server's flow control would have to admit at once
+	pods := []corev1.Pod{}
+	p := pager.New(func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		return a.client.CoreV1().Pods(a.namespace).List(ctx, opts)
+	})
+	p.PageSize = podListPageSize
+	err := p.EachListItem(ctx, metav1.ListOptions{LabelSelector: selector}, func(obj runtime.Object) error {
+		pods = append(pods, *obj.(*corev1.Pod))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fluentd pods: %w", err)
+	}
+
+	return pods, nil
+}
@@ -0,0 +1,116 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// portForwardService forwards a local port to a running pod backing the service port,
+// so the TLS probe works from outside the cluster. It returns the local address and
+// a function stopping the forward.
+func (a app) portForwardService(ctx context.Context, serviceName string, servicePort int) (string, func(), error) {
+	svc, err := a.client.CoreV1().Services(a.namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get service %s: %w", serviceName, err)
+	}
+
+	var targetPort *intstr.IntOrString
+	for _, port := range svc.Spec.Ports {
+		if int(port.Port) == servicePort {
+			targetPort = &port.TargetPort
+			break
+		}
+	}
+	if targetPort == nil {
+		return "", nil, fmt.Errorf("service %s has no port %d", serviceName, servicePort)
+	}
+
+	pods, err := a.client.CoreV1().Pods(a.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String(),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get pods of service %s: %w", serviceName, err)
+	}
+
+	var pod *corev1.Pod
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			pod = &pods.Items[i]
+			break
+		}
+	}
+	if pod == nil {
+		return "", nil, fmt.Errorf("service %s has no running pods", serviceName)
+	}
+
+	podPort := targetPort.IntValue()
+	if targetPort.Type == intstr.String {
+		podPort = 0
+		for _, container := range pod.Spec.Containers {
+			for _, port := range container.Ports {
+				if port.Name == targetPort.StrVal {
+					podPort = int(port.ContainerPort)
+				}
+			}
+		}
+	}
+	if podPort == 0 {
+		podPort = servicePort
+	}
+
+	transport, upgrader, err := spdy.RoundTripperFor(a.restConfig)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create port-forward transport: %w", err)
+	}
+
+	url := a.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("portforward").
+		URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	stopCh := make(chan struct{})
+	readyCh := make(chan struct{})
+	fw, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", podPort)}, stopCh, readyCh, io.Discard, os.Stderr)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create port-forward: %w", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fw.ForwardPorts()
+	}()
+
+	select {
+	case <-readyCh:
+	case err := <-errCh:
+		return "", nil, fmt.Errorf("failed to port-forward to pod %s: %w", pod.Name, err)
+	case <-ctx.Done():
+		close(stopCh)
+		return "", nil, ctx.Err()
+	}
+
+	ports, err := fw.GetPorts()
+	if err != nil {
+		close(stopCh)
+		return "", nil, fmt.Errorf("failed to get forwarded ports: %w", err)
+	}
+
+	address := fmt.Sprintf("127.0.0.1:%d", ports[0].Local)
+	log.Printf("Forwarding %s to pod %s port %d of service %s", address, pod.Name, podPort, serviceName)
+
+	return address, func() { close(stopCh) }, nil
+}
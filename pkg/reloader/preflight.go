@@ -0,0 +1,94 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+)
+
+// PreflightCheck is the outcome of a single check of Preflight
+type PreflightCheck struct {
+	Target string `json:"target,omitempty"`
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Preflight verifies the deployment of every target without reloading
+// anything: the config, the permissions, the fluentd pods of the selector,
+// the certificate and the DNS of the service URLs
+func Preflight(ctx context.Context, cfg Config) []PreflightCheck {
+	checks := []PreflightCheck{}
+	add := func(target, name string, err error, detail string) bool {
+		check := PreflightCheck{Target: target, Name: name, OK: err == nil, Detail: detail}
+		if err != nil {
+			check.Detail = err.Error()
+		}
+		checks = append(checks, check)
+		return err == nil
+	}
+
+	if !add("", "config", cfg.Validate(), "") {
+		return checks
+	}
+	cfg = cfg.withDefaults()
+	add("", "permissions", CheckPermissions(ctx, cfg), "")
+
+	targets, err := loadTargets(ctx, cfg)
+	if !add("", "targets", err, fmt.Sprintf("%d targets", len(targets))) {
+		return checks
+	}
+
+	for _, target := range targets {
+		name := ""
+		if len(targets) > 1 {
+			name = target.Name
+		}
+		if !add(name, "config", validateTarget(target), "") {
+			continue
+		}
+		app := app{
+			namespace:     target.Namespace,
+			certNamespace: target.CertNamespace,
+			certName:      target.CertName,
+			secretName:    target.SecretName,
+			certFile:      target.CertFile,
+			source:        target.CertSource,
+			selector:      target.Selector,
+			statefulSet:   target.StatefulSetName,
+			client:        target.Client,
+			restConfig:    target.RESTConfig,
+			shards:        target.Shards,
+			shardIndex:    target.ShardIndex,
+			fieldSelector: target.FieldSelector,
+			nodeName:      target.NodeName,
+			zone:          target.Zone,
+		}
+
+		fluentdTargets, skipped, err := app.getFluentdTargets(ctx, target)
+		if err == nil && len(fluentdTargets) == 0 {
+			err = fmt.Errorf("selector %s matches no fluentd pods", target.Selector)
+		}
+		add(name, "pods", err, fmt.Sprintf("%d pods match %s, skipped %v", len(fluentdTargets), target.Selector, skipped))
+
+		source, sourceName := "certificate", target.CertNamespace+"/"+target.CertName
+		switch {
+		case target.CertSource != nil:
+			source, sourceName = "certificate source", fmt.Sprintf("%T", target.CertSource)
+		case target.CertFile != "":
+			source, sourceName = "certificate file", target.CertFile
+		case target.SecretName != "":
+			source, sourceName = "secret", target.CertNamespace+"/"+target.SecretName
+		}
+		_, err = app.getCertificate(ctx)
+		add(name, source, err, source+" "+sourceName)
+
+		if target.CheckMode == CheckModeSecretRevision {
+			continue
+		}
+		for _, serviceURL := range append([]string{target.ServiceURL}, target.ServiceURLs...) {
+			add(name, "dns "+serviceURL, resolveServiceURL(ctx, target, serviceURL), "resolved")
+		}
+	}
+
+	return checks
+}
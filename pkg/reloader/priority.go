@@ -0,0 +1,107 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// skipLabel excludes a pod from reloads while set to true, e.g. while it is debugged
+	skipLabel = "fluentd-reloader.io/skip"
+	// priorityLabel orders the reloads, pods with a higher priority are reloaded
+	// after the ones with a lower priority are healthy again
+	priorityLabel = "fluentd-reloader.io/reload-priority"
+
+	// priorityHealthTimeout bounds the wait for a priority group to become healthy
+	priorityHealthTimeout = time.Minute
+)
+
+// priority returns the reload priority of the target, 0 without the label
+func priority(t target) int {
+	if t.pod == nil {
+		return 0
+	}
+	value, ok := t.pod.Labels[priorityLabel]
+	if !ok {
+		return 0
+	}
+
+	p, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Pod %s has an invalid %s label %q, using priority 0", t, priorityLabel, value)
+		return 0
+	}
+
+	return p
+}
+
+// priorityGroups splits the targets into groups of ascending priority, keeping
+// the order of the targets within a group
+func priorityGroups(targets []target) [][]target {
+	sorted := append([]target{}, targets...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return priority(sorted[i]) < priority(sorted[j])
+	})
+
+	var groups [][]target
+	for i, t := range sorted {
+		if i == 0 || priority(t) != priority(sorted[i-1]) {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], t)
+	}
+
+	return groups
+}
+
+// reloadByPriority reloads the targets group by group in ascending priority,
+// the next group is only reloaded once the pods of the previous one are healthy
+func reloadByPriority(ctx context.Context, cfg Config, targets []target, reload func(targets ...target) ([]ReloadAction, error)) ([]ReloadAction, error) {
+	groups := priorityGroups(targets)
+	var actions []ReloadAction
+	for i, group := range groups {
+		groupActions, err := reload(group...)
+		actions = append(actions, groupActions...)
+		if err == nil && i < len(groups)-1 {
+			err = waitHealthy(ctx, cfg, group)
+		}
+		if err != nil {
+			for _, rest := range groups[i+1:] {
+				for _, t := range rest {
+					actions = append(actions, ReloadAction{Target: t.String(), Outcome: OutcomeSkipped})
+				}
+			}
+
+			return actions, err
+		}
+	}
+
+	return actions, nil
+}
+
+// waitHealthy waits until every target passes the health check
+func waitHealthy(ctx context.Context, cfg Config, targets []target) error {
+	ctx, cancel := context.WithTimeout(ctx, priorityHealthTimeout)
+	defer cancel()
+
+	for _, t := range targets {
+		for {
+			err := checkHealth(ctx, cfg, t)
+			if err == nil {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%s is not healthy after the reload, not reloading the pods with a higher priority: %w", t, err)
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}
+
+	return nil
+}
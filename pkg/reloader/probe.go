@@ -0,0 +1,84 @@
+package reloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/donchev7/fluentd-reloader/pkg/certprobe"
+)
+
+// probe returns what is served for serviceURL, address overrides where the
+// probe connects to
+func probe(ctx context.Context, cfg Config, serviceURL, address, proxy string, tlsConfig *tls.Config) (certprobe.Result, error) {
+	selectProxy, err := proxyFunc(proxy)
+	if err != nil {
+		return certprobe.Result{}, err
+	}
+	selectProxy = withProxyCredentials(selectProxy, cfg.ProbeProxyUsername, cfg.ProbeProxyPassword)
+
+	result, err := certprobe.Probe(ctx, serviceURL, certprobe.Options{
+		Address:   address,
+		Proxy:     selectProxy,
+		TLSConfig: tlsConfig,
+		Timeout:   cfg.ProbeTimeout,
+		Attempts:  cfg.ProbeAttempts,
+
+		AllowHostnameMismatch: cfg.ReloadOnHostnameMismatch,
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to probe %s: %w", serviceURL, err)
+	}
+	log.Printf("Issuer: %s\nExpiry: %v\n", result.Issuer, result.NotAfter.Format(time.RFC850))
+
+	return result, nil
+}
+
+// probeTLSConfig returns the TLS config enforcing the configured minimum
+// version and cipher suites on the probe
+func probeTLSConfig(cfg Config) (*tls.Config, error) {
+	conf := &tls.Config{RootCAs: cfg.ProbeRootCAs}
+
+	switch cfg.ProbeMinTLSVersion {
+	case "":
+	case "1.0":
+		conf.MinVersion = tls.VersionTLS10
+	case "1.1":
+		conf.MinVersion = tls.VersionTLS11
+	case "1.2":
+		conf.MinVersion = tls.VersionTLS12
+	case "1.3":
+		conf.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("minimum TLS version must be 1.0, 1.1, 1.2 or 1.3, got %s", cfg.ProbeMinTLSVersion)
+	}
+
+	if len(cfg.ProbeCipherSuites) == 0 {
+		return conf, nil
+	}
+
+	suites := map[string]uint16{}
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[s.Name] = s.ID
+	}
+	for _, name := range cfg.ProbeCipherSuites {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %s", name)
+		}
+		conf.CipherSuites = append(conf.CipherSuites, id)
+	}
+
+	return conf, nil
+}
+
+// fingerprint returns the hex encoded SHA-256 fingerprint of the certificate
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,115 @@
+package reloader
+
+import (
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// profilesConfigMapKey is the key of the named profiles in the targets ConfigMap
+const profilesConfigMapKey = "profiles.yaml"
+
+// profileSpec bundles the settings of one kind of fluentd deployment, like
+// aggregators, forwarders or syslog receivers, empty fields keep the values of
+// the target
+type profileSpec struct {
+	Selector    string `json:"selector"`
+	ReleaseName string `json:"releaseName"`
+	// ProbePort is the port the service URL serves the certificate on
+	ProbePort      int    `json:"probePort"`
+	RPCPort        int    `json:"rpcPort"`
+	RPCPortName    string `json:"rpcPortName"`
+	ReloadStrategy string `json:"reloadStrategy"`
+	// ReloadPause is a duration like 30s
+	ReloadPause          string `json:"reloadPause"`
+	MaxBufferQueueLength int    `json:"maxBufferQueueLength"`
+	MaxRetryCount        int    `json:"maxRetryCount"`
+	CircuitFailures      int    `json:"circuitFailures"`
+	MaxReloadPercent     int    `json:"maxReloadPercent"`
+}
+
+// parseProfiles reads the named profiles of the targets ConfigMap
+func parseProfiles(data map[string]string, configMap string) (map[string]profileSpec, error) {
+	profiles := map[string]profileSpec{}
+	if err := yaml.Unmarshal([]byte(data[profilesConfigMapKey]), &profiles); err != nil {
+		return nil, terminal(fmt.Errorf("failed to parse %s of configmap %s: %w", profilesConfigMapKey, configMap, err), "fix the profiles")
+	}
+
+	return profiles, nil
+}
+
+// apply returns the target with the settings of the profile
+func (p profileSpec) apply(target Config) (Config, error) {
+	if p.ReleaseName != "" {
+		target.Selector = releaseSelector(p.ReleaseName, p.Selector)
+	} else if p.Selector != "" {
+		target.Selector = p.Selector
+	}
+	if p.ProbePort != 0 {
+		target.ProbePort = p.ProbePort
+	}
+	if p.RPCPort != 0 {
+		target.RPCPort = p.RPCPort
+	}
+	if p.RPCPortName != "" {
+		target.RPCPortName = p.RPCPortName
+	}
+	if p.ReloadStrategy != "" {
+		if _, ok := reloaders[p.ReloadStrategy]; !ok {
+			return target, fmt.Errorf("reload strategy %s is not supported", p.ReloadStrategy)
+		}
+		target.ReloadStrategy = p.ReloadStrategy
+	}
+	if p.ReloadPause != "" {
+		pause, err := time.ParseDuration(p.ReloadPause)
+		if err != nil {
+			return target, fmt.Errorf("invalid reload pause %q: %w", p.ReloadPause, err)
+		}
+		target.ReloadPause = pause
+	}
+	if p.MaxBufferQueueLength != 0 {
+		target.MaxBufferQueueLength = p.MaxBufferQueueLength
+	}
+	if p.MaxRetryCount != 0 {
+		target.MaxRetryCount = p.MaxRetryCount
+	}
+	if p.CircuitFailures != 0 {
+		target.CircuitFailures = p.CircuitFailures
+	}
+	if p.MaxReloadPercent != 0 {
+		target.MaxReloadPercent = p.MaxReloadPercent
+	}
+
+	return target, nil
+}
+
+// expandProfiles checks every target once per enabled profile, named after
+// the target and the profile. Targets without profiles are kept as they are.
+func expandProfiles(targets []Config, profiles map[string]profileSpec, configMap string) ([]Config, error) {
+	expanded := make([]Config, 0, len(targets))
+	for _, target := range targets {
+		if len(target.Profiles) == 0 {
+			expanded = append(expanded, target)
+			continue
+		}
+
+		for _, name := range target.Profiles {
+			profile, ok := profiles[name]
+			if !ok {
+				return nil, terminal(fmt.Errorf("target %s enables profile %s missing from %s of configmap %s", target.Name, name, profilesConfigMapKey, configMap),
+					"define the profile or fix FLUENTD_PROFILES")
+			}
+
+			t, err := profile.apply(target)
+			if err != nil {
+				return nil, terminal(fmt.Errorf("profile %s: %w", name, err), "fix the profiles")
+			}
+			t.Name = target.Name + "/" + name
+			t.Profile, t.Profiles = name, nil
+			expanded = append(expanded, t)
+		}
+	}
+
+	return expanded, nil
+}
@@ -0,0 +1,88 @@
+package reloader
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const maxPropagationPollInterval = 30 * time.Second
+
+// checksPropagation reports whether the secret volumes are checked or waited
+// for before reloading
+func (c Config) checksPropagation() bool {
+	return c.MountedCertPath != "" || c.PropagationWait > 0
+}
+
+// waitForPropagation returns whether kubelet updated the secret volumes of the
+// targets, reloading earlier just loads the old certificate again. With
+// MountedCertPath the mounted files are checked with an increasing interval for
+// up to PropagationWait, otherwise it waits until the secret changed at least
+// PropagationWait ago.
+func (a app) waitForPropagation(ctx context.Context, cfg Config, certificate cmapi.Certificate, targets []target, expected *x509.Certificate, s *TargetReport) (bool, error) {
+	if cfg.MountedCertPath == "" {
+		return true, a.waitForSecretAge(ctx, cfg, certificate)
+	}
+
+	deadline := time.Now().Add(cfg.PropagationWait)
+	interval := 2 * time.Second
+	for {
+		s.UnpropagatedPods = nil
+		propagated, err := a.secretPropagated(ctx, cfg, targets, expected, s)
+		if err != nil || propagated || !time.Now().Before(deadline) {
+			return propagated, err
+		}
+
+		log.Printf("%d pods do not have the expected certificate mounted yet, checking again in %v", len(s.UnpropagatedPods), interval)
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		interval *= 2
+		if interval > maxPropagationPollInterval {
+			interval = maxPropagationPollInterval
+		}
+	}
+}
+
+// waitForSecretAge waits until the secret of the certificate was last written
+// PropagationWait ago, certificates not read from a secret are not waited for
+func (a app) waitForSecretAge(ctx context.Context, cfg Config, certificate cmapi.Certificate) error {
+	if cfg.CertFile != "" || cfg.CertSource != nil {
+		return nil
+	}
+
+	secret, err := a.client.CoreV1().Secrets(certificate.Namespace).Get(ctx, certificate.Spec.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get secret %s: %w", certificate.Spec.SecretName, err)
+	}
+
+	// the managed fields record when each field manager last wrote the secret
+	changed := secret.CreationTimestamp.Time
+	for _, field := range secret.ManagedFields {
+		if field.Time != nil && field.Time.After(changed) {
+			changed = field.Time.Time
+		}
+	}
+
+	wait := time.Until(changed.Add(cfg.PropagationWait))
+	if wait <= 0 {
+		return nil
+	}
+
+	log.Printf("Secret %s changed at %v, waiting %v for kubelet to update the secret volumes", secret.Name, changed, wait.Round(time.Second))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+	}
+
+	return nil
+}
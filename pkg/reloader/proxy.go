@@ -0,0 +1,84 @@
+package reloader
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// ProxyNone connects directly
+	ProxyNone = "none"
+	// ProxyFromEnvironment honors HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	ProxyFromEnvironment = "env"
+)
+
+// proxyFunc returns the proxy selection for a proxy setting, which is either
+// none, env or the URL of a proxy
+func proxyFunc(setting string) (func(*http.Request) (*url.URL, error), error) {
+	switch setting {
+	case "", ProxyNone:
+		return nil, nil
+	case ProxyFromEnvironment:
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(setting)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url %s: %w", setting, err)
+	}
+
+	return http.ProxyURL(proxyURL), nil
+}
+
+// probeProxySchemes are the proxies the TLS probe can tunnel through
+var probeProxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+// validProbeProxy reports an error for a probe proxy URL the probe cannot use
+func validProbeProxy(setting string) error {
+	if _, err := proxyFunc(setting); err != nil {
+		return err
+	}
+	if setting == "" || setting == ProxyNone || setting == ProxyFromEnvironment {
+		return nil
+	}
+
+	// the url was parsed by proxyFunc
+	proxyURL, _ := url.Parse(setting)
+	if !probeProxySchemes[proxyURL.Scheme] {
+		return fmt.Errorf("proxy scheme %q is not supported, use http, https, socks5 or socks5h", proxyURL.Scheme)
+	}
+
+	return nil
+}
+
+// withProxyCredentials sets the username and password on the selected proxy
+// unless its URL has credentials of its own
+func withProxyCredentials(selectProxy func(*http.Request) (*url.URL, error), username, password string) func(*http.Request) (*url.URL, error) {
+	if selectProxy == nil || username == "" {
+		return selectProxy
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := selectProxy(req)
+		if err != nil || proxyURL == nil || proxyURL.User != nil {
+			return proxyURL, err
+		}
+
+		withUser := *proxyURL
+		withUser.User = url.UserPassword(username, password)
+		return &withUser, nil
+	}
+}
+
+// newRPCTransport returns the transport used for the fluentd RPC calls
+func newRPCTransport(cfg Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// the setting is validated by Config.Validate
+	transport.Proxy, _ = proxyFunc(cfg.RPCProxy)
+	if cfg.rpcTLSEnabled() {
+		transport.TLSClientConfig = rpcTLSConfig(cfg)
+	}
+
+	return transport
+}
@@ -0,0 +1,232 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// permission is a single verb on a resource the reloader needs
+type permission struct {
+	// namespace defaults to the namespace of the fluentd pods
+	namespace string
+	// cluster scoped resources have no namespace
+	cluster     bool
+	group       string
+	resource    string
+	subresource string
+	verb        string
+	// reason explains which setting needs the permission
+	reason string
+}
+
+func (p permission) String() string {
+	resource := p.resource
+	if p.subresource != "" {
+		resource += "/" + p.subresource
+	}
+	if p.group != "" {
+		resource += "." + p.group
+	}
+
+	if p.namespace != "" {
+		resource += " in namespace " + p.namespace
+	}
+
+	return fmt.Sprintf("%s %s (%s)", p.verb, resource, p.reason)
+}
+
+// requiredPermissions returns the permissions needed by the given config
+func requiredPermissions(cfg Config) []permission {
+	permissions := []permission{
+		{resource: "pods", verb: "list", reason: "discover fluentd pods"},
+	}
+	switch {
+	case cfg.CertSource != nil || cfg.CertFile != "":
+	case cfg.SecretName != "":
+		permissions = append(permissions, permission{namespace: cfg.CertNamespace, resource: "secrets", verb: "get", reason: "FLUENTD_SECRET_NAME"})
+	default:
+		permissions = append(permissions, permission{namespace: cfg.CertNamespace, group: "cert-manager.io", resource: "certificates", verb: "list", reason: "read the certificate"})
+	}
+
+	for _, name := range cfg.DependentCerts {
+		namespace, _ := splitCertName(name, cfg.CertNamespace)
+		permissions = append(permissions, permission{namespace: namespace, group: "cert-manager.io", resource: "certificates", verb: "list", reason: "FLUENTD_DEPENDENT_CERTS"})
+	}
+	if cfg.Daemon {
+		permissions = append(permissions, permission{resource: "pods", verb: "watch", reason: "pod cache in daemon mode"})
+	}
+	if cfg.WatchEvents {
+		permissions = append(permissions,
+			permission{namespace: cfg.CertNamespace, resource: "secrets", verb: "list", reason: "WATCH_EVENTS"},
+			permission{namespace: cfg.CertNamespace, resource: "secrets", verb: "watch", reason: "WATCH_EVENTS"},
+		)
+		if cfg.CertFile == "" && cfg.CertSource == nil && cfg.SecretName == "" {
+			permissions = append(permissions,
+				permission{namespace: cfg.CertNamespace, group: "cert-manager.io", resource: "certificates", verb: "list", reason: "WATCH_EVENTS"},
+				permission{namespace: cfg.CertNamespace, group: "cert-manager.io", resource: "certificates", verb: "watch", reason: "WATCH_EVENTS"},
+			)
+		}
+	}
+	if cfg.JobTemplate != nil {
+		permissions = append(permissions,
+			permission{namespace: cfg.JobTemplate.Namespace, group: "batch", resource: "jobs", verb: "list", reason: "JOB_TEMPLATE"},
+			permission{namespace: cfg.JobTemplate.Namespace, group: "batch", resource: "jobs", verb: "create", reason: "JOB_TEMPLATE"},
+		)
+	}
+	if cfg.StatefulSetName != "" {
+		permissions = append(permissions, permission{group: "apps", resource: "statefulsets", verb: "get", reason: "FLUENTD_STATEFULSET_NAME"})
+	}
+	if cfg.SyncCondition {
+		permissions = append(permissions, permission{group: "apps", resource: "statefulsets", verb: "patch", reason: "FLUENTD_SYNC_CONDITION"})
+	}
+	if cfg.StatusResource {
+		permissions = append(permissions,
+			permission{group: "fluentd-reloader.io", resource: "fluentdreloads", verb: "get", reason: "FLUENTD_STATUS_RESOURCE"},
+			permission{group: "fluentd-reloader.io", resource: "fluentdreloads", verb: "create", reason: "FLUENTD_STATUS_RESOURCE"},
+			permission{group: "fluentd-reloader.io", resource: "fluentdreloads", verb: "patch", reason: "FLUENTD_STATUS_RESOURCE"},
+			permission{group: "fluentd-reloader.io", resource: "fluentdreloads", subresource: "status", verb: "patch", reason: "FLUENTD_STATUS_RESOURCE"})
+		if cfg.TargetsConfigMap != "" || IsCertNamePattern(cfg.CertName) {
+			permissions = append(permissions,
+				permission{group: "fluentd-reloader.io", resource: "fluentdreloads", verb: "list", reason: "FLUENTD_STATUS_RESOURCE with several targets"},
+				permission{group: "fluentd-reloader.io", resource: "fluentdreloads", verb: "delete", reason: "FLUENTD_STATUS_RESOURCE with several targets"})
+		}
+	}
+	if cfg.TargetsConfigMap != "" {
+		permissions = append(permissions, permission{resource: "configmaps", verb: "get", reason: "FLUENTD_TARGETS_CONFIGMAP"})
+	}
+	switch cfg.SecretManager {
+	case SecretManagerExternalSecrets:
+		permissions = append(permissions, permission{namespace: cfg.CertNamespace, group: "external-secrets.io", resource: "externalsecrets", verb: "list", reason: "FLUENTD_SECRET_MANAGER"})
+	case SecretManagerSealedSecrets:
+		permissions = append(permissions, permission{namespace: cfg.CertNamespace, group: "bitnami.com", resource: "sealedsecrets", verb: "get", reason: "FLUENTD_SECRET_MANAGER"})
+	}
+	if cfg.ReportHistoryConfigMap != "" {
+		permissions = append(permissions,
+			permission{resource: "configmaps", verb: "get", reason: "REPORT_HISTORY_CONFIGMAP"},
+			permission{resource: "configmaps", verb: "create", reason: "REPORT_HISTORY_CONFIGMAP"},
+			permission{resource: "configmaps", verb: "update", reason: "REPORT_HISTORY_CONFIGMAP"},
+		)
+	}
+	if cfg.ReloadVia == ReloadViaPodIP && cfg.ReloadStrategy == StrategyFluentdRPC {
+		permissions = append(permissions, permission{resource: "pods", verb: "get", reason: "looking up pods that moved to a new IP"})
+	}
+	if cfg.Zone != "" {
+		permissions = append(permissions, permission{cluster: true, resource: "nodes", verb: "get", reason: "FLUENTD_ZONE"})
+	}
+	if cfg.ReloadVia == ReloadViaAPIProxy {
+		permissions = append(permissions,
+			permission{resource: "pods", subresource: "proxy", verb: "get", reason: "FLUENTD_RELOAD_VIA=api-proxy"},
+			permission{resource: "pods", subresource: "proxy", verb: "create", reason: "FLUENTD_RELOAD_VIA=api-proxy"},
+		)
+	}
+	if cfg.TriggerRenewal {
+		permissions = append(permissions, permission{namespace: cfg.CertNamespace, group: "cert-manager.io", resource: "certificates", subresource: "status", verb: "patch", reason: "TRIGGER_RENEWAL"})
+	}
+	if cfg.SelectorFromService != "" {
+		permissions = append(permissions, permission{resource: "services", verb: "get", reason: "FLUENTD_SELECTOR_FROM_SERVICE"})
+	}
+	if cfg.ConfigMapName != "" {
+		permissions = append(permissions, permission{resource: "configmaps", verb: "get", reason: "FLUENTD_CONFIGMAP"})
+	}
+	if (cfg.ComparePublicKey || cfg.CompareChain || cfg.CheckMode == CheckModeSecretRevision) && cfg.CertFile == "" && cfg.CertSource == nil {
+		permissions = append(permissions, permission{namespace: cfg.CertNamespace, resource: "secrets", verb: "get", reason: "FLUENTD_COMPARE_PUBLIC_KEY, FLUENTD_COMPARE_CHAIN or CHECK_MODE=secret-revision"})
+	}
+	if cfg.Canary {
+		permissions = append(permissions, permission{resource: "pods", verb: "get", reason: "FLUENTD_CANARY"})
+	}
+	if cfg.AnnotatePods || cfg.CheckMode == CheckModeSecretRevision {
+		permissions = append(permissions, permission{resource: "pods", verb: "patch", reason: "FLUENTD_ANNOTATE_PODS or CHECK_MODE=secret-revision"})
+	}
+	if cfg.execHooks() {
+		permissions = append(permissions, permission{resource: "pods", subresource: "exec", verb: "create", reason: "PRE_RELOAD_HOOK_COMMAND or POST_RELOAD_HOOK_COMMAND"})
+	}
+	if cfg.MountedCertPath != "" {
+		permissions = append(permissions, permission{resource: "pods", subresource: "exec", verb: "create", reason: "FLUENTD_MOUNTED_CERT_PATH"})
+	} else if cfg.PropagationWait > 0 && cfg.CertFile == "" && cfg.CertSource == nil {
+		permissions = append(permissions, permission{namespace: cfg.CertNamespace, resource: "secrets", verb: "get", reason: "SECRET_PROPAGATION_WAIT"})
+	}
+	if cfg.CircuitFailures > 0 {
+		permissions = append(permissions, permission{resource: "events", verb: "create", reason: "FLUENTD_CIRCUIT_FAILURES"})
+	}
+	if cfg.CircuitRestart {
+		permissions = append(permissions,
+			permission{resource: "pods", subresource: "eviction", verb: "create", reason: "FLUENTD_CIRCUIT_RESTART"},
+			permission{group: "policy", resource: "poddisruptionbudgets", verb: "list", reason: "FLUENTD_CIRCUIT_RESTART"},
+		)
+	}
+	if cfg.RecordHistory {
+		permissions = append(permissions,
+			permission{namespace: cfg.CertNamespace, resource: "events", verb: "create", reason: "FLUENTD_RECORD_HISTORY"},
+			permission{namespace: cfg.CertNamespace, group: "cert-manager.io", resource: "certificates", verb: "patch", reason: "FLUENTD_RECORD_HISTORY"},
+		)
+	}
+
+	if cfg.ForwarderSelector != "" {
+		permissions = append(permissions, permission{namespace: cfg.ForwarderNamespace, resource: "pods", verb: "list", reason: "FLUENTD_FORWARDER_SELECTOR"})
+		switch cfg.ForwarderReloadStrategy {
+		case StrategyExecSignal:
+			permissions = append(permissions, permission{namespace: cfg.ForwarderNamespace, resource: "pods", subresource: "exec", verb: "create", reason: "exec-signal forwarder reload strategy"})
+		case StrategyPodDelete:
+			permissions = append(permissions,
+				permission{namespace: cfg.ForwarderNamespace, resource: "pods", subresource: "eviction", verb: "create", reason: "pod-delete forwarder reload strategy"},
+				permission{namespace: cfg.ForwarderNamespace, group: "policy", resource: "poddisruptionbudgets", verb: "list", reason: "pod-delete forwarder reload strategy"},
+			)
+		}
+	}
+	for _, strategy := range []string{cfg.ReloadStrategy, cfg.FallbackStrategy} {
+		switch strategy {
+		case StrategyExecSignal:
+			permissions = append(permissions, permission{resource: "pods", subresource: "exec", verb: "create", reason: "exec-signal reload strategy"})
+		case StrategyPodDelete:
+			permissions = append(permissions,
+				permission{resource: "pods", subresource: "eviction", verb: "create", reason: "pod-delete reload strategy"},
+				permission{group: "policy", resource: "poddisruptionbudgets", verb: "list", reason: "pod-delete reload strategy"},
+			)
+		}
+	}
+
+	return permissions
+}
+
+// CheckPermissions verifies with SelfSubjectAccessReviews that the service account
+// has every permission the config needs, so a missing rule fails fast with a clear
+// message instead of a 403 in the middle of a reload
+func CheckPermissions(ctx context.Context, cfg Config) error {
+	cfg = cfg.withDefaults()
+	missing := []string{}
+	for _, p := range requiredPermissions(cfg) {
+		if p.namespace == "" && !p.cluster {
+			p.namespace = cfg.Namespace
+		}
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   p.namespace,
+					Verb:        p.verb,
+					Group:       p.group,
+					Resource:    p.resource,
+					Subresource: p.subresource,
+				},
+			},
+		}
+
+		resp, err := cfg.Client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to review permission %s: %w", p, err)
+		}
+
+		if !resp.Status.Allowed {
+			missing = append(missing, p.String())
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("service account is missing permissions:\n  %s", strings.Join(missing, "\n  "))
+	}
+
+	return nil
+}
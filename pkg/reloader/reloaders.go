@@ -0,0 +1,482 @@
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	StrategyFluentdRPC = "fluentd-rpc"
+	StrategyFluentBit  = "fluent-bit"
+	StrategyExecSignal = "exec-signal"
+	StrategyPodDelete  = "pod-delete"
+)
+
+var (
+	// errRPCNotFound is returned when fluentd does not know the RPC endpoint
+	errRPCNotFound = errors.New("rpc endpoint not found")
+	// errRPCUnsupported is returned when neither config.gracefulReload nor
+	// config.reload worked, the fallback strategy takes over
+	errRPCUnsupported = errors.New("fluentd rpc reloads are not supported")
+)
+
+// rpcAttempts is how often a fluentd RPC reload is tried on retriable failures
+const rpcAttempts = 3
+
+// Reloader reloads the configuration of a single log shipper target
+type Reloader interface {
+	Reload(ctx context.Context, t target) error
+}
+
+// reloaders maps the FLUENTD_RELOAD_STRATEGY names to their constructors
+var reloaders = map[string]func(a app, cfg Config) Reloader{
+	StrategyFluentdRPC: newFluentdRPCReloader,
+	StrategyFluentBit:  newFluentBitReloader,
+	StrategyExecSignal: newExecSignalReloader,
+	StrategyPodDelete:  newPodDeleteReloader,
+}
+
+// workerReloader is implemented by reloaders that report per worker results
+type workerReloader interface {
+	ReloadWorkers(ctx context.Context, t target) ([]WorkerResult, error)
+}
+
+// reloadFluentdConfig reloads the targets one after another, waiting the pause of
+// the pacer in between, and stops at the first failure, the returned actions
+// record the outcome for every target
+func reloadFluentdConfig(ctx context.Context, reloader Reloader, pace pacer, targets ...target) ([]ReloadAction, error) {
+	actions := make([]ReloadAction, 0, len(targets))
+	for i, t := range targets {
+		if i > 0 {
+			if pause := pace(ctx, targets[i:]); pause > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(pause):
+				}
+			}
+		}
+
+		if ctx.Err() != nil {
+			log.Printf("Run deadline exceeded, skipping %d remaining pods: %v", len(targets)-i, targets[i:])
+			for _, skipped := range targets[i:] {
+				actions = append(actions, ReloadAction{Target: skipped.String(), Outcome: OutcomeSkipped})
+			}
+
+			return actions, fmt.Errorf("run deadline exceeded with %d pods not reloaded: %w", len(targets)-i, ctx.Err())
+		}
+
+		hasher, verify := reloader.(configHasher)
+		before := ""
+		if verify {
+			var err error
+			if before, err = hasher.ConfigHash(ctx, t); err != nil {
+				log.Printf("Failed to read the config of %s before the reload: %v", t, err)
+			}
+		}
+
+		log.Println("Reloading fluentd Config on", t)
+		start := time.Now()
+		workers, fellBack, err := reloadOrFallback(ctx, reloader, t)
+		action := ReloadAction{Target: t.String(), Outcome: OutcomeReloaded, Duration: time.Since(start).String(), Workers: workers}
+		if fellBack {
+			action.Reason = ReasonFallbackStrategy
+		}
+		if err == nil && verify {
+			// fluentd only answers config.getDump again once the reload finished
+			action.ConfigHash, err = hasher.ConfigHash(ctx, t)
+			switch {
+			case err != nil:
+				err = fmt.Errorf("failed to confirm the reload of %s: %w", t, err)
+			case action.ConfigHash == before:
+				log.Printf("Config of %s is unchanged after the reload", t)
+			}
+		}
+		if err != nil {
+			action.Outcome = OutcomeFailed
+			action.Error = err.Error()
+		}
+		actions = append(actions, action)
+
+		if err != nil {
+			return actions, err
+		}
+	}
+
+	return actions, nil
+}
+
+// rpcClient returns the injected HTTP client or one honoring the RPC timeout and proxy
+func rpcClient(cfg Config) HTTPClient {
+	if cfg.HTTPClient != nil {
+		return cfg.HTTPClient
+	}
+
+	return podClient(cfg, rpcRoundTripper(cfg))
+}
+
+// rpcResponse is the body returned by fluentd's RPC endpoint
+type rpcResponse struct {
+	OK bool `json:"ok"`
+}
+
+// fluentdRPCReloader calls fluentd's config.gracefulReload RPC endpoint
+type fluentdRPCReloader struct {
+	client HTTPClient
+	method string
+	// urlTemplate overrides the reload URL per pod when set
+	urlTemplate *template.Template
+	// workers reloads each of that many workers on its own port
+	workers   int
+	headers   http.Header
+	userAgent string
+	// scheme is https when the RPC is called over TLS
+	scheme string
+	// app looks up pods whose IP changed since they were listed, when set
+	app      *app
+	ipFamily string
+}
+
+func newFluentdRPCReloader(a app, cfg Config) Reloader {
+	r := fluentdRPCReloader{
+		client:    rpcClient(cfg),
+		ipFamily:  cfg.IPFamily,
+		scheme:    cfg.rpcScheme(),
+		method:    cfg.RPCMethod,
+		workers:   cfg.RPCWorkers,
+		headers:   cfg.RPCHeaders,
+		userAgent: cfg.UserAgent,
+	}
+	if cfg.ReloadVia == ReloadViaPodIP && a.client != nil {
+		r.app = &a
+	}
+	if cfg.ReloadURLTemplate != "" {
+		// the template was checked by Validate
+		r.urlTemplate = template.Must(parseReloadURLTemplate(cfg.ReloadURLTemplate))
+	}
+	if cfg.VerifyConfigDump {
+		return verifyingRPCReloader{r}
+	}
+
+	return r
+}
+
+func (r fluentdRPCReloader) Reload(ctx context.Context, t target) error {
+	_, err := r.ReloadWorkers(ctx, t)
+	return err
+}
+
+// ReloadWorkers reloads fluentd and returns the per worker results when fluentd
+// runs multiple workers. A pod that cannot be reached is looked up again and
+// reloaded once more when it was rescheduled with a new IP.
+func (r fluentdRPCReloader) ReloadWorkers(ctx context.Context, t target) ([]WorkerResult, error) {
+	results, err := r.reloadWorkers(ctx, t)
+	if moved, ok := r.movedTarget(ctx, t, err); ok {
+		return r.reloadWorkers(ctx, moved)
+	}
+
+	return results, err
+}
+
+func (r fluentdRPCReloader) reloadWorkers(ctx context.Context, t target) ([]WorkerResult, error) {
+	if r.workers <= 1 {
+		if r.urlTemplate != nil {
+			url, err := reloadURL(r.urlTemplate, t)
+			if err != nil {
+				return nil, err
+			}
+
+			return r.reload(ctx, t, url)
+		}
+
+		return r.reloadAddress(ctx, t, t.host)
+	}
+
+	// every worker serves its own endpoint on the RPC port plus its worker id
+	host, port, err := net.SplitHostPort(t.host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host of %s: %w", t, err)
+	}
+	basePort, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse port of %s: %w", t, err)
+	}
+
+	results := make([]WorkerResult, 0, r.workers)
+	var firstErr error
+	failed := 0
+	for worker := 0; worker < r.workers; worker++ {
+		address := net.JoinHostPort(host, strconv.Itoa(basePort+worker))
+		_, err := r.reloadAddress(ctx, t, address)
+		result := WorkerResult{Worker: worker, OK: err == nil}
+		if err != nil {
+			log.Printf("Worker %d of %s failed to reload: %v", worker, t, err)
+			result.Error = err.Error()
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		results = append(results, result)
+	}
+
+	if firstErr != nil {
+		return results, fmt.Errorf("%d of %d workers of %s failed to reload, first error: %w", failed, r.workers, t, firstErr)
+	}
+
+	return results, nil
+}
+
+// reloadAddress calls config.gracefulReload on address and falls back to
+// config.reload on fluentd builds without graceful reloads
+func (r fluentdRPCReloader) reloadAddress(ctx context.Context, t target, address string) ([]WorkerResult, error) {
+	results, err := r.reload(ctx, t, fmt.Sprintf("%s://%s/api/config.gracefulReload", r.scheme, address))
+	if !errors.Is(err, errRPCNotFound) {
+		return results, err
+	}
+
+	log.Printf("fluentd on %s does not support config.gracefulReload, retrying with config.reload", t)
+	results, err = r.reload(ctx, t, fmt.Sprintf("%s://%s/api/config.reload", r.scheme, address))
+	if err != nil {
+		return results, fmt.Errorf("%w: %v", errRPCUnsupported, err)
+	}
+
+	return results, nil
+}
+
+// reload calls the reload endpoint, retrying retriable failures
+func (r fluentdRPCReloader) reload(ctx context.Context, t target, url string) ([]WorkerResult, error) {
+	for attempt := 1; ; attempt++ {
+		results, err := r.reloadOnce(ctx, t, url)
+		if err == nil || ErrorClass(err) != ErrorClassRetriable || attempt == rpcAttempts {
+			return results, err
+		}
+
+		wait := time.Duration(attempt) * time.Second
+		if after := retryAfter(err); after > wait {
+			wait = after
+			if wait > maxRetryAfter {
+				wait = maxRetryAfter
+			}
+		}
+		log.Printf("Reload of %s failed, retrying in %v: %v", t, wait, err)
+		select {
+		case <-ctx.Done():
+			return results, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// reloadOnce calls the reload endpoint, a supervisor of multiple workers answers
+// with a list holding the response of every worker
+func (r fluentdRPCReloader) reloadOnce(ctx context.Context, t target, url string) ([]WorkerResult, error) {
+	req, err := http.NewRequestWithContext(ctx, r.method, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	setRunIDHeader(req)
+	for name, values := range r.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", r.userAgent)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		if goingAway(err) {
+			return nil, retriable(fmt.Errorf("failed to send request, the connection is draining: %w", err))
+		}
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		// not retried, the caller falls back to another endpoint or strategy
+		return nil, terminal(fmt.Errorf("failed to reload fluentd Config: %s: %w", resp.Status, errRPCNotFound), "")
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		after := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if resp.StatusCode == http.StatusServiceUnavailable && resp.Close {
+			// a proxy closing the connection along with a 503 is draining
+			return nil, retriableAfter(fmt.Errorf("failed to reload fluentd Config: %s, the endpoint is draining", resp.Status), after)
+		}
+		return nil, retriableAfter(fmt.Errorf("failed to reload fluentd Config: %s", resp.Status), after)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, terminal(fmt.Errorf("failed to reload fluentd Config: %s", resp.Status), "check FLUENTD_RPC_METHOD and FLUENTD_RPC_HEADERS")
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	log.Printf("Response: %s", string(b))
+
+	// older fluentd versions return an empty body, newer ones return {"ok": true},
+	// on windows the body may start with a byte order mark
+	b = bytes.TrimSpace(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")))
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	if b[0] == '[' {
+		workers := []rpcResponse{}
+		if err := json.Unmarshal(b, &workers); err != nil {
+			return nil, terminal(fmt.Errorf("failed to parse response body: %w", err), "check that FLUENTD_RPC_PORT serves the fluentd RPC")
+		}
+
+		results := make([]WorkerResult, 0, len(workers))
+		failed := []int{}
+		for worker, w := range workers {
+			results = append(results, WorkerResult{Worker: worker, OK: w.OK})
+			if !w.OK {
+				failed = append(failed, worker)
+			}
+		}
+		if len(failed) > 0 {
+			return results, terminal(fmt.Errorf("workers %v of fluentd on %s did not acknowledge the reload", failed, t), "check the fluentd logs for config errors")
+		}
+
+		return results, nil
+	}
+
+	rpcResp := rpcResponse{}
+	if err := json.Unmarshal(b, &rpcResp); err != nil {
+		return nil, terminal(fmt.Errorf("failed to parse response body: %w", err), "check that FLUENTD_RPC_PORT serves the fluentd RPC")
+	}
+
+	if !rpcResp.OK {
+		return nil, terminal(fmt.Errorf("fluentd on %s did not acknowledge the reload", t), "check the fluentd logs for config errors")
+	}
+
+	return nil, nil
+}
+
+// fluentBitReloader triggers fluent-bit's hot reload through its HTTP server
+type fluentBitReloader struct {
+	client HTTPClient
+}
+
+func newFluentBitReloader(_ app, cfg Config) Reloader {
+	return fluentBitReloader{
+		client: rpcClient(cfg),
+	}
+}
+
+func (r fluentBitReloader) Reload(ctx context.Context, t target) error {
+	url := fmt.Sprintf("http://%s/api/v2/reload", t.host)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	setRunIDHeader(req)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return statusError(fmt.Errorf("failed to reload fluent-bit Config: %s", resp.Status), resp.StatusCode, "check that the fluent-bit HTTP server enables hot reload")
+	}
+
+	return nil
+}
+
+// execSignalReloader sends a signal to the main process of the fluentd container,
+// fluentd gracefully reloads its config on SIGUSR2
+type execSignalReloader struct {
+	app       app
+	container string
+	signal    string
+	// windows reloads the pods on windows nodes, which have no signals
+	windows Reloader
+}
+
+func newExecSignalReloader(a app, cfg Config) Reloader {
+	return execSignalReloader{
+		app:       a,
+		container: cfg.ContainerName,
+		signal:    cfg.ReloadSignal,
+		windows:   newFluentdRPCReloader(a, cfg),
+	}
+}
+
+func (r execSignalReloader) Reload(ctx context.Context, t target) error {
+	if t.pod == nil {
+		return fmt.Errorf("target %s is not a pod, cannot exec into it", t)
+	}
+	if podOS(*t.pod) == "windows" {
+		log.Printf("%s runs on windows, reloading it through the fluentd RPC", t)
+		return r.windows.Reload(ctx, t)
+	}
+
+	if _, err := r.app.execInPod(ctx, *t.pod, r.container, "kill", "-"+r.signal, "1"); err != nil {
+		return fmt.Errorf("failed to signal %s: %w", t, err)
+	}
+
+	return nil
+}
+
+// podDeleteReloader evicts the pod so its controller recreates it with the new
+// certificate, the eviction respects the PodDisruptionBudgets of the pod
+type podDeleteReloader struct {
+	app  app
+	wait time.Duration
+}
+
+func newPodDeleteReloader(a app, cfg Config) Reloader {
+	return podDeleteReloader{app: a, wait: cfg.DisruptionWait}
+}
+
+func (r podDeleteReloader) Reload(ctx context.Context, t target) error {
+	if t.pod == nil {
+		return fmt.Errorf("target %s is not a pod, cannot delete it", t)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.wait)
+	defer cancel()
+
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{Name: t.pod.Name, Namespace: t.pod.Namespace},
+	}
+	for {
+		if err := r.app.waitForDisruptionBudget(ctx, *t.pod); err != nil {
+			return err
+		}
+
+		err := r.app.client.CoreV1().Pods(t.pod.Namespace).EvictV1(ctx, eviction)
+		if err == nil {
+			return nil
+		}
+		// the budget was used up since it was checked
+		if !apierrors.IsTooManyRequests(err) {
+			return fmt.Errorf("failed to evict pod %s: %w", t, err)
+		}
+
+		log.Printf("Eviction of %s is not allowed by its disruption budget yet, retrying", t)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to evict pod %s: %w", t, ctx.Err())
+		case <-time.After(disruptionPollInterval):
+		}
+	}
+}
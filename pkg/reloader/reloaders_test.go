@@ -0,0 +1,120 @@
+package reloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFluentdRPCReloaderReloadOnce(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		status int
+		body   string
+		// wantErr is part of the error, empty when the reload succeeds
+		wantErr string
+	}{
+		{name: "empty body", method: http.MethodGet, status: http.StatusOK},
+		{name: "ok", method: http.MethodGet, status: http.StatusOK, body: `{"ok":true}`},
+		{name: "post", method: http.MethodPost, status: http.StatusOK, body: `{"ok":true}`},
+		{name: "byte order mark", method: http.MethodGet, status: http.StatusOK, body: "\xef\xbb\xbf{\"ok\":true}\n"},
+		{
+			name:    "not ok",
+			method:  http.MethodGet,
+			status:  http.StatusOK,
+			body:    `{"ok":false}`,
+			wantErr: "did not acknowledge",
+		},
+		{
+			name:    "worker not ok",
+			method:  http.MethodGet,
+			status:  http.StatusOK,
+			body:    `[{"ok":true},{"ok":false}]`,
+			wantErr: "workers [1]",
+		},
+		{
+			name:    "not found",
+			method:  http.MethodGet,
+			status:  http.StatusNotFound,
+			wantErr: "404",
+		},
+		{
+			name:    "server error",
+			method:  http.MethodPost,
+			status:  http.StatusInternalServerError,
+			wantErr: "500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, accept string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, accept = r.Method, r.Header.Get("Accept")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			r := fluentdRPCReloader{client: srv.Client(), method: tt.method, userAgent: "fluentd-reloader"}
+			_, err := r.reloadOnce(context.Background(), target{host: srv.Listener.Addr().String()}, srv.URL+"/api/config.gracefulReload")
+
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("reloadOnce() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("reloadOnce() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if method != tt.method {
+				t.Errorf("method = %s, want %s", method, tt.method)
+			}
+			if accept != "application/json" {
+				t.Errorf("Accept = %q, want application/json", accept)
+			}
+		})
+	}
+}
+
+func TestFluentdRPCReloaderReloadTerminal(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{name: "bad request", status: http.StatusBadRequest},
+		{name: "unauthorized", status: http.StatusUnauthorized},
+		{name: "forbidden", status: http.StatusForbidden},
+		{name: "method not allowed", status: http.StatusMethodNotAllowed},
+		{name: "not ok", status: http.StatusOK, body: `{"ok":false}`},
+		{name: "not json", status: http.StatusOK, body: "<html></html>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			r := fluentdRPCReloader{client: srv.Client(), method: http.MethodGet}
+			_, err := r.reload(context.Background(), target{host: srv.Listener.Addr().String()}, srv.URL+"/api/config.gracefulReload")
+
+			if err == nil {
+				t.Fatal("reload() error = nil")
+			}
+			if ErrorClass(err) != ErrorClassTerminal {
+				t.Errorf("ErrorClass() = %s, want %s", ErrorClass(err), ErrorClassTerminal)
+			}
+			if n := atomic.LoadInt32(&requests); n != 1 {
+				t.Errorf("sent %d requests, want 1", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	maxRenewalPollInterval = time.Minute
+	// renewalBackoff keeps the reloader from triggering a renewal again right
+	// after cert-manager failed to issue one
+	renewalBackoff = time.Hour
+)
+
+// renewalPending reports whether cert-manager is issuing a new certificate
+// that has not been written to the secret yet
+func renewalPending(cert cmapi.Certificate) bool {
+	ready := false
+	for _, condition := range cert.Status.Conditions {
+		switch condition.Type {
+		case cmapi.CertificateConditionIssuing:
+			if condition.Status == cmmeta.ConditionTrue {
+				return true
+			}
+		case cmapi.CertificateConditionReady:
+			ready = condition.Status == cmmeta.ConditionTrue
+		}
+	}
+
+	renewalTime := cert.Status.RenewalTime
+	return renewalTime != nil && renewalTime.Time.Before(time.Now()) && !ready
+}
+
+// waitForRenewal polls the certificate with an increasing interval until the
+// renewal finished or the configured wait elapsed, it returns the latest certificate
+func (a app) waitForRenewal(ctx context.Context, cfg Config) (cmapi.Certificate, error) {
+	cert, err := a.getCertificate(ctx)
+	if err != nil || cfg.RenewalWait <= 0 {
+		return cert, err
+	}
+
+	deadline := time.Now().Add(cfg.RenewalWait)
+	interval := 5 * time.Second
+	for renewalPending(cert) && time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return cert, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		cert, err = a.getCertificate(ctx)
+		if err != nil {
+			return cert, err
+		}
+
+		interval *= 2
+		if interval > maxRenewalPollInterval {
+			interval = maxRenewalPollInterval
+		}
+	}
+
+	return cert, nil
+}
+
+// triggerRenewal asks cert-manager to reissue the certificate like cmctl renew,
+// by setting its Issuing condition, and returns the updated certificate. It
+// returns false without a change when the last issuance failed recently.
+func (a app) triggerRenewal(ctx context.Context, cert cmapi.Certificate) (cmapi.Certificate, bool, error) {
+	if failed := cert.Status.LastFailureTime; failed != nil && time.Since(failed.Time) < renewalBackoff {
+		log.Printf("Not triggering a renewal of certificate %s, issuing it failed at %v", cert.Name, failed.Time)
+		return cert, false, nil
+	}
+
+	now := metav1.Now()
+	issuing := cmapi.CertificateCondition{
+		Type:               cmapi.CertificateConditionIssuing,
+		Status:             cmmeta.ConditionTrue,
+		Reason:             "ManuallyTriggered",
+		Message:            "Certificate re-issuance triggered by fluentd-reloader as the served certificate expires soon",
+		LastTransitionTime: &now,
+		ObservedGeneration: cert.Generation,
+	}
+	conditions := []cmapi.CertificateCondition{}
+	for _, condition := range cert.Status.Conditions {
+		if condition.Type != cmapi.CertificateConditionIssuing {
+			conditions = append(conditions, condition)
+		}
+	}
+	conditions = append(conditions, issuing)
+
+	// the resource version makes the patch fail when cert-manager changed the status meanwhile
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": cert.ResourceVersion},
+		"status":   map[string]interface{}{"conditions": conditions},
+	})
+	if err != nil {
+		return cert, false, fmt.Errorf("failed to trigger renewal of certificate %s: %w", cert.Name, err)
+	}
+
+	uri, err := certificatesPath(a.client, cert.Namespace)
+	if err != nil {
+		return cert, false, fmt.Errorf("failed to trigger renewal of certificate %s: %w", cert.Name, err)
+	}
+	err = a.client.Discovery().RESTClient().Patch(types.MergePatchType).AbsPath(uri, cert.Name, "status").Body(patch).Do(ctx).Error()
+	if err != nil {
+		return cert, false, fmt.Errorf("failed to trigger renewal of certificate %s: %w", cert.Name, err)
+	}
+
+	cert.Status.Conditions = conditions
+	return cert, true, nil
+}
@@ -0,0 +1,129 @@
+package reloader
+
+import "time"
+
+// Status values of a TargetReport
+const (
+	StatusInSync   = "in-sync"
+	StatusReloaded = "reloaded"
+	// StatusRenewalPending means cert-manager is still issuing the renewed certificate
+	StatusRenewalPending = "renewal-pending"
+	StatusError          = "error"
+	// StatusReloadPaused means fluentd serves a stale certificate but reloads are paused
+	StatusReloadPaused = "reload-paused"
+	// StatusReloadDeferred means fluentd serves a stale certificate outside of the reload window
+	StatusReloadDeferred = "reload-deferred"
+	// StatusSecretPropagating means kubelet has not updated the secret volume of some pods yet
+	StatusSecretPropagating = "secret-propagating"
+	// StatusDelegated means a spawned job checks the target
+	StatusDelegated = "delegated"
+)
+
+// Statuses are the statuses a check reports a target with, a new status has
+// to be added here to be exported as metric
+var Statuses = []string{
+	StatusInSync, StatusReloaded, StatusRenewalPending, StatusError, StatusReloadPaused,
+	StatusReloadDeferred, StatusSecretPropagating, StatusDelegated,
+}
+
+// TargetReport is the outcome of checking a single target
+type TargetReport struct {
+	Cluster string `json:"cluster,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Status  string `json:"status"`
+	// RunID correlates the logs, events and fluentd calls of the check
+	RunID          string    `json:"runId,omitempty"`
+	ServedNotAfter time.Time `json:"servedNotAfter"`
+	// ServedSerial and ServedIssuer identify the certificate the primary service serves
+	ServedSerial string `json:"servedSerial,omitempty"`
+	ServedIssuer string `json:"servedIssuer,omitempty"`
+	// ExpectedSerial is the serial of the certificate fluentd was reloaded for
+	ExpectedSerial   string           `json:"expectedSerial,omitempty"`
+	ExpectedNotAfter time.Time        `json:"expectedNotAfter"`
+	Endpoints        []EndpointReport `json:"endpoints,omitempty"`
+	DiscoveredPods   []string         `json:"discoveredPods,omitempty"`
+	SkippedPods      map[string]int   `json:"skippedPods,omitempty"`
+	Revoked          bool             `json:"revoked,omitempty"`
+	// BufferGatedPods were not reloaded because their buffers were too deep
+	BufferGatedPods []string `json:"bufferGatedPods,omitempty"`
+	// UnhealthyPods were not reloaded because they were unhealthy
+	UnhealthyPods []string `json:"unhealthyPods,omitempty"`
+	// UnpropagatedPods have not the expected certificate mounted yet
+	UnpropagatedPods []string `json:"unpropagatedPods,omitempty"`
+	// SecretSync is the sync status of the ExternalSecret or SealedSecret writing the TLS secret
+	SecretSync *SecretSyncReport `json:"secretSync,omitempty"`
+	// HostnameMismatch lists the service URLs serving a certificate not valid for them
+	HostnameMismatch []string `json:"hostnameMismatch,omitempty"`
+	// DriftedPods run a config differing from the fluentd ConfigMap
+	DriftedPods []string `json:"driftedPods,omitempty"`
+	// OpenCircuits are not reloaded until their cooldown passed because their reloads kept failing
+	OpenCircuits []string `json:"openCircuits,omitempty"`
+	// ExpiryWarning means the served certificate expires soon without a renewal
+	ExpiryWarning bool `json:"expiryWarning,omitempty"`
+	// RenewalTriggered means the reloader asked cert-manager to renew the certificate
+	RenewalTriggered bool           `json:"renewalTriggered,omitempty"`
+	Actions          []ReloadAction `json:"actions,omitempty"`
+	// ForwarderActions are the reloads of the forwarders after the aggregators
+	ForwarderActions []ReloadAction `json:"forwarderActions,omitempty"`
+	Error            string         `json:"error,omitempty"`
+	// ErrorClass is ErrorClassRetriable or ErrorClassTerminal when the check failed
+	ErrorClass string `json:"errorClass,omitempty"`
+}
+
+// EndpointReport is the outcome of probing a single service URL
+type EndpointReport struct {
+	URL            string    `json:"url"`
+	ServedNotAfter time.Time `json:"servedNotAfter"`
+	InSync         bool      `json:"inSync"`
+}
+
+// Report is the outcome of a run over all targets
+type Report struct {
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Targets     []TargetReport `json:"targets"`
+}
+
+// Outcome values of a ReloadAction
+const (
+	OutcomeReloaded = "reloaded"
+	OutcomeFailed   = "failed"
+	OutcomeSkipped  = "skipped"
+	// OutcomeUnhealthy means the instance was not reloaded because it is unhealthy
+	OutcomeUnhealthy = "unhealthy"
+	// OutcomeCircuitOpen means the instance was not reloaded because its reloads kept failing
+	OutcomeCircuitOpen = "circuit-open"
+)
+
+// Reason values of a ReloadAction, why the instance was reloaded
+const (
+	ReasonCertRotation = "cert_rotation"
+	// ReasonConfigMapChange means the running config drifted from the ConfigMap
+	ReasonConfigMapChange = "configmap_change"
+	ReasonForced          = "forced"
+	// ReasonFallbackRestart means the pod was restarted because its reloads kept failing
+	ReasonFallbackRestart = "fallback_restart"
+	// ReasonFallbackStrategy means fluentd did not support RPC reloads and the
+	// pod was reloaded with FallbackStrategy
+	ReasonFallbackStrategy = "fallback_strategy"
+)
+
+// ReloadAction is the outcome of reloading a single fluentd instance
+type ReloadAction struct {
+	Target  string `json:"target"`
+	Outcome string `json:"outcome"`
+	// Reason is why the instance was reloaded, e.g. ReasonCertRotation
+	Reason   string `json:"reason,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Workers are the results of the workers of a multi-worker fluentd
+	Workers []WorkerResult `json:"workers,omitempty"`
+	// ConfigHash is the SHA-256 of the config fluentd runs after the reload
+	ConfigHash string `json:"configHash,omitempty"`
+}
+
+// WorkerResult is the outcome of reloading a single fluentd worker
+type WorkerResult struct {
+	Worker int    `json:"worker"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,103 @@
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// reportKeyPrefix prefixes the ConfigMap keys of the stored reports, the
+// timestamp after it sorts the keys by age
+const reportKeyPrefix = "report-"
+
+// storeReport adds the report to the report history ConfigMap, keeping the
+// newest ReportHistoryLimit reports so the reload history can be read when
+// the logging pipeline itself is broken
+func storeReport(ctx context.Context, cfg Config, report Report) error {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	key := reportKey(report)
+
+	configMaps := cfg.Client.CoreV1().ConfigMaps(cfg.Namespace)
+	// targets are checked concurrently, so updates of the ConfigMap can conflict
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, cfg.ReportHistoryConfigMap, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: cfg.ReportHistoryConfigMap, Namespace: cfg.Namespace},
+				Data:       map[string]string{key: string(b)},
+			}
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// created concurrently, retried as a conflict
+				return apierrors.NewConflict(corev1.Resource("configmaps"), cfg.ReportHistoryConfigMap, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[key] = string(b)
+		pruneReports(cm.Data, cfg.ReportHistoryLimit)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store report in configmap %s: %w", cfg.ReportHistoryConfigMap, err)
+	}
+
+	return nil
+}
+
+// storeReportHistory stores the report when a report history ConfigMap is
+// configured, failing to store it does not fail the run
+func storeReportHistory(ctx context.Context, cfg Config, report Report) {
+	if cfg.ReportHistoryConfigMap == "" {
+		return
+	}
+	if err := storeReport(ctx, cfg, report); err != nil {
+		log.Println(err)
+	}
+}
+
+// reportKey names the report after its generation time and the run ID
+func reportKey(report Report) string {
+	key := reportKeyPrefix + report.GeneratedAt.UTC().Format("20060102T150405.000Z")
+	if len(report.Targets) > 0 && report.Targets[0].RunID != "" {
+		key += "-" + report.Targets[0].RunID
+	}
+
+	return key + ".json"
+}
+
+// pruneReports removes the oldest reports beyond the limit, other keys are kept
+func pruneReports(data map[string]string, limit int) {
+	keys := []string{}
+	for key := range data {
+		if strings.HasPrefix(key, reportKeyPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) <= limit {
+		return
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys[:len(keys)-limit] {
+		delete(data, key)
+	}
+}
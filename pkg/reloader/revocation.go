@@ -0,0 +1,92 @@
+package reloader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// revoked reports whether the served certificate is revoked according to its
+// stapled OCSP response or the configured CRL
+func revoked(ctx context.Context, cfg Config, state tls.ConnectionState) (bool, error) {
+	leaf := state.PeerCertificates[0]
+	var issuer *x509.Certificate
+	if len(state.PeerCertificates) > 1 {
+		issuer = state.PeerCertificates[1]
+	}
+
+	if cfg.CheckOCSP {
+		switch {
+		case len(state.OCSPResponse) == 0:
+			log.Println("Served certificate has no stapled OCSP response")
+		case issuer == nil:
+			log.Println("Served chain has no issuer to verify the OCSP response with")
+		default:
+			resp, err := ocsp.ParseResponseForCert(state.OCSPResponse, leaf, issuer)
+			if err != nil {
+				return false, fmt.Errorf("failed to parse OCSP response: %w", err)
+			}
+			if resp.Status == ocsp.Revoked {
+				log.Printf("OCSP response reports the served certificate revoked at %v", resp.RevokedAt)
+				return true, nil
+			}
+		}
+	}
+
+	if cfg.CRLURL != "" {
+		crl, err := fetchCRL(ctx, cfg)
+		if err != nil {
+			return false, err
+		}
+		if issuer != nil {
+			if err := crl.CheckSignatureFrom(issuer); err != nil {
+				return false, fmt.Errorf("CRL is not signed by the issuer of the served certificate: %w", err)
+			}
+		}
+
+		for _, entry := range crl.RevokedCertificates {
+			if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+				log.Printf("CRL %s lists the served certificate revoked at %v", cfg.CRLURL, entry.RevocationTime)
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func fetchCRL(ctx context.Context, cfg Config) (*x509.RevocationList, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.CRLURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL request: %w", err)
+	}
+
+	client := &http.Client{Timeout: cfg.RPCTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch CRL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(fmt.Errorf("failed to fetch CRL: %s", resp.Status), resp.StatusCode, "")
+	}
+
+	der, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL: %w", err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CRL: %w", err)
+	}
+
+	return crl, nil
+}
@@ -0,0 +1,99 @@
+package reloader
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// RPCProtocol values select the HTTP version of the fluentd RPC calls
+const (
+	// RPCProtocolAuto negotiates HTTP/2 over TLS and uses HTTP/1.1 otherwise
+	RPCProtocolAuto = "auto"
+	// RPCProtocolHTTP1 always uses HTTP/1.1
+	RPCProtocolHTTP1 = "http1"
+	// RPCProtocolHTTP2 always uses HTTP/2, without TLS as h2c with prior
+	// knowledge, e.g. for RPC endpoints behind Envoy
+	RPCProtocolHTTP2 = "http2"
+)
+
+// maxRetryAfter caps how long a Retry-After header delays the next attempt
+const maxRetryAfter = 30 * time.Second
+
+// rpcRoundTripper returns the transport of the fluentd RPC calls for the protocol
+func rpcRoundTripper(cfg Config) http.RoundTripper {
+	transport := newRPCTransport(cfg)
+	switch cfg.RPCProtocol {
+	case RPCProtocolHTTP1:
+		transport.ForceAttemptHTTP2 = false
+		// a non-nil empty map disables the HTTP/2 upgrade
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case RPCProtocolHTTP2:
+		if !cfg.rpcTLSEnabled() {
+			return h2cTransport(cfg)
+		}
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	return transport
+}
+
+// h2cTransport speaks HTTP/2 over plain TCP connections
+func h2cTransport(cfg Config) http.RoundTripper {
+	dialer := &net.Dialer{Timeout: cfg.RPCTimeout}
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// validRPCProtocol checks the protocol setting against the other RPC settings
+func validRPCProtocol(cfg Config) error {
+	switch cfg.RPCProtocol {
+	case "", RPCProtocolAuto, RPCProtocolHTTP1:
+		return nil
+	case RPCProtocolHTTP2:
+		if !cfg.rpcTLSEnabled() && cfg.RPCProxy != "" && cfg.RPCProxy != ProxyNone {
+			return fmt.Errorf("rpc protocol %s without TLS does not support a proxy", RPCProtocolHTTP2)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("invalid rpc protocol %q, must be %s, %s or %s", cfg.RPCProtocol, RPCProtocolAuto, RPCProtocolHTTP1, RPCProtocolHTTP2)
+}
+
+// goingAway reports whether the server closed the HTTP/2 connection with
+// GOAWAY, e.g. Envoy draining its listeners
+func goingAway(err error) bool {
+	var goAway http2.GoAwayError
+	// the HTTP/2 implementation bundled with net/http has no exported error type
+	return errors.As(err, &goAway) || strings.Contains(err.Error(), "GOAWAY")
+}
+
+// parseRetryAfter returns the delay of a Retry-After header in seconds or as
+// HTTP date, zero when it is missing or invalid
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,116 @@
+package reloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// rpcTLSEnabled reports whether the fluentd RPC is called over HTTPS
+func (c Config) rpcTLSEnabled() bool {
+	return c.RPCClientCert != "" || c.RPCCAFile != ""
+}
+
+// rpcScheme returns the URL scheme of the fluentd RPC
+func (c Config) rpcScheme() string {
+	if c.rpcTLSEnabled() {
+		return "https"
+	}
+
+	return "http"
+}
+
+// rpcTLSConfig returns the TLS config of the fluentd RPC calls, presenting the
+// client certificate of the reloader when one is configured
+func rpcTLSConfig(cfg Config) *tls.Config {
+	conf := &tls.Config{ServerName: cfg.RPCServerName}
+	if cfg.RPCCAFile != "" {
+		pool, err := loadCertPool(cfg.RPCCAFile)
+		if err != nil {
+			// an empty pool fails every handshake instead of trusting the system roots
+			log.Println(err)
+			pool = x509.NewCertPool()
+		}
+		conf.RootCAs = pool
+	}
+	if cfg.RPCClientCert != "" {
+		conf.GetClientCertificate = (&clientCertificate{certFile: cfg.RPCClientCert, keyFile: cfg.RPCClientKey}).get
+	}
+
+	return conf
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file %s: %w", file, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA file %s contains no certificates", file)
+	}
+
+	return pool, nil
+}
+
+// clientCertificate serves the client certificate from its files, loading it
+// again whenever one of the files changed so a certificate rotated by
+// cert-manager is used without restarting the reloader
+type clientCertificate struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (c *clientCertificate) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime, err := latestModTime(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if c.cert != nil && modTime.Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			// the files may be mid-rotation, keep the previous certificate until both are written
+			log.Printf("Failed to load the rotated RPC client certificate, using the previous one: %v", err)
+			return c.cert, nil
+		}
+
+		return nil, fmt.Errorf("failed to load RPC client certificate: %w", err)
+	}
+	if c.cert != nil {
+		log.Printf("Loaded the rotated RPC client certificate from %s", c.certFile)
+	}
+	c.cert, c.modTime = &cert, modTime
+
+	return c.cert, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s: %w", file, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,571 @@
+package reloader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Run checks every configured target once, reloading fluentd where the served
+// certificate is out of sync. The error reports failed targets, their details
+// are part of the report.
+func Run(ctx context.Context, cfg Config) (Report, error) {
+	if err := cfg.Validate(); err != nil {
+		return Report{}, err
+	}
+
+	cfg = cfg.withDefaults()
+	report, err := runTargets(ctx, cfg, checkAndRecord)
+	storeReportHistory(ctx, cfg, report)
+
+	return report, err
+}
+
+// ForceReload reloads fluentd on every configured target regardless of the
+// served certificate, e.g. during an incident
+func ForceReload(ctx context.Context, cfg Config) (Report, error) {
+	cfg = cfg.withDefaults()
+	// a forced reload bypasses the buffer check
+	cfg.MaxBufferQueueLength, cfg.MaxRetryCount = 0, 0
+	if err := cfg.validateReload(); err != nil {
+		return Report{}, err
+	}
+
+	report, err := runTargets(ctx, cfg, forceReload)
+	storeReportHistory(ctx, cfg, report)
+
+	return report, err
+}
+
+// runTargets runs fn for every configured target and collects the report
+func runTargets(ctx context.Context, cfg Config, fn func(context.Context, app, Config) (TargetReport, error)) (Report, error) {
+	ctx, runID := ensureRunID(ctx)
+	if cfg.RunDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.RunDeadline)
+		defer cancel()
+	}
+
+	report := Report{}
+	targets, err := loadTargets(ctx, cfg)
+	if err != nil {
+		report.GeneratedAt = time.Now().UTC()
+		report.Targets = []TargetReport{{RunID: runID, Status: StatusError, Error: err.Error(), ErrorClass: ErrorClass(err)}}
+		return report, err
+	}
+	pruneFluentdReloads(ctx, cfg, targets)
+
+	var firstErr error
+	failed := 0
+	for _, target := range targets {
+		app := app{
+			namespace:     target.Namespace,
+			certNamespace: target.CertNamespace,
+			certName:      target.CertName,
+			secretName:    target.SecretName,
+			certFile:      target.CertFile,
+			source:        target.CertSource,
+			selector:      target.Selector,
+			statefulSet:   target.StatefulSetName,
+			client:        target.Client,
+			podCache:      target.PodCache,
+			restConfig:    target.RESTConfig,
+			shards:        target.Shards,
+			shardIndex:    target.ShardIndex,
+			fieldSelector: target.FieldSelector,
+			nodeName:      target.NodeName,
+			zone:          target.Zone,
+		}
+
+		if len(targets) > 1 {
+			log.Printf("Run %s checking target %s", runID, target.Name)
+		} else {
+			log.Printf("Run %s checking fluentd", runID)
+		}
+
+		s, err := TargetReport{}, validateTarget(target)
+		if err == nil {
+			s, err = fn(ctx, app, target)
+		}
+		if len(targets) > 1 {
+			s.Target = target.Name
+		}
+		s.Cluster = target.Cluster
+		s.RunID = runID
+		if err != nil {
+			log.Printf("Run %s: target %s failed: %v", runID, target.Name, err)
+			s.Status = StatusError
+			s.Error = err.Error()
+			s.ErrorClass = ErrorClass(err)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		report.Targets = append(report.Targets, s)
+	}
+	report.GeneratedAt = time.Now().UTC()
+
+	if firstErr != nil && len(targets) > 1 {
+		return report, fmt.Errorf("%d of %d targets failed, first error: %w", failed, len(targets), firstErr)
+	}
+
+	return report, firstErr
+}
+
+// inSync reports whether the served certificate is the one the Certificate resource expects
+func (a app) inSync(ctx context.Context, config Config, certificate cmapi.Certificate, servedChain []*x509.Certificate) (bool, error) {
+	servedCert := servedChain[0]
+	if certificate.Status.NotAfter == nil || !config.NotAfterMatches(servedCert.NotAfter, certificate.Status.NotAfter.Time) {
+		return false, nil
+	}
+	if !config.ComparePublicKey && !config.CompareChain {
+		return true, nil
+	}
+
+	secretCerts, err := a.getCertificateChain(ctx, certificate)
+	if err != nil {
+		return false, err
+	}
+
+	if config.ComparePublicKey {
+		servedPin, expectedPin := publicKeyPin(servedCert), publicKeyPin(secretCerts[0])
+		if servedPin != expectedPin {
+			log.Printf("Served public key %s does not match the public key %s of secret %s", servedPin, expectedPin, certificate.Spec.SecretName)
+			return false, nil
+		}
+	}
+
+	if config.CompareChain {
+		var ca []*x509.Certificate
+		if config.CertFile == "" && config.CertSource == nil {
+			if ca, err = a.getSecretCA(ctx, certificate); err != nil {
+				return false, err
+			}
+		}
+		if !sameIntermediates(servedChain, secretCerts, ca) {
+			log.Printf("Served intermediates do not match the chain of secret %s", certificate.Spec.SecretName)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// endpointsInSync reports whether every service URL serves the expected
+// certificate, recording the per URL results when there are several
+func (a app) endpointsInSync(ctx context.Context, config Config, certificate cmapi.Certificate, serviceURLs []string, servedChains [][]*x509.Certificate, s *TargetReport) (bool, error) {
+	allInSync := true
+	endpoints := make([]EndpointReport, 0, len(serviceURLs))
+	for i, serviceURL := range serviceURLs {
+		inSync, err := a.inSync(ctx, config, certificate, servedChains[i])
+		if err != nil {
+			return false, err
+		}
+		if !inSync {
+			log.Printf("%s serves a stale certificate expiring %v", serviceURL, servedChains[i][0].NotAfter)
+			allInSync = false
+		}
+
+		endpoints = append(endpoints, EndpointReport{URL: serviceURL, ServedNotAfter: servedChains[i][0].NotAfter, InSync: inSync})
+	}
+
+	if len(serviceURLs) > 1 {
+		s.Endpoints = endpoints
+	}
+
+	return allInSync, nil
+}
+
+// discoverTargets returns the fluentd targets and records them in the report
+func discoverTargets(ctx context.Context, app app, config Config, s *TargetReport) ([]target, error) {
+	fluentdTargets, skipped, err := app.getFluentdTargets(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if len(skipped) > 0 {
+		log.Printf("Skipped pods by reason: %v", skipped)
+		s.SkippedPods = skipped
+	}
+	for _, t := range fluentdTargets {
+		s.DiscoveredPods = append(s.DiscoveredPods, t.String())
+	}
+
+	return fluentdTargets, nil
+}
+
+// reloadTargets reloads the targets with the configured buffer check, ordering,
+// canary and forward check and records the actions with the reason in the
+// report, the canary needs the expected certificate expiry
+func reloadTargets(ctx context.Context, app app, config Config, reason string, fluentdTargets []target, expected *metav1.Time, s *TargetReport) error {
+	reloader := newReloader(app, config)
+	var unhealthy []ReloadAction
+	if config.SkipUnhealthy {
+		fluentdTargets, unhealthy = gateHealth(ctx, config, fluentdTargets)
+		for _, action := range unhealthy {
+			s.UnhealthyPods = append(s.UnhealthyPods, action.Target)
+		}
+	}
+	var gated []ReloadAction
+	if config.bufferGateEnabled() {
+		fluentdTargets, gated = gateBuffers(ctx, config, fluentdTargets)
+		for _, action := range gated {
+			s.BufferGatedPods = append(s.BufferGatedPods, action.Target)
+		}
+	}
+	var restart []target
+	var circuitOpen []ReloadAction
+	if config.CircuitFailures > 0 {
+		fluentdTargets, restart, circuitOpen = gateCircuits(app, config, fluentdTargets)
+	}
+	var held []target
+	if config.OrderedReload {
+		fluentdTargets, held = orderTargets(fluentdTargets, config.ReloadPartition)
+		if len(held) > 0 {
+			log.Printf("Holding back %d pods below partition %d: %v", len(held), config.ReloadPartition, held)
+		}
+	}
+
+	if err := checkBlastRadius(config, reason, fluentdTargets, len(s.DiscoveredPods)); err != nil {
+		return err
+	}
+
+	pace := newPacer(config)
+	actions, err := reloadByPriority(ctx, config, fluentdTargets, func(targets ...target) ([]ReloadAction, error) {
+		if config.Canary && len(targets) > 1 && expected != nil {
+			return app.reloadWithCanary(ctx, config, reloader, pace, expected.Time, targets...)
+		}
+
+		return reloadFluentdConfig(ctx, reloader, pace, targets...)
+	})
+	for i := range actions {
+		if actions[i].Reason == "" {
+			actions[i].Reason = reason
+		}
+	}
+	if err == nil && len(restart) > 0 {
+		var restarted []ReloadAction
+		restarted, err = reloadFluentdConfig(ctx, reloaders[StrategyPodDelete](app, config), fixedPause(config.ReloadPause), restart...)
+		for i := range restarted {
+			restarted[i].Reason = ReasonFallbackRestart
+		}
+		actions = append(actions, restarted...)
+	}
+	if config.CircuitFailures > 0 {
+		recordCircuits(ctx, app, config, append(fluentdTargets, restart...), actions)
+		s.OpenCircuits = openCircuits(app, config, append(append(fluentdTargets, restart...), held...))
+		for _, action := range circuitOpen {
+			s.OpenCircuits = append(s.OpenCircuits, action.Target)
+		}
+	}
+	for _, t := range held {
+		actions = append(actions, ReloadAction{Target: t.String(), Outcome: OutcomeSkipped})
+	}
+	s.Actions = append(append(append(actions, gated...), unhealthy...), circuitOpen...)
+	if err == nil && config.ForwardCheck {
+		err = checkForwardInputs(ctx, config, fluentdTargets)
+	}
+	if err == nil && config.ForwarderSelector != "" {
+		err = reloadForwarders(ctx, app, config, s)
+		for i := range s.ForwarderActions {
+			if s.ForwarderActions[i].Reason == "" {
+				s.ForwarderActions[i].Reason = reason
+			}
+		}
+	}
+
+	return err
+}
+
+// forceReload reloads the fluentd targets without checking the certificate
+func forceReload(ctx context.Context, app app, config Config) (TargetReport, error) {
+	s := TargetReport{}
+
+	fluentdTargets, err := discoverTargets(ctx, app, config, &s)
+	if err != nil {
+		return s, err
+	}
+
+	log.Printf("Force reloading %d fluentd targets", len(fluentdTargets))
+	err = reloadTargets(ctx, app, config, ReasonForced, fluentdTargets, nil, &s)
+	if err != nil {
+		return s, err
+	}
+	s.Status = StatusReloaded
+
+	return s, nil
+}
+
+func run(ctx context.Context, app app, config Config) (TargetReport, error) {
+	s := TargetReport{}
+
+	fluentdTargets, err := discoverTargets(ctx, app, config, &s)
+	if err != nil {
+		return s, err
+	}
+	if config.SecretManager != "" {
+		s.SecretSync, err = app.waitForSecretSync(ctx, config)
+		if err != nil {
+			return s, err
+		}
+	}
+	if config.CheckMode == CheckModeSecretRevision {
+		return runSecretRevision(ctx, app, config, fluentdTargets, s)
+	}
+
+	probeAddress := config.ProbeAddress
+	if config.ProbePortForward != "" {
+		address, stop, err := app.portForwardService(ctx, config.ProbePortForward, config.ProbePort)
+		if err != nil {
+			return s, err
+		}
+		defer stop()
+		probeAddress = address
+	}
+	// the probe address and the port-forward replace resolving the primary service
+	resolvePrimary := probeAddress == ""
+	if probeAddress == "" {
+		probeAddress = net.JoinHostPort(config.ServiceURL, strconv.Itoa(config.ProbePort))
+	}
+
+	tlsConfig, err := probeTLSConfig(config)
+	if err != nil {
+		return s, err
+	}
+
+	serviceURLs := append([]string{config.ServiceURL}, config.ServiceURLs...)
+	servedChains := make([][]*x509.Certificate, 0, len(serviceURLs))
+	var primary tls.ConnectionState
+	var mismatched []string
+	for i, serviceURL := range serviceURLs {
+		address := net.JoinHostPort(serviceURL, strconv.Itoa(config.ProbePort))
+		if i == 0 {
+			// the port-forward and the probe address only reach the primary service
+			address = probeAddress
+		}
+		if i > 0 || resolvePrimary {
+			if err := resolveServiceURL(ctx, config, serviceURL); err != nil {
+				return s, err
+			}
+		}
+
+		result, err := probe(ctx, config, serviceURL, address, config.ProbeProxy, tlsConfig)
+		if err != nil {
+			return s, err
+		}
+		if i == 0 {
+			primary = result.State
+			s.ServedSerial, s.ServedIssuer = result.Serial, result.Issuer
+		}
+		servedChains = append(servedChains, result.State.PeerCertificates)
+		if result.HostnameMismatch {
+			log.Printf("%s serves a certificate for %v, which is not valid for it", serviceURL, result.DNSNames)
+			mismatched = append(mismatched, serviceURL)
+		}
+	}
+	s.HostnameMismatch = mismatched
+	servedCert := servedChains[0][0]
+	expiry := servedCert.NotAfter
+	s.ServedNotAfter = expiry
+
+	certificate, err := app.getCertificate(ctx)
+	if err != nil {
+		return s, err
+	}
+	if certificate.Status.NotAfter != nil {
+		s.ExpectedNotAfter = certificate.Status.NotAfter.Time
+	}
+
+	log.Printf("Certificate will expire on %v\n", expiry)
+	inSync, err := app.endpointsInSync(ctx, config, certificate, serviceURLs, servedChains, &s)
+	if err != nil {
+		return s, err
+	}
+	if len(mismatched) > 0 {
+		// a certificate of the old domain is still served, a reload picks up the new one
+		if err := app.checkExpectedHostnames(ctx, certificate, mismatched); err != nil {
+			return s, err
+		}
+		inSync = false
+	}
+
+	renewalTriggered := false
+	if expiringWithoutRenewal(config, certificate, expiry) {
+		log.Printf("Served certificate expires on %v and cert-manager has not renewed it", expiry)
+		s.ExpiryWarning = true
+		if config.RecordHistory {
+			app.recordHistory(ctx, certificate, corev1.EventTypeWarning, reasonExpiring,
+				fmt.Sprintf("%s serves a certificate expiring %v and no renewal was issued", config.ServiceURL, expiry))
+		}
+		if config.TriggerRenewal {
+			certificate, renewalTriggered, err = app.triggerRenewal(ctx, certificate)
+			if err != nil {
+				return s, err
+			}
+			if renewalTriggered {
+				log.Printf("Triggered a renewal of certificate %s", certificate.Name)
+				s.RenewalTriggered = true
+				if config.RecordHistory {
+					app.recordHistory(ctx, certificate, corev1.EventTypeNormal, reasonRenewalTriggered,
+						fmt.Sprintf("Triggered a renewal as %s serves a certificate expiring %v", config.ServiceURL, expiry))
+				}
+			}
+		}
+	}
+
+	isRevoked := false
+	if config.CheckOCSP || config.CRLURL != "" {
+		isRevoked, err = revoked(ctx, config, primary)
+		if err != nil {
+			return s, err
+		}
+		if isRevoked {
+			log.Println("Served certificate is revoked")
+			s.Revoked = true
+			inSync = false
+			if config.RecordHistory {
+				app.recordHistory(ctx, certificate, corev1.EventTypeWarning, reasonRevoked,
+					fmt.Sprintf("%s serves the revoked certificate %s", config.ServiceURL, servedCert.SerialNumber))
+			}
+		}
+	}
+
+	// a triggered renewal is waited for like a pending one and reloaded once issued
+	if (!inSync || renewalTriggered) && renewalPending(certificate) {
+		log.Println("Certificate renewal is pending, waiting for cert-manager to issue the new certificate")
+		certificate, err = app.waitForRenewal(ctx, config)
+		if err != nil {
+			return s, err
+		}
+		if certificate.Status.NotAfter != nil {
+			s.ExpectedNotAfter = certificate.Status.NotAfter.Time
+		}
+
+		if renewalPending(certificate) {
+			log.Println("Certificate renewal is still pending, not reloading fluentd yet")
+			s.Status = StatusRenewalPending
+			return s, nil
+		}
+
+		inSync, err = app.endpointsInSync(ctx, config, certificate, serviceURLs, servedChains, &s)
+		if err != nil {
+			return s, err
+		}
+		inSync = inSync && !isRevoked && len(mismatched) == 0
+	}
+
+	drifted := false
+	if inSync && config.ConfigMapName != "" {
+		driftedTargets, err := app.driftedTargets(ctx, config, fluentdTargets)
+		if err != nil {
+			return s, err
+		}
+		for _, t := range driftedTargets {
+			s.DriftedPods = append(s.DriftedPods, t.String())
+		}
+		if len(driftedTargets) > 0 {
+			log.Printf("Certificate is valid but %d pods run a config differing from configmap %s", len(driftedTargets), config.ConfigMapName)
+			fluentdTargets = driftedTargets
+			drifted = true
+		}
+	}
+
+	if inSync && !drifted {
+		log.Printf("Certificate will be renewed on %v\n", certificate.Status.RenewalTime)
+		log.Println("Certificate is valid")
+		s.Status = StatusInSync
+
+		if config.RecordHistory {
+			app.recordHistory(ctx, certificate, corev1.EventTypeNormal, reasonEndpointVerified,
+				fmt.Sprintf("%s serves the certificate expiring %v", config.ServiceURL, expiry))
+		}
+
+		return s, nil
+	}
+
+	if !inSync {
+		log.Println("Certificate is not valid")
+		log.Printf("Certificate should expire on %v but it expires on %v\n", certificate.Status.NotAfter, expiry)
+	}
+	if !inSync && !isRevoked {
+		pending, err := app.pendingDependent(ctx, config, certificate)
+		if err != nil {
+			return s, err
+		}
+		if pending != "" {
+			log.Printf("Dependent certificate %s is not renewed yet, not reloading fluentd yet", pending)
+			s.Status = StatusRenewalPending
+			return s, nil
+		}
+	}
+	if !inSync && config.checksPropagation() {
+		secretCerts, err := app.getCertificateChain(ctx, certificate)
+		if err != nil {
+			return s, err
+		}
+		propagated, err := app.waitForPropagation(ctx, config, certificate, fluentdTargets, secretCerts[0], &s)
+		if err != nil {
+			return s, err
+		}
+		if !propagated {
+			log.Printf("%d pods do not have the renewed certificate mounted yet, not reloading fluentd yet", len(s.UnpropagatedPods))
+			s.Status = StatusSecretPropagating
+			return s, nil
+		}
+	}
+	if config.reloadsPaused() {
+		log.Println("Reloads are paused, not reloading fluentd")
+		s.Status = StatusReloadPaused
+		return s, nil
+	}
+	if config.reloadsDeferred() {
+		log.Printf("Outside of the reload window, deferring the reload to %v", config.ReloadWindow.NextOpen(time.Now()))
+		s.Status = StatusReloadDeferred
+		return s, nil
+	}
+	reason := ReasonCertRotation
+	if inSync && drifted {
+		reason = ReasonConfigMapChange
+	} else if secretCerts, err := app.getCertificateChain(ctx, certificate); err == nil {
+		s.ExpectedSerial = secretCerts[0].SerialNumber.String()
+	} else {
+		log.Printf("Failed to read the serial of the expected certificate: %v", err)
+	}
+	err = reloadTargets(ctx, app, config, reason, fluentdTargets, certificate.Status.NotAfter, &s)
+	if err != nil {
+		if config.RecordHistory {
+			app.recordHistory(ctx, certificate, corev1.EventTypeWarning, reloadFailedReason(err), err.Error())
+		}
+
+		return s, err
+	}
+	s.Status = StatusReloaded
+	if config.RecordHistory {
+		app.recordHistory(ctx, certificate, corev1.EventTypeNormal, reasonReloaded,
+			fmt.Sprintf("Reloaded %d fluentd targets, %s served a certificate expiring %v", len(fluentdTargets), config.ServiceURL, expiry))
+	}
+
+	if config.AnnotatePods {
+		// probe again so the annotation records the certificate served after the reload
+		reloaded, err := probe(ctx, config, config.ServiceURL, probeAddress, config.ProbeProxy, tlsConfig)
+		if err != nil {
+			return s, err
+		}
+
+		if err := app.annotatePods(ctx, fingerprint(reloaded.Certificate), reloadedPods(fluentdTargets, s.Actions)); err != nil {
+			return s, err
+		}
+	}
+
+	return s, nil
+}
@@ -0,0 +1,64 @@
+package reloader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	// runIDHeader carries the run ID on every call to fluentd so reloads can be
+	// found in its access logs
+	runIDHeader = "X-Request-ID"
+	// runIDAnnotation records the run on events and reloaded pods
+	runIDAnnotation = "fluentd-reloader.io/run-id"
+)
+
+type runIDKey struct{}
+
+// NewRunID returns a random ID correlating the logs, events, reports and
+// fluentd calls of a single run
+func NewRunID() string {
+	b := make([]byte, 8)
+	// crypto/rand only fails when the OS has no entropy source
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRunID returns a context carrying the run ID, runs without one get a new ID
+func WithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+// RunID returns the run ID of the context, empty outside of a run
+func RunID(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+// ensureRunID returns the context with a run ID, keeping an existing one
+func ensureRunID(ctx context.Context) (context.Context, string) {
+	if id := RunID(ctx); id != "" {
+		return ctx, id
+	}
+
+	id := NewRunID()
+	return WithRunID(ctx, id), id
+}
+
+// runIDAnnotations returns the annotation recording the run ID of the context
+func runIDAnnotations(ctx context.Context) map[string]string {
+	if id := RunID(ctx); id != "" {
+		return map[string]string{runIDAnnotation: id}
+	}
+
+	return nil
+}
+
+// setRunIDHeader sets the run ID of the request context as X-Request-ID
+func setRunIDHeader(req *http.Request) {
+	if id := RunID(req.Context()); id != "" {
+		req.Header.Set(runIDHeader, id)
+	}
+}
@@ -0,0 +1,165 @@
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// getSecretCertificates returns the certificate chain stored in the tls.crt of
+// the certificate's secret, the leaf comes first
+func (a app) getSecretCertificates(ctx context.Context, cert cmapi.Certificate) ([]*x509.Certificate, error) {
+	secret, err := a.client.CoreV1().Secrets(cert.Namespace).Get(ctx, cert.Spec.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %w", cert.Spec.SecretName, err)
+	}
+
+	certs, err := parseCertificates(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s of secret %s: %w", corev1.TLSCertKey, secret.Name, err)
+	}
+	if len(certs) == 0 {
+		return nil, terminal(fmt.Errorf("secret %s has no certificate in %s", secret.Name, corev1.TLSCertKey), "")
+	}
+
+	return certs, nil
+}
+
+// getSecretCA returns the certificates in the ca.crt of the certificate's
+// secret, cert-manager stores the issuing CA there
+func (a app) getSecretCA(ctx context.Context, cert cmapi.Certificate) ([]*x509.Certificate, error) {
+	secret, err := a.client.CoreV1().Secrets(cert.Namespace).Get(ctx, cert.Spec.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %w", cert.Spec.SecretName, err)
+	}
+
+	certs, err := parseCertificates(secret.Data[cmmeta.TLSCAKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s of secret %s: %w", cmmeta.TLSCAKey, secret.Name, err)
+	}
+
+	return certs, nil
+}
+
+// certificateFromSecret describes a plain TLS secret as a Certificate so it can be
+// checked like one, used when certificates are not issued by cert-manager
+func (a app) certificateFromSecret(ctx context.Context) (cmapi.Certificate, error) {
+	cert := cmapi.Certificate{
+		ObjectMeta: metav1.ObjectMeta{Name: a.secretName, Namespace: a.certNamespace},
+		Spec:       cmapi.CertificateSpec{SecretName: a.secretName},
+	}
+
+	certs, err := a.getSecretCertificates(ctx, cert)
+	if err != nil {
+		return cmapi.Certificate{}, err
+	}
+	notAfter := metav1.NewTime(certs[0].NotAfter)
+	cert.Status.NotAfter = &notAfter
+
+	return cert, nil
+}
+
+// parseCertificates decodes all PEM encoded certificates
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// sameIntermediates reports whether fluentd serves the intermediates of the
+// secret. When the tls.crt chain has intermediates the served ones must equal
+// them, with a leaf-only tls.crt every served intermediate must be one of the
+// CA certificates of ca.crt, which fluentd may be configured to send along.
+// Self-signed roots are ignored, a secret without intermediates always matches
+// as a reload could not change the served ones.
+func sameIntermediates(served, expected, ca []*x509.Certificate) bool {
+	a := intermediates(served)
+	if b := intermediates(expected); len(b) > 0 {
+		return equalCertificates(a, b)
+	}
+
+	known := withoutRoots(ca)
+	if len(known) == 0 {
+		return true
+	}
+	for _, cert := range a {
+		if !containsCertificate(known, cert) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// intermediates returns the certificates after the leaf without self-signed roots
+func intermediates(chain []*x509.Certificate) []*x509.Certificate {
+	if len(chain) < 2 {
+		return nil
+	}
+
+	return withoutRoots(chain[1:])
+}
+
+func withoutRoots(certs []*x509.Certificate) []*x509.Certificate {
+	filtered := make([]*x509.Certificate, 0, len(certs))
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+			continue
+		}
+		filtered = append(filtered, cert)
+	}
+
+	return filtered
+}
+
+func equalCertificates(a, b []*x509.Certificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsCertificate(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// publicKeyPin returns the hex encoded SHA-256 hash of the certificate's SubjectPublicKeyInfo
+func publicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
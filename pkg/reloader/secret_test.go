@@ -0,0 +1,120 @@
+package reloader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCert issues a certificate for name signed by parent, self-signed when parent is nil
+func testCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func TestSameIntermediates(t *testing.T) {
+	root, rootKey := testCert(t, "root", nil, nil)
+	intermediate, intermediateKey := testCert(t, "intermediate", root, rootKey)
+	rotated, _ := testCert(t, "intermediate", root, rootKey)
+	leaf, _ := testCert(t, "fluentd", intermediate, intermediateKey)
+
+	tests := []struct {
+		name     string
+		served   []*x509.Certificate
+		expected []*x509.Certificate
+		ca       []*x509.Certificate
+		want     bool
+	}{
+		{
+			name:     "same chain",
+			served:   []*x509.Certificate{leaf, intermediate},
+			expected: []*x509.Certificate{leaf, intermediate},
+			want:     true,
+		},
+		{
+			name:     "served root is ignored",
+			served:   []*x509.Certificate{leaf, intermediate, root},
+			expected: []*x509.Certificate{leaf, intermediate},
+			want:     true,
+		},
+		{
+			name:     "rotated intermediate",
+			served:   []*x509.Certificate{leaf, rotated},
+			expected: []*x509.Certificate{leaf, intermediate},
+		},
+		{
+			name:     "missing intermediate",
+			served:   []*x509.Certificate{leaf},
+			expected: []*x509.Certificate{leaf, intermediate},
+		},
+		{
+			name:     "leaf-only tls.crt with the intermediate in ca.crt",
+			served:   []*x509.Certificate{leaf, intermediate},
+			expected: []*x509.Certificate{leaf},
+			ca:       []*x509.Certificate{intermediate, root},
+			want:     true,
+		},
+		{
+			name:     "leaf-only tls.crt with another intermediate in ca.crt",
+			served:   []*x509.Certificate{leaf, rotated},
+			expected: []*x509.Certificate{leaf},
+			ca:       []*x509.Certificate{intermediate, root},
+		},
+		{
+			name:     "leaf-only tls.crt with a root-only ca.crt",
+			served:   []*x509.Certificate{leaf, intermediate},
+			expected: []*x509.Certificate{leaf},
+			ca:       []*x509.Certificate{root},
+			want:     true,
+		},
+		{
+			name:     "leaf only",
+			served:   []*x509.Certificate{leaf},
+			expected: []*x509.Certificate{leaf},
+			want:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameIntermediates(tt.served, tt.expected, tt.ca); got != tt.want {
+				t.Errorf("sameIntermediates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
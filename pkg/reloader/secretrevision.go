@@ -0,0 +1,94 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CheckMode values select how a stale certificate is detected
+const (
+	// CheckModeTLSProbe compares the certificate fluentd serves with the Certificate
+	CheckModeTLSProbe = "tls-probe"
+	// CheckModeSecretRevision reloads the pods that were not reloaded since the
+	// secret last changed, without connecting to fluentd
+	CheckModeSecretRevision = "secret-revision"
+)
+
+// runSecretRevision reloads the targets whose pods are not annotated with the
+// fingerprint of the certificate currently stored in the secret
+func runSecretRevision(ctx context.Context, app app, config Config, fluentdTargets []target, s TargetReport) (TargetReport, error) {
+	certificate, err := app.getCertificate(ctx)
+	if err != nil {
+		return s, err
+	}
+	secretCerts, err := app.getCertificateChain(ctx, certificate)
+	if err != nil {
+		return s, err
+	}
+	leaf := secretCerts[0]
+	s.ExpectedNotAfter = leaf.NotAfter
+	expected := fingerprint(leaf)
+
+	stale := make([]target, 0, len(fluentdTargets))
+	for _, t := range fluentdTargets {
+		if t.pod.Annotations[certFingerprintAnnotation] != expected {
+			stale = append(stale, t)
+		}
+	}
+
+	if len(stale) == 0 {
+		log.Println("All pods were reloaded since the secret last changed")
+		s.Status = StatusInSync
+		return s, nil
+	}
+
+	log.Printf("Secret %s changed since %d pods were reloaded: %v", certificate.Spec.SecretName, len(stale), stale)
+	if config.checksPropagation() {
+		propagated, err := app.waitForPropagation(ctx, config, certificate, stale, leaf, &s)
+		if err != nil {
+			return s, err
+		}
+		if !propagated {
+			log.Printf("%d pods do not have the changed secret mounted yet, not reloading fluentd yet", len(s.UnpropagatedPods))
+			s.Status = StatusSecretPropagating
+			return s, nil
+		}
+	}
+	if config.reloadsPaused() {
+		log.Println("Reloads are paused, not reloading fluentd")
+		s.Status = StatusReloadPaused
+		return s, nil
+	}
+	if config.reloadsDeferred() {
+		log.Printf("Outside of the reload window, deferring the reload to %v", config.ReloadWindow.NextOpen(time.Now()))
+		s.Status = StatusReloadDeferred
+		return s, nil
+	}
+	s.ExpectedSerial = leaf.SerialNumber.String()
+	// the canary is verified against the certificate in the secret
+	err = reloadTargets(ctx, app, config, ReasonCertRotation, stale, &metav1.Time{Time: leaf.NotAfter}, &s)
+	if err != nil {
+		if config.RecordHistory {
+			app.recordHistory(ctx, certificate, corev1.EventTypeWarning, reloadFailedReason(err), err.Error())
+		}
+
+		return s, err
+	}
+	s.Status = StatusReloaded
+	if config.RecordHistory {
+		app.recordHistory(ctx, certificate, corev1.EventTypeNormal, reasonReloaded,
+			fmt.Sprintf("Reloaded %d fluentd targets after secret %s changed", len(stale), certificate.Spec.SecretName))
+	}
+
+	// the annotation remembers the secret revision the pods were reloaded with
+	if err := app.annotatePods(ctx, expected, reloadedPods(stale, s.Actions)); err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
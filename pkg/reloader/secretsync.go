@@ -0,0 +1,175 @@
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SecretManager values name the controller writing the TLS secret
+const (
+	SecretManagerExternalSecrets = "external-secrets"
+	SecretManagerSealedSecrets   = "sealed-secrets"
+)
+
+// secretSyncPoll is the interval the sync status is checked at while waiting
+const secretSyncPoll = 5 * time.Second
+
+// SecretSyncReport is the sync status of the resource the TLS secret is generated from
+type SecretSyncReport struct {
+	Kind        string     `json:"kind"`
+	Name        string     `json:"name"`
+	Synced      bool       `json:"synced"`
+	Reason      string     `json:"reason,omitempty"`
+	Message     string     `json:"message,omitempty"`
+	RefreshTime *time.Time `json:"refreshTime,omitempty"`
+}
+
+// syncedResource is the part of an ExternalSecret or SealedSecret the sync
+// status is read from, decoded as JSON as their types are not vendored
+type syncedResource struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Target struct {
+			Name string `json:"name"`
+		} `json:"target"`
+	} `json:"spec"`
+	Status struct {
+		RefreshTime *metav1.Time       `json:"refreshTime"`
+		Conditions  []metav1.Condition `json:"conditions"`
+	} `json:"status"`
+}
+
+// waitForSecretSync waits up to SecretSyncTimeout for the ExternalSecret or
+// SealedSecret to sync the TLS secret, so a secret that is still being written
+// is not compared. Once the wait is over the secret is compared as it is.
+func (a app) waitForSecretSync(ctx context.Context, cfg Config) (*SecretSyncReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.SecretSyncTimeout)
+	defer cancel()
+
+	for {
+		sync, err := a.secretSync(ctx, cfg)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Secret %s is not synced after %v: %v", a.secretName, cfg.SecretSyncTimeout, err)
+				return &SecretSyncReport{Name: a.secretName, Message: err.Error()}, nil
+			}
+			return nil, err
+		}
+		if sync.Synced {
+			return sync, nil
+		}
+
+		log.Printf("%s %s has not synced secret %s yet: %s", sync.Kind, sync.Name, a.secretName, sync.Message)
+		select {
+		case <-ctx.Done():
+			log.Printf("Secret %s is not synced after %v, comparing it as it is", a.secretName, cfg.SecretSyncTimeout)
+			return sync, nil
+		case <-time.After(secretSyncPoll):
+		}
+	}
+}
+
+// secretSync reads the sync status of the resource generating the secret and
+// whether the secret exists
+func (a app) secretSync(ctx context.Context, cfg Config) (*SecretSyncReport, error) {
+	var resource syncedResource
+	var kind, condition string
+	var err error
+	switch cfg.SecretManager {
+	case SecretManagerExternalSecrets:
+		kind, condition = "ExternalSecret", "Ready"
+		resource, err = a.externalSecret(ctx)
+	case SecretManagerSealedSecrets:
+		kind, condition = "SealedSecret", "Synced"
+		resource, err = a.sealedSecret(ctx)
+	default:
+		return nil, fmt.Errorf("unknown secret manager %s", cfg.SecretManager)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sync := &SecretSyncReport{Kind: kind, Name: resource.Name}
+	if resource.Status.RefreshTime != nil {
+		refreshed := resource.Status.RefreshTime.Time
+		sync.RefreshTime = &refreshed
+	}
+	if c := findCondition(resource.Status.Conditions, condition); c != nil {
+		sync.Synced = c.Status == metav1.ConditionTrue
+		sync.Reason, sync.Message = c.Reason, c.Message
+	}
+	if !sync.Synced {
+		return sync, nil
+	}
+
+	_, err = a.client.CoreV1().Secrets(a.certNamespace).Get(ctx, a.secretName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		sync.Synced, sync.Message = false, "secret does not exist yet"
+	case err != nil:
+		return nil, fmt.Errorf("failed to get secret %s: %w", a.secretName, err)
+	}
+
+	return sync, nil
+}
+
+// externalSecret returns the ExternalSecret targeting the secret, its target
+// defaults to the name of the ExternalSecret
+func (a app) externalSecret(ctx context.Context) (syncedResource, error) {
+	uri := fmt.Sprintf("/apis/external-secrets.io/v1beta1/namespaces/%s/externalsecrets", a.certNamespace)
+	b, err := a.client.Discovery().RESTClient().Get().AbsPath(uri).DoRaw(ctx)
+	if err != nil {
+		return syncedResource{}, fmt.Errorf("failed to list externalsecrets: %w", err)
+	}
+	list := struct {
+		Items []syncedResource `json:"items"`
+	}{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return syncedResource{}, fmt.Errorf("failed to parse externalsecrets: %w", err)
+	}
+
+	for _, es := range list.Items {
+		target := es.Spec.Target.Name
+		if target == "" {
+			target = es.Name
+		}
+		if target == a.secretName {
+			return es, nil
+		}
+	}
+
+	return syncedResource{}, terminal(fmt.Errorf("no externalsecret in namespace %s targets secret %s", a.certNamespace, a.secretName),
+		"check FLUENTD_SECRET_NAME and FLUENTD_SECRET_MANAGER")
+}
+
+// sealedSecret returns the SealedSecret of the secret, which has the same name
+func (a app) sealedSecret(ctx context.Context) (syncedResource, error) {
+	uri := fmt.Sprintf("/apis/bitnami.com/v1alpha1/namespaces/%s/sealedsecrets/%s", a.certNamespace, a.secretName)
+	b, err := a.client.Discovery().RESTClient().Get().AbsPath(uri).DoRaw(ctx)
+	if err != nil {
+		return syncedResource{}, fmt.Errorf("failed to get sealedsecret %s: %w", a.secretName, err)
+	}
+
+	resource := syncedResource{}
+	if err := json.Unmarshal(b, &resource); err != nil {
+		return syncedResource{}, fmt.Errorf("failed to parse sealedsecret %s: %w", a.secretName, err)
+	}
+
+	return resource, nil
+}
+
+func findCondition(conditions []metav1.Condition, conditionType string) *metav1.Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+
+	return nil
+}
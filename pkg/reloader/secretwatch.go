@@ -0,0 +1,83 @@
+package reloader
+
+import (
+	"context"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+const maxSecretWatchBackoff = time.Minute
+
+// watchSecrets calls onChange whenever a TLS secret in the namespace changes
+// until ctx is done. The watch resumes from the last seen resource version,
+// including the ones of bookmarks, when the API server closes it or restarts.
+// When the resource version expired the secrets are listed again and onChange
+// is called, as events may have been missed in between.
+func watchSecrets(ctx context.Context, client kubernetes.Interface, namespace string, onChange func()) {
+	fieldSelector := "type=" + string(corev1.SecretTypeTLS)
+	backoff := time.Second
+	for ctx.Err() == nil {
+		// only the resource version of the list is needed
+		list, err := client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{FieldSelector: fieldSelector, Limit: 1})
+		if err != nil {
+			log.Printf("Failed to list secrets in %s, retrying in %v: %v", namespace, backoff, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > maxSecretWatchBackoff {
+				backoff = maxSecretWatchBackoff
+			}
+			continue
+		}
+		backoff = time.Second
+		onChange()
+
+		watcher, err := watchtools.NewRetryWatcher(list.ResourceVersion, &cache.ListWatch{
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = fieldSelector
+				return client.CoreV1().Secrets(namespace).Watch(ctx, options)
+			},
+		})
+		if err != nil {
+			log.Printf("Failed to watch secrets in %s: %v", namespace, err)
+			continue
+		}
+
+		consumeSecretEvents(ctx, watcher, namespace, onChange)
+		watcher.Stop()
+	}
+}
+
+// consumeSecretEvents returns when ctx is done or the watch cannot resume
+func consumeSecretEvents(ctx context.Context, watcher *watchtools.RetryWatcher, namespace string, onChange func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-watcher.Done():
+			log.Printf("Watch of secrets in %s stopped, listing them again", namespace)
+			return
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+
+			if event.Type == watch.Error {
+				log.Printf("Watch of secrets in %s failed, listing them again: %v", namespace, apierrors.FromObject(event.Object))
+				return
+			}
+			onChange()
+		}
+	}
+}
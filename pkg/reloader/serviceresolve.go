@@ -0,0 +1,87 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// clusterService returns the namespace and name of the Service a cluster
+// hostname like fluentd, fluentd.logging or fluentd.logging.svc names, ok is
+// false for other hostnames
+func clusterService(host, namespace string) (string, string, bool) {
+	parts := strings.Split(host, ".")
+	switch {
+	case len(parts) == 1:
+		return namespace, parts[0], true
+	case len(parts) == 2 || (len(parts) > 2 && parts[2] == "svc"):
+		return parts[1], parts[0], true
+	}
+
+	return "", "", false
+}
+
+// resolveServiceURL resolves the service URL before it is probed and logs the
+// addresses and the Service behind it, so a typo fails with a targeted error
+// instead of an opaque dial error
+func resolveServiceURL(ctx context.Context, cfg Config, serviceURL string) error {
+	host := serviceURL
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) != nil || proxied(cfg, host) {
+		return nil
+	}
+
+	addrs, resolveErr := net.DefaultResolver.LookupHost(ctx, host)
+	namespace, name, ok := clusterService(host, cfg.Namespace)
+	if !ok || cfg.Client == nil {
+		if resolveErr != nil {
+			return fmt.Errorf("failed to resolve %s: %w", host, resolveErr)
+		}
+		log.Printf("%s resolves to %v", host, addrs)
+		return nil
+	}
+
+	svc, err := cfg.Client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if resolveErr == nil {
+		// external hostnames can look like cluster ones, so the service is only informational here
+		if err == nil {
+			log.Printf("%s resolves to %v, service %s/%s has cluster IP %s", host, addrs, namespace, name, svc.Spec.ClusterIP)
+		} else {
+			log.Printf("%s resolves to %v", host, addrs)
+		}
+		return nil
+	}
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return terminal(fmt.Errorf("failed to resolve %s, service %s not found in namespace %s", host, name, namespace),
+			"check FLUENTD_SERVICE_URL")
+	case err == nil:
+		return fmt.Errorf("failed to resolve %s although service %s/%s with cluster IP %s exists: %w",
+			host, namespace, name, svc.Spec.ClusterIP, resolveErr)
+	}
+
+	return fmt.Errorf("failed to resolve %s: %w", host, resolveErr)
+}
+
+// proxied reports whether the probe of host goes through a proxy, which
+// resolves the hostname itself
+func proxied(cfg Config, host string) bool {
+	// the setting is validated by Config.Validate
+	selectProxy, _ := proxyFunc(cfg.ProbeProxy)
+	if selectProxy == nil {
+		return false
+	}
+
+	proxyURL, err := selectProxy(&http.Request{URL: &url.URL{Scheme: "https", Host: host}})
+	return err == nil && proxyURL != nil
+}
@@ -0,0 +1,65 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// SelectorFromServiceURL derives the Service from the host of the service URL
+const SelectorFromServiceURL = "auto"
+
+// serviceForSelector returns the namespace and name of the Service whose
+// selector finds the fluentd pods. With SelectorFromServiceURL a cluster
+// hostname like fluentd.logging.svc names the Service and its namespace,
+// other hostnames only name the Service.
+func serviceForSelector(cfg Config) (string, string) {
+	if cfg.SelectorFromService != SelectorFromServiceURL {
+		return splitCertName(cfg.SelectorFromService, cfg.Namespace)
+	}
+
+	host := cfg.ServiceURL
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if namespace, name, ok := clusterService(host, cfg.Namespace); ok {
+		return namespace, name
+	}
+
+	return cfg.Namespace, strings.Split(host, ".")[0]
+}
+
+// serviceSelector returns the pod selector of the Service, narrowed down by
+// the configured selector when set, so the reloader follows selector changes
+// of the Service instead of duplicating it
+func serviceSelector(ctx context.Context, cfg Config) (string, error) {
+	namespace, name := serviceForSelector(cfg)
+	if namespace != cfg.Namespace {
+		return "", terminal(fmt.Errorf("service %s is in namespace %s, not in the fluentd namespace %s", name, namespace, cfg.Namespace),
+			"set FLUENTD_SELECTOR_FROM_SERVICE to a service in FLUENTD_NAMESPACE")
+	}
+
+	svc, err := cfg.Client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get service %s: %w", name, err)
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return "", terminal(fmt.Errorf("service %s has no selector", name), "set FLUENTD_SELECTOR instead")
+	}
+
+	selector := labels.SelectorFromSet(svc.Spec.Selector).String()
+	if cfg.Selector != "" {
+		selector += "," + cfg.Selector
+	}
+
+	return selector, nil
+}
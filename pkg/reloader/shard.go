@@ -0,0 +1,39 @@
+package reloader
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// skipOtherShard is the reason pods reloaded by another replica are skipped
+const skipOtherShard = "other-shard"
+
+// shardOf hashes the pod name into one of the shards
+func shardOf(podName string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(podName))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// inShard reports whether this replica reloads the pod, every pod belongs to
+// the only shard when sharding is disabled
+func (a app) inShard(podName string) bool {
+	return a.shards <= 1 || shardOf(podName, a.shards) == a.shardIndex
+}
+
+// ShardOrdinal returns the StatefulSet ordinal of a pod name like fluentd-reloader-2
+func ShardOrdinal(podName string) (int, error) {
+	i := strings.LastIndex(podName, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("%s is not a statefulset pod name", podName)
+	}
+
+	ordinal, err := strconv.Atoi(podName[i+1:])
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a statefulset pod name: %w", podName, err)
+	}
+
+	return ordinal, nil
+}
@@ -0,0 +1,64 @@
+package reloader
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestShardOrdinal(t *testing.T) {
+	tests := []struct {
+		podName string
+		want    int
+		wantErr bool
+	}{
+		{podName: "fluentd-reloader-0", want: 0},
+		{podName: "fluentd-reloader-12", want: 12},
+		{podName: "fluentd-reloader-7d9f8b6c5-x2x4z", wantErr: true},
+		{podName: "reloader", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.podName, func(t *testing.T) {
+			got, err := ShardOrdinal(tt.podName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ShardOrdinal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ShardOrdinal() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInShard(t *testing.T) {
+	tests := []struct {
+		name   string
+		shards int
+	}{
+		{name: "disabled", shards: 0},
+		{name: "single shard", shards: 1},
+		{name: "three shards", shards: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replicas := tt.shards
+			if replicas < 1 {
+				replicas = 1
+			}
+
+			for i := 0; i < 50; i++ {
+				pod := fmt.Sprintf("fluentd-%d", i)
+				owners := 0
+				for index := 0; index < replicas; index++ {
+					if (app{shards: tt.shards, shardIndex: index}).inShard(pod) {
+						owners++
+					}
+				}
+				if owners != 1 {
+					t.Errorf("%s is reloaded by %d of %d replicas, want exactly one", pod, owners, replicas)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package reloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// sidecarDebounce collapses the burst of events of a secret volume update
+const sidecarDebounce = time.Second
+
+// SidecarConfig configures the reloader running as a sidecar of fluentd,
+// reloading it when the mounted certificate files change
+type SidecarConfig struct {
+	// CertDir is the directory the certificate secret is mounted to
+	CertDir string
+	// Files are the watched files in CertDir, defaults to tls.crt and tls.key
+	Files []string
+	// RPCAddress is the fluentd RPC endpoint, defaults to localhost:24444
+	RPCAddress string
+	// RPCSocket is a unix socket the RPC requests are sent to instead of RPCAddress
+	RPCSocket  string
+	RPCMethod  string
+	RPCTimeout time.Duration
+	HTTPClient HTTPClient
+}
+
+// WatchCertificates reloads fluentd whenever the content of the watched files
+// changes, it needs no Kubernetes API access and returns when ctx is done
+func WatchCertificates(ctx context.Context, cfg SidecarConfig) error {
+	if cfg.CertDir == "" {
+		return fmt.Errorf("certificate directory is required")
+	}
+	if len(cfg.Files) == 0 {
+		cfg.Files = []string{"tls.crt", "tls.key"}
+	}
+	if cfg.RPCAddress == "" {
+		cfg.RPCAddress = "localhost:24444"
+	}
+	if cfg.RPCTimeout == 0 {
+		cfg.RPCTimeout = 5 * time.Second
+	}
+	if cfg.RPCSocket != "" && cfg.HTTPClient == nil {
+		// the RPC address only remains as the Host header
+		socket := cfg.RPCSocket
+		cfg.HTTPClient = &http.Client{
+			Timeout: cfg.RPCTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+	}
+	reloader := newFluentdRPCReloader(app{}, Config{
+		RPCMethod:  cfg.RPCMethod,
+		RPCTimeout: cfg.RPCTimeout,
+		HTTPClient: cfg.HTTPClient,
+	}.withDefaults())
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	// secret volumes swap a ..data symlink, so the directory is watched instead of the files
+	if err := watcher.Add(cfg.CertDir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", cfg.CertDir, err)
+	}
+
+	last, err := hashFiles(cfg.CertDir, cfg.Files)
+	if err != nil {
+		return err
+	}
+	log.Printf("Watching %v in %s", cfg.Files, cfg.CertDir)
+
+	debounce := time.NewTimer(sidecarDebounce)
+	debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			log.Printf("Watch error: %v", err)
+		case <-watcher.Events:
+			debounce.Reset(sidecarDebounce)
+		case <-debounce.C:
+			current, err := hashFiles(cfg.CertDir, cfg.Files)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if current == last {
+				continue
+			}
+
+			log.Println("Certificate files changed, reloading fluentd")
+			if err := reloader.Reload(ctx, target{host: cfg.RPCAddress}); err != nil {
+				log.Printf("Failed to reload fluentd: %v", err)
+				continue
+			}
+			last = current
+		}
+	}
+}
+
+// hashFiles returns a hash over the content of the files in dir
+func hashFiles(dir string, files []string) (string, error) {
+	h := sha256.New()
+	for _, name := range files {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		h.Write(b)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,44 @@
+package reloader
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// unreachable reports whether err is a failed connection attempt, e.g.
+// connection refused or no route to host, which happens when the listed IP of
+// a rescheduled pod is stale
+func unreachable(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// movedTarget looks up the pod of an unreachable target again and returns it
+// with its current IP, ok is false when the IP did not change
+func (r fluentdRPCReloader) movedTarget(ctx context.Context, t target, err error) (target, bool) {
+	if r.app == nil || t.pod == nil || !unreachable(err) {
+		return target{}, false
+	}
+
+	host, port, splitErr := net.SplitHostPort(t.host)
+	if splitErr != nil {
+		return target{}, false
+	}
+	pod, getErr := r.app.client.CoreV1().Pods(t.pod.Namespace).Get(ctx, t.pod.Name, metav1.GetOptions{})
+	if getErr != nil {
+		log.Printf("Failed to look up unreachable pod %s again: %v", t, getErr)
+		return target{}, false
+	}
+
+	ip := podIP(*pod, r.ipFamily)
+	if ip == "" || ip == host {
+		return target{}, false
+	}
+
+	log.Printf("Pod %s moved from %s to %s, retrying: %v", t, host, ip, err)
+	return target{host: net.JoinHostPort(ip, port), pod: pod}, true
+}
@@ -0,0 +1,53 @@
+package reloader
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ordinal returns the StatefulSet ordinal of the target's pod
+func ordinal(t target) (int, bool) {
+	if t.pod == nil {
+		return 0, false
+	}
+
+	i := strings.LastIndex(t.pod.Name, "-")
+	if i < 0 {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(t.pod.Name[i+1:])
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// orderTargets sorts the targets by descending ordinal like a StatefulSet rolling
+// update and holds back the pods below the partition
+func orderTargets(targets []target, partition int) (ordered, held []target) {
+	for _, t := range targets {
+		n, ok := ordinal(t)
+		if !ok {
+			log.Printf("Pod %s has no StatefulSet ordinal, reloading it last", t)
+			n = -1
+		}
+
+		if ok && n < partition {
+			held = append(held, t)
+			continue
+		}
+		ordered = append(ordered, t)
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, _ := ordinal(ordered[i])
+		b, _ := ordinal(ordered[j])
+		return a > b
+	})
+
+	return ordered, held
+}
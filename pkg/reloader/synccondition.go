@@ -0,0 +1,126 @@
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// certInSyncAnnotation holds True, False or Unknown for kubectl columns
+	certInSyncAnnotation = "fluentd-reloader.io/cert-in-sync"
+	// certSyncConditionAnnotation holds the syncCondition as JSON
+	certSyncConditionAnnotation = "fluentd-reloader.io/cert-sync-condition"
+)
+
+// syncCondition summarizes the last check of a target like a status condition
+type syncCondition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason"`
+	Message            string    `json:"message,omitempty"`
+	LastProbeTime      time.Time `json:"lastProbeTime"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// newSyncCondition returns the condition of a check's report
+func newSyncCondition(s TargetReport, err error) syncCondition {
+	c := syncCondition{Type: "CertInSync", LastProbeTime: time.Now().UTC().Truncate(time.Second)}
+	switch {
+	case err != nil:
+		c.Status, c.Reason, c.Message = string(metav1.ConditionUnknown), "CheckFailed", err.Error()
+	case s.Status == StatusInSync:
+		c.Status, c.Reason = string(metav1.ConditionTrue), "InSync"
+	case s.Status == StatusReloaded:
+		c.Status, c.Reason = string(metav1.ConditionTrue), "Reloaded"
+		c.Message = fmt.Sprintf("reloaded %d pods", len(s.Actions))
+	case s.Status == StatusRenewalPending:
+		c.Status, c.Reason = string(metav1.ConditionFalse), "RenewalPending"
+		c.Message = fmt.Sprintf("serving a certificate expiring %v", s.ServedNotAfter)
+	case s.Status == StatusReloadPaused:
+		c.Status, c.Reason = string(metav1.ConditionFalse), "ReloadPaused"
+		c.Message = fmt.Sprintf("serving a certificate expiring %v", s.ServedNotAfter)
+	case s.Status == StatusReloadDeferred:
+		c.Status, c.Reason = string(metav1.ConditionFalse), "ReloadDeferred"
+		c.Message = fmt.Sprintf("serving a certificate expiring %v", s.ServedNotAfter)
+	case s.Status == StatusSecretPropagating:
+		c.Status, c.Reason = string(metav1.ConditionFalse), "SecretPropagating"
+		c.Message = fmt.Sprintf("%d pods have not the renewed certificate mounted yet", len(s.UnpropagatedPods))
+	default:
+		c.Status, c.Reason = string(metav1.ConditionUnknown), s.Status
+	}
+
+	return c
+}
+
+// recordSyncCondition annotates the fluentd StatefulSet with the outcome of the
+// check, keeping the transition time while the status does not change
+func (a app) recordSyncCondition(ctx context.Context, s TargetReport, checkErr error) error {
+	sts, err := a.client.AppsV1().StatefulSets(a.namespace).Get(ctx, a.statefulSet, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get statefulset %s: %w", a.statefulSet, err)
+	}
+
+	condition := newSyncCondition(s, checkErr)
+	condition.LastTransitionTime = condition.LastProbeTime
+	previous := syncCondition{}
+	if err := json.Unmarshal([]byte(sts.Annotations[certSyncConditionAnnotation]), &previous); err == nil && previous.Status == condition.Status {
+		condition.LastTransitionTime = previous.LastTransitionTime
+	}
+
+	b, err := json.Marshal(condition)
+	if err != nil {
+		return fmt.Errorf("failed to encode sync condition: %w", err)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				certInSyncAnnotation:        condition.Status,
+				certSyncConditionAnnotation: string(b),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build annotation patch: %w", err)
+	}
+
+	_, err = a.client.AppsV1().StatefulSets(a.namespace).Patch(ctx, a.statefulSet, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate statefulset %s: %w", a.statefulSet, err)
+	}
+
+	return nil
+}
+
+// checkAndRecord checks the target and records the outcome on its StatefulSet
+// and its FluentdReload
+func checkAndRecord(ctx context.Context, app app, config Config) (TargetReport, error) {
+	if config.StatusResource {
+		// a deleted FluentdReload clears the recorded state, the next check creates it again
+		fr, err := app.getFluentdReload(ctx, fluentdReloadName(config))
+		if err != nil {
+			log.Println(err)
+		} else if fr != nil && fr.DeletionTimestamp != nil {
+			return TargetReport{Status: StatusCleanedUp}, app.finalizeFluentdReload(ctx, *fr)
+		}
+	}
+
+	s, err := run(ctx, app, config)
+	if config.SyncCondition && config.StatefulSetName != "" {
+		if recordErr := app.recordSyncCondition(ctx, s, err); recordErr != nil {
+			log.Println(recordErr)
+		}
+	}
+	if config.StatusResource {
+		if recordErr := app.recordFluentdReload(ctx, config, s, err); recordErr != nil {
+			log.Println(recordErr)
+		}
+	}
+
+	return s, err
+}
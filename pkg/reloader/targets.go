@@ -0,0 +1,190 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// targetsConfigMapKey is the key of the targets list in the targets ConfigMap
+const targetsConfigMapKey = "targets.yaml"
+
+// targetSpec is a single entry of the targets ConfigMap, empty fields
+// fall back to the values of the base config
+type targetSpec struct {
+	Name       string `json:"name"`
+	CertName   string `json:"certName"`
+	SecretName string `json:"secretName"`
+	CertFile   string `json:"certFile"`
+	ServiceURL string `json:"serviceURL"`
+	// ServiceURLs are probed in addition to ServiceURL
+	ServiceURLs     []string `json:"serviceURLs"`
+	Selector        string   `json:"selector"`
+	ReleaseName     string   `json:"releaseName"`
+	StatefulSetName string   `json:"statefulSetName"`
+	// DependentCerts are renewed together with the certificate of the target
+	DependentCerts []string  `json:"dependentCerts"`
+	PreReloadHook  *hookSpec `json:"preReloadHook"`
+	PostReloadHook *hookSpec `json:"postReloadHook"`
+	// Profiles replace the profiles enabled in the base config
+	Profiles []string `json:"profiles"`
+}
+
+// hookSpec is a reload hook of a target, the timeout is a duration like 30s
+type hookSpec struct {
+	URL           string   `json:"url"`
+	Command       []string `json:"command"`
+	Timeout       string   `json:"timeout"`
+	FailurePolicy string   `json:"failurePolicy"`
+}
+
+// reloadHook converts the spec, a nil spec keeps the hook of the base config
+func (s *hookSpec) reloadHook(base *ReloadHook) (*ReloadHook, error) {
+	if s == nil {
+		return base, nil
+	}
+
+	hook := &ReloadHook{URL: s.URL, Command: s.Command, FailurePolicy: s.FailurePolicy}
+	if s.Timeout != "" {
+		timeout, err := time.ParseDuration(s.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hook timeout %q: %w", s.Timeout, err)
+		}
+		hook.Timeout = timeout
+	}
+	if err := hook.Validate(); err != nil {
+		return nil, err
+	}
+
+	return hook.withDefaults(), nil
+}
+
+// loadTargets returns the config of every target to check. Without a targets
+// ConfigMap the config itself is the only target, unless its cert name is a
+// pattern matching several Certificates. The ConfigMap is read on
+// every run so new targets are picked up without restarting the reloader,
+// the selectors of Services are read on every run as well.
+func loadTargets(ctx context.Context, cfg Config) ([]Config, error) {
+	targets, err := loadTargetConfigs(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, target := range targets {
+		if target.SelectorFromService == "" {
+			continue
+		}
+		selector, err := serviceSelector(ctx, target)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", target.Name, err)
+		}
+		// resolved once so loading the targets again keeps the selector
+		targets[i].Selector, targets[i].SelectorFromService = selector, ""
+	}
+
+	return targets, nil
+}
+
+// validateTarget validates the config of a target merged from the targets
+// ConfigMap and its profile, the base config is validated on its own
+func validateTarget(target Config) error {
+	if target.TargetsConfigMap == "" {
+		return nil
+	}
+
+	target.TargetsConfigMap = ""
+	if err := target.Validate(); err != nil {
+		return terminal(fmt.Errorf("target %s is invalid: %w", target.Name, err), "fix it in the targets configmap")
+	}
+
+	return nil
+}
+
+// loadTargetConfigs reads the targets from the targets ConfigMap or the
+// Certificates matching the cert name pattern
+func loadTargetConfigs(ctx context.Context, cfg Config) ([]Config, error) {
+	if cfg.TargetsConfigMap == "" {
+		if IsCertNamePattern(cfg.CertName) {
+			return certPatternTargets(ctx, cfg)
+		}
+		return []Config{cfg}, nil
+	}
+
+	cm, err := cfg.Client.CoreV1().ConfigMaps(cfg.Namespace).Get(ctx, cfg.TargetsConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get targets configmap: %w", err)
+	}
+
+	profiles, err := parseProfiles(cm.Data, cfg.TargetsConfigMap)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cm.Data[targetsConfigMapKey]; !ok {
+		// a configmap with only profiles applies them to the base config
+		return expandProfiles([]Config{cfg}, profiles, cfg.TargetsConfigMap)
+	}
+
+	specs := []targetSpec{}
+	if err := yaml.Unmarshal([]byte(cm.Data[targetsConfigMapKey]), &specs); err != nil {
+		return nil, terminal(fmt.Errorf("failed to parse %s of configmap %s: %w", targetsConfigMapKey, cfg.TargetsConfigMap, err), "fix the targets list")
+	}
+
+	targets := make([]Config, 0, len(specs))
+	for i, spec := range specs {
+		target := cfg
+		target.Name = spec.Name
+		if spec.CertName != "" {
+			target.CertNamespace, target.CertName = splitCertName(spec.CertName, cfg.CertNamespace)
+		}
+		if spec.SecretName != "" {
+			target.SecretName = spec.SecretName
+		}
+		if spec.CertFile != "" {
+			target.CertFile = spec.CertFile
+		}
+		if spec.ServiceURL != "" {
+			target.ServiceURL = spec.ServiceURL
+			target.ServiceURLs = spec.ServiceURLs
+		}
+		if spec.ReleaseName != "" {
+			target.Selector = releaseSelector(spec.ReleaseName, spec.Selector)
+		} else if spec.Selector != "" {
+			target.Selector = spec.Selector
+		}
+		if spec.StatefulSetName != "" {
+			target.StatefulSetName = spec.StatefulSetName
+		}
+		if spec.DependentCerts != nil {
+			target.DependentCerts = spec.DependentCerts
+		}
+		if spec.Profiles != nil {
+			target.Profiles = spec.Profiles
+		}
+		if target.PreReloadHook, err = spec.PreReloadHook.reloadHook(cfg.PreReloadHook); err != nil {
+			return nil, terminal(fmt.Errorf("pre-reload hook of target %d in configmap %s: %w", i, cfg.TargetsConfigMap, err), "fix the targets list")
+		}
+		if target.PostReloadHook, err = spec.PostReloadHook.reloadHook(cfg.PostReloadHook); err != nil {
+			return nil, terminal(fmt.Errorf("post-reload hook of target %d in configmap %s: %w", i, cfg.TargetsConfigMap, err), "fix the targets list")
+		}
+
+		if target.Name == "" {
+			target.Name = target.CertName
+		}
+		if target.Name == "" {
+			target.Name = target.SecretName
+		}
+		if target.Name == "" {
+			target.Name = target.CertFile
+		}
+		if (target.CertName == "" && target.SecretName == "" && target.CertFile == "" && target.CertSource == nil) || (target.ServiceURL == "" && target.CheckMode != CheckModeSecretRevision) {
+			return nil, fmt.Errorf("target %d in configmap %s needs a certName, secretName or certFile and a serviceURL", i, cfg.TargetsConfigMap)
+		}
+
+		targets = append(targets, target)
+	}
+
+	return expandProfiles(targets, profiles, cfg.TargetsConfigMap)
+}
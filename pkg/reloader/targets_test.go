@@ -0,0 +1,64 @@
+package reloader
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target Config
+		// wantErr is part of the error, empty when the target is valid
+		wantErr string
+	}{
+		{
+			name:   "base config is validated on its own",
+			target: Config{Name: "fluentd"},
+		},
+		{
+			name: "valid target",
+			target: Config{
+				Name: "fluentd", TargetsConfigMap: "targets", Client: fake.NewSimpleClientset(),
+				Namespace: "logging", ServiceURL: "fluentd.logging.svc", CertName: "fluentd-tls",
+			},
+		},
+		{
+			name: "target without service url",
+			target: Config{
+				Name: "fluentd", TargetsConfigMap: "targets", Client: fake.NewSimpleClientset(),
+				Namespace: "logging", CertName: "fluentd-tls",
+			},
+			wantErr: "target fluentd is invalid",
+		},
+		{
+			name: "profile enabling an invalid option",
+			target: Config{
+				Name: "fluentd", TargetsConfigMap: "targets", Client: fake.NewSimpleClientset(),
+				Namespace: "logging", ServiceURL: "fluentd.logging.svc", CertName: "fluentd-tls",
+				TriggerRenewal: true,
+			},
+			wantErr: "expiry warning",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTarget(tt.target)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateTarget() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateTarget() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if ErrorClass(err) != ErrorClassTerminal {
+				t.Errorf("ErrorClass() = %s, want %s", ErrorClass(err), ErrorClassTerminal)
+			}
+		})
+	}
+}
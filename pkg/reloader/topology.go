@@ -0,0 +1,122 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// Reasons pods are skipped by the pod filters
+const (
+	skipFieldSelector = "field-selector"
+	skipOtherNode     = "other-node"
+	skipOtherZone     = "other-zone"
+)
+
+// zoneLabel is the well-known topology label of the node's zone
+const zoneLabel = "topology.kubernetes.io/zone"
+
+// podFieldSelectors lists the pod fields a field selector may use, the same
+// fields the API server supports for pods
+var podFieldSelectors = map[string]func(corev1.Pod) string{
+	"metadata.name":           func(p corev1.Pod) string { return p.Name },
+	"metadata.namespace":      func(p corev1.Pod) string { return p.Namespace },
+	"spec.nodeName":           func(p corev1.Pod) string { return p.Spec.NodeName },
+	"spec.restartPolicy":      func(p corev1.Pod) string { return string(p.Spec.RestartPolicy) },
+	"spec.schedulerName":      func(p corev1.Pod) string { return p.Spec.SchedulerName },
+	"spec.serviceAccountName": func(p corev1.Pod) string { return p.Spec.ServiceAccountName },
+	"status.phase":            func(p corev1.Pod) string { return string(p.Status.Phase) },
+	"status.podIP":            func(p corev1.Pod) string { return p.Status.PodIP },
+	"status.nominatedNodeName": func(p corev1.Pod) string {
+		return p.Status.NominatedNodeName
+	},
+}
+
+// parsePodFieldSelector parses a field selector on the supported pod fields
+func parsePodFieldSelector(selector string) (fields.Selector, error) {
+	parsed, err := fields.ParseSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field selector %s: %w", selector, err)
+	}
+	for _, r := range parsed.Requirements() {
+		if _, ok := podFieldSelectors[r.Field]; !ok {
+			return nil, fmt.Errorf("field selector %s uses the unsupported pod field %s", selector, r.Field)
+		}
+	}
+
+	return parsed, nil
+}
+
+// podFilter decides which pods this reloader is responsible for by the field
+// selector, the node and the zone, nodes are looked up once per filter
+type podFilter struct {
+	app    app
+	fields fields.Selector
+	zones  map[string]string
+}
+
+// podFilter returns the filter of the configured field selector, node and zone
+func (a app) podFilter() (*podFilter, error) {
+	f := &podFilter{app: a, zones: map[string]string{}}
+	if a.fieldSelector != "" {
+		var err error
+		if f.fields, err = parsePodFieldSelector(a.fieldSelector); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// skipReason returns why the pod is filtered out, empty when it is not
+func (f *podFilter) skipReason(ctx context.Context, pod corev1.Pod) (string, error) {
+	if f.fields != nil && !f.fields.Matches(podFields(pod)) {
+		return skipFieldSelector, nil
+	}
+	if f.app.nodeName != "" && pod.Spec.NodeName != f.app.nodeName {
+		return skipOtherNode, nil
+	}
+	if f.app.zone == "" {
+		return "", nil
+	}
+
+	zone, err := f.nodeZone(ctx, pod.Spec.NodeName)
+	if err != nil {
+		return "", err
+	}
+	if zone != f.app.zone {
+		return skipOtherZone, nil
+	}
+
+	return "", nil
+}
+
+func (f *podFilter) nodeZone(ctx context.Context, nodeName string) (string, error) {
+	if nodeName == "" {
+		// not scheduled yet
+		return "", nil
+	}
+	if zone, ok := f.zones[nodeName]; ok {
+		return zone, nil
+	}
+
+	node, err := f.app.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get node %s: %w", nodeName, err)
+	}
+	f.zones[nodeName] = node.Labels[zoneLabel]
+
+	return f.zones[nodeName], nil
+}
+
+func podFields(pod corev1.Pod) fields.Set {
+	set := fields.Set{}
+	for field, value := range podFieldSelectors {
+		set[field] = value(pod)
+	}
+
+	return set
+}
@@ -0,0 +1,54 @@
+package reloader
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"text/template"
+)
+
+// reloadURLData is what a reload URL template is evaluated with
+type reloadURLData struct {
+	// Host is the host:port of the target
+	Host        string
+	PodName     string
+	PodIP       string
+	Port        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+func parseReloadURLTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("reload-url").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reload url template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// reloadURL evaluates the template for the target
+func reloadURL(tmpl *template.Template, t target) (string, error) {
+	host, port, err := net.SplitHostPort(t.host)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse host of %s: %w", t, err)
+	}
+
+	data := reloadURLData{Host: t.host, PodIP: host, Port: port}
+	if t.pod != nil {
+		data.PodName = t.pod.Name
+		if net.ParseIP(host) == nil {
+			// reloading via pod DNS names
+			data.PodIP = t.pod.Status.PodIP
+		}
+		data.Labels = t.pod.Labels
+		data.Annotations = t.pod.Annotations
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to evaluate reload url template for %s: %w", t, err)
+	}
+
+	return b.String(), nil
+}
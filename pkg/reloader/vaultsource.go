@@ -0,0 +1,278 @@
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Vault auth methods of the VaultSource
+const (
+	VaultAuthToken      = "token"
+	VaultAuthAppRole    = "approle"
+	VaultAuthKubernetes = "kubernetes"
+)
+
+// vaultServiceAccountToken is the token the kubernetes auth method logs in with
+const vaultServiceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultSource reads the expected certificate from Vault, for clusters where
+// a Vault agent injects the certificate into fluentd instead of cert-manager.
+// Path is read with the Vault HTTP API, e.g. pki/cert/<serial> of a PKI
+// mount or secret/data/fluentd-tls of a KV mount.
+type VaultSource struct {
+	Address string
+	Path    string
+	// Field holds the PEM certificate, certificate and tls.crt are tried when empty
+	Field string
+	// Namespace is the Vault Enterprise namespace
+	Namespace string
+	// Auth is VaultAuthToken, VaultAuthAppRole or VaultAuthKubernetes
+	Auth      string
+	AuthMount string
+	Token     string
+	RoleID    string
+	SecretID  string
+	// Role is the role of the kubernetes auth method
+	Role   string
+	CAFile string
+
+	mu          sync.Mutex
+	client      *http.Client
+	token       string
+	tokenExpiry time.Time
+}
+
+// vaultResponse is the part of a Vault response the certificate is read from
+type vaultResponse struct {
+	Data map[string]interface{} `json:"data"`
+	Auth *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// Validate checks the settings of the auth method
+func (s *VaultSource) Validate() error {
+	if s.Address == "" || s.Path == "" {
+		return fmt.Errorf("vault address and path are required")
+	}
+	switch s.Auth {
+	case "", VaultAuthToken:
+		if s.Token == "" {
+			return fmt.Errorf("vault token auth needs a token")
+		}
+	case VaultAuthAppRole:
+		if s.RoleID == "" || s.SecretID == "" {
+			return fmt.Errorf("vault approle auth needs a role id and a secret id")
+		}
+	case VaultAuthKubernetes:
+		if s.Role == "" {
+			return fmt.Errorf("vault kubernetes auth needs a role")
+		}
+	default:
+		return fmt.Errorf("vault auth must be %s, %s or %s, got %s", VaultAuthToken, VaultAuthAppRole, VaultAuthKubernetes, s.Auth)
+	}
+
+	return nil
+}
+
+func (s *VaultSource) Certificate(ctx context.Context) (cmapi.Certificate, error) {
+	cert := cmapi.Certificate{ObjectMeta: metav1.ObjectMeta{Name: path.Base(s.Path)}}
+	certs, err := s.Chain(ctx, cert)
+	if err != nil {
+		return cmapi.Certificate{}, err
+	}
+	notAfter := metav1.NewTime(certs[0].NotAfter)
+	cert.Status.NotAfter = &notAfter
+
+	return cert, nil
+}
+
+// Chain returns the certificate followed by the CA chain of PKI responses
+func (s *VaultSource) Chain(ctx context.Context, _ cmapi.Certificate) ([]*x509.Certificate, error) {
+	resp, err := s.request(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(s.Path, "/"), nil, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// KV version 2 nests the secret in another data object
+	data := resp.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+
+	fields := []string{s.Field}
+	if s.Field == "" {
+		fields = []string{"certificate", corev1.TLSCertKey}
+	}
+	pemData := ""
+	for _, field := range fields {
+		if value, ok := data[field].(string); ok {
+			pemData = value
+			break
+		}
+	}
+	if pemData == "" {
+		return nil, terminal(fmt.Errorf("vault path %s has no certificate in %s", s.Path, strings.Join(fields, " or ")), "check VAULT_CERT_FIELD")
+	}
+
+	// PKI issue and sign responses carry the chain next to the certificate
+	if chain, ok := data["ca_chain"].([]interface{}); ok {
+		for _, ca := range chain {
+			if value, ok := ca.(string); ok {
+				pemData += "\n" + value
+			}
+		}
+	} else if issuer, ok := data["issuing_ca"].(string); ok {
+		pemData += "\n" + issuer
+	}
+
+	certs, err := parseCertificates([]byte(pemData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate of vault path %s: %w", s.Path, err)
+	}
+	if len(certs) == 0 {
+		return nil, terminal(fmt.Errorf("vault path %s has no PEM certificate", s.Path), "")
+	}
+
+	return certs, nil
+}
+
+// request calls the Vault API, logging in first when authenticated is set
+func (s *VaultSource) request(ctx context.Context, method, uri string, body interface{}, authenticated bool) (vaultResponse, error) {
+	client, err := s.httpClient()
+	if err != nil {
+		return vaultResponse{}, err
+	}
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return vaultResponse{}, fmt.Errorf("failed to encode vault request: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(s.Address, "/")+uri, &payload)
+	if err != nil {
+		return vaultResponse{}, fmt.Errorf("failed to create vault request: %w", err)
+	}
+	if s.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.Namespace)
+	}
+	if authenticated {
+		token, err := s.login(ctx)
+		if err != nil {
+			return vaultResponse{}, err
+		}
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	httpResp, err := client.Do(req)
+	if err != nil {
+		return vaultResponse{}, fmt.Errorf("failed to call vault: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	resp := vaultResponse{}
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil && httpResp.StatusCode < 300 {
+		return vaultResponse{}, fmt.Errorf("failed to parse vault response: %w", err)
+	}
+	switch {
+	case httpResp.StatusCode == http.StatusForbidden:
+		// the token may have been revoked, log in again on the next request
+		s.mu.Lock()
+		s.token = ""
+		s.mu.Unlock()
+		return vaultResponse{}, terminal(fmt.Errorf("vault denied %s: %s", uri, strings.Join(resp.Errors, ", ")), "check the vault policy")
+	case httpResp.StatusCode == http.StatusNotFound:
+		return vaultResponse{}, terminal(fmt.Errorf("vault path %s does not exist", uri), "check VAULT_CERT_PATH")
+	case httpResp.StatusCode == http.StatusTooManyRequests || httpResp.StatusCode >= 500:
+		return vaultResponse{}, retriable(fmt.Errorf("vault answered %s: %s", httpResp.Status, strings.Join(resp.Errors, ", ")))
+	case httpResp.StatusCode >= 300:
+		return vaultResponse{}, fmt.Errorf("vault answered %s: %s", httpResp.Status, strings.Join(resp.Errors, ", "))
+	}
+
+	return resp, nil
+}
+
+// login returns a token of the auth method, tokens of approle and kubernetes
+// logins are kept until shortly before their lease ends
+func (s *VaultSource) login(ctx context.Context) (string, error) {
+	if s.Auth == "" || s.Auth == VaultAuthToken {
+		return s.Token, nil
+	}
+
+	s.mu.Lock()
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		token := s.token
+		s.mu.Unlock()
+		return token, nil
+	}
+	s.mu.Unlock()
+
+	mount := s.AuthMount
+	if mount == "" {
+		mount = s.Auth
+	}
+	var body map[string]string
+	switch s.Auth {
+	case VaultAuthAppRole:
+		body = map[string]string{"role_id": s.RoleID, "secret_id": s.SecretID}
+	case VaultAuthKubernetes:
+		jwt, err := os.ReadFile(vaultServiceAccountToken)
+		if err != nil {
+			return "", fmt.Errorf("failed to read service account token: %w", err)
+		}
+		body = map[string]string{"role": s.Role, "jwt": strings.TrimSpace(string(jwt))}
+	}
+
+	resp, err := s.request(ctx, http.MethodPost, "/v1/auth/"+strings.Trim(mount, "/")+"/login", body, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to log in to vault: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault login returned no token")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = resp.Auth.ClientToken
+	// renew a minute before the lease ends
+	s.tokenExpiry = time.Now().Add(time.Duration(resp.Auth.LeaseDuration)*time.Second - time.Minute)
+
+	return s.token, nil
+}
+
+func (s *VaultSource) httpClient() (*http.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if s.CAFile != "" {
+		pool, err := loadCertPool(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	s.client = &http.Client{Timeout: 30 * time.Second, Transport: transport}
+
+	return s.client, nil
+}
@@ -0,0 +1,212 @@
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	cmapi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// watchWorkers is the number of targets Watch checks concurrently
+const watchWorkers = 4
+
+// Watch checks the targets whenever their Certificate or a TLS secret in the
+// certificate namespace changes and at least every interval. Every certificate
+// namespace of the targets is watched, following changes of the targets. Events are
+// coalesced per target in a rate limited workqueue, so a renewal touching both
+// the Certificate and its secret is checked once, failed checks are retried
+// with backoff. onReport is called with the report of every check. With a
+// JobTemplate every check is delegated to a job instead.
+func Watch(ctx context.Context, cfg Config, interval time.Duration, onReport func(Report)) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	cfg = cfg.withDefaults()
+	if cfg.RESTConfig == nil {
+		return fmt.Errorf("watching requires a rest config")
+	}
+	if cfg.RunDeadline == 0 {
+		cfg.RunDeadline = interval
+	}
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+
+	var mu sync.Mutex
+	targets := map[string]Config{}
+	var namespaces *namespaceWatches
+	enqueueAll := func() {
+		loaded, err := loadTargets(ctx, cfg)
+		if err != nil {
+			log.Printf("Failed to load targets: %v", err)
+			return
+		}
+		pruneFluentdReloads(ctx, cfg, loaded)
+
+		mu.Lock()
+		defer mu.Unlock()
+		targets = map[string]Config{}
+		watched := map[string]bool{}
+		for _, target := range loaded {
+			target.TargetsConfigMap = ""
+			targets[target.Name] = target
+			watched[target.CertNamespace] = true
+			queue.Add(target.Name)
+		}
+		namespaces.update(watched)
+	}
+	// events only carry the namespace, every target with a certificate there is checked
+	enqueueInNamespace := func(namespace string) {
+		mu.Lock()
+		defer mu.Unlock()
+		for name, target := range targets {
+			if target.CertNamespace == namespace {
+				queue.Add(name)
+			}
+		}
+	}
+	enqueueNamespace := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		meta, ok := obj.(interface{ GetNamespace() string })
+		if !ok {
+			return
+		}
+
+		enqueueInNamespace(meta.GetNamespace())
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueueNamespace,
+		UpdateFunc: func(_, obj interface{}) { enqueueNamespace(obj) },
+		DeleteFunc: enqueueNamespace,
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(cfg.RESTConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	version, err := certManagerVersion(cfg.Client)
+	if err != nil {
+		// e.g. secret sources without cert-manager, the certificate informer only logs its failures
+		log.Printf("Failed to discover the cert-manager version, watching %s certificates: %v", cmapi.SchemeGroupVersion, err)
+		version = cmapi.SchemeGroupVersion.Version
+	}
+	gvr := schema.GroupVersionResource{Group: cmapi.SchemeGroupVersion.Group, Version: version, Resource: "certificates"}
+	namespaces = newNamespaceWatches(ctx, func(ctx context.Context, namespace string) {
+		certificates := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, interval, namespace, nil)
+		certificates.ForResource(gvr).Informer().AddEventHandler(handler)
+		certificates.Start(ctx.Done())
+		// the secrets of cert-manager and FLUENTD_SECRET_NAME are in the certificate namespace
+		go watchSecrets(ctx, cfg.Client, namespace, func() { enqueueInNamespace(namespace) })
+	})
+
+	// the targets are reloaded every interval, picking up changes of the targets configmap
+	go wait.Until(enqueueAll, interval, ctx.Done())
+	go func() {
+		<-ctx.Done()
+		queue.ShutDown()
+	}()
+
+	processNext := func() bool {
+		key, shutdown := queue.Get()
+		if shutdown {
+			return false
+		}
+		defer queue.Done(key)
+
+		name := key.(string)
+		mu.Lock()
+		target, ok := targets[name]
+		mu.Unlock()
+		if !ok {
+			queue.Forget(key)
+			return true
+		}
+
+		check := Run
+		if target.JobTemplate != nil {
+			check = spawnJob
+		}
+		report, err := check(ctx, target)
+		for i := range report.Targets {
+			report.Targets[i].Target = name
+		}
+		onReport(report)
+
+		switch {
+		case err == nil:
+			queue.Forget(key)
+			if wait, ok := deferredFor(target, report); ok {
+				queue.AddAfter(key, wait)
+			}
+		case ErrorClass(err) == ErrorClassTerminal:
+			// retrying cannot help, the target is checked again on the next change or interval
+			log.Printf("Check of %s failed, not retrying: %v", name, err)
+			queue.Forget(key)
+		default:
+			log.Printf("Check of %s failed, retrying: %v", name, err)
+			queue.AddRateLimited(key)
+		}
+
+		return true
+	}
+
+	// the queue hands a target to one worker at a time, so a hanging target
+	// only blocks its own worker
+	var wg sync.WaitGroup
+	for i := 0; i < watchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for processNext() {
+			}
+		}()
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// namespaceWatches runs the watches of every namespace a target is in, a
+// namespace no target is in anymore is no longer watched
+type namespaceWatches struct {
+	ctx   context.Context
+	start func(ctx context.Context, namespace string)
+	stops map[string]context.CancelFunc
+}
+
+func newNamespaceWatches(ctx context.Context, start func(ctx context.Context, namespace string)) *namespaceWatches {
+	return &namespaceWatches{ctx: ctx, start: start, stops: map[string]context.CancelFunc{}}
+}
+
+// update starts watching the new namespaces and stops watching the ones
+// missing from namespaces
+func (w *namespaceWatches) update(namespaces map[string]bool) {
+	for namespace, stop := range w.stops {
+		if !namespaces[namespace] {
+			log.Printf("No target is in namespace %s anymore, no longer watching it", namespace)
+			stop()
+			delete(w.stops, namespace)
+		}
+	}
+	for namespace := range namespaces {
+		if _, ok := w.stops[namespace]; ok {
+			continue
+		}
+
+		log.Printf("Watching certificates and secrets in namespace %s", namespace)
+		ctx, stop := context.WithCancel(w.ctx)
+		w.stops[namespace] = stop
+		w.start(ctx, namespace)
+	}
+}
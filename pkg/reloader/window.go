@@ -0,0 +1,161 @@
+package reloader
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ReloadWindow restricts reloads to a daily time window, a stale certificate
+// found outside of it is reported as StatusReloadDeferred
+type ReloadWindow struct {
+	// days the window opens on, every day when empty
+	days map[time.Weekday]bool
+	// start and end are offsets from midnight, an end before the start spans midnight
+	start, end time.Duration
+	location   *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseReloadWindow parses a window like "02:00-04:00" or "Mon-Fri 22:00-02:00"
+// in the given IANA timezone, days are comma separated names or ranges
+func ParseReloadWindow(spec, timezone string) (*ReloadWindow, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reload window timezone %s: %w", timezone, err)
+	}
+	w := &ReloadWindow{days: map[time.Weekday]bool{}, location: location}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+	case 2:
+		if err := w.parseDays(fields[0]); err != nil {
+			return nil, err
+		}
+		fields = fields[1:]
+	default:
+		return nil, fmt.Errorf("reload window must look like [Mon-Fri ]HH:MM-HH:MM, got %q", spec)
+	}
+
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return nil, fmt.Errorf("reload window must look like [Mon-Fri ]HH:MM-HH:MM, got %q", spec)
+	}
+	if w.start, err = parseClock(times[0]); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseClock(times[1]); err != nil {
+		return nil, err
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("reload window %q is empty", spec)
+	}
+
+	return w, nil
+}
+
+func (w *ReloadWindow) parseDays(spec string) error {
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return fmt.Errorf("unknown weekday %s in reload window", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return fmt.Errorf("unknown weekday %s in reload window", to)
+			}
+		}
+
+		for day := first; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s in reload window, expected HH:MM", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// opensOn returns when the window opens on the day of t
+func (w *ReloadWindow) opensOn(t time.Time, days int) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+days, 0, 0, 0, 0, w.location).Add(w.start)
+}
+
+func (w *ReloadWindow) dayAllowed(t time.Time) bool {
+	return len(w.days) == 0 || w.days[t.Weekday()]
+}
+
+// Open reports whether reloads are allowed at t
+func (w *ReloadWindow) Open(t time.Time) bool {
+	t = t.In(w.location)
+	length := w.end - w.start
+	if length < 0 {
+		length += 24 * time.Hour
+	}
+
+	// the window of today or the one opened yesterday may contain t
+	for _, days := range []int{0, -1} {
+		opens := w.opensOn(t, days)
+		if w.dayAllowed(opens) && !t.Before(opens) && t.Before(opens.Add(length)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NextOpen returns t when the window is open, otherwise when it opens next
+func (w *ReloadWindow) NextOpen(t time.Time) time.Time {
+	if w.Open(t) {
+		return t
+	}
+
+	t = t.In(w.location)
+	for days := 0; days <= 7; days++ {
+		opens := w.opensOn(t, days)
+		if w.dayAllowed(opens) && opens.After(t) {
+			return opens
+		}
+	}
+
+	return t
+}
+
+// reloadsDeferred reports whether reloads are outside the reload window now
+func (c Config) reloadsDeferred() bool {
+	return c.ReloadWindow != nil && !c.ReloadWindow.Open(time.Now())
+}
+
+// deferredFor returns how long until the window opens when a target of the
+// report was deferred, so it is checked again as soon as reloads are allowed
+func deferredFor(cfg Config, report Report) (time.Duration, bool) {
+	if cfg.ReloadWindow == nil {
+		return 0, false
+	}
+	for _, s := range report.Targets {
+		if s.Status == StatusReloadDeferred {
+			now := time.Now()
+			return cfg.ReloadWindow.NextOpen(now).Sub(now), true
+		}
+	}
+
+	return 0, false
+}
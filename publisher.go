@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/donchev7/fluentd-reloader/pkg/reloader"
+	"github.com/nats-io/nats.go"
+)
+
+// reloadEvent is published for every target a reload was performed or failed on
+type reloadEvent struct {
+	Type    string                  `json:"type"`
+	Time    time.Time               `json:"time"`
+	Cluster string                  `json:"cluster,omitempty"`
+	Target  string                  `json:"target,omitempty"`
+	Status  string                  `json:"status"`
+	Error   string                  `json:"error,omitempty"`
+	Actions []reloader.ReloadAction `json:"actions"`
+}
+
+// natsTimeout bounds connecting to NATS and confirming the publishes
+const natsTimeout = 10 * time.Second
+
+// natsPublisher publishes reload events to a NATS subject, connecting for every
+// batch as reloads are rare
+type natsPublisher struct {
+	url      string
+	subject  string
+	user     string
+	password string
+	token    string
+	// credsFile is a .creds file with the user JWT and nkey seed
+	credsFile    string
+	nkeySeedFile string
+	caFile       string
+	// certFile and keyFile authenticate with a client certificate
+	certFile string
+	keyFile  string
+}
+
+// reloadEvents returns an event for every target of the report with reload actions
+func reloadEvents(r reloader.Report) []reloadEvent {
+	events := []reloadEvent{}
+	for _, s := range r.Targets {
+		eventType := ""
+		for _, action := range s.Actions {
+			switch action.Outcome {
+			case reloader.OutcomeFailed:
+				eventType = "reload-failed"
+			case reloader.OutcomeReloaded:
+				if eventType == "" {
+					eventType = "reloaded"
+				}
+			}
+		}
+		if eventType == "" {
+			continue
+		}
+
+		events = append(events, reloadEvent{
+			Type:    eventType,
+			Time:    r.GeneratedAt,
+			Cluster: s.Cluster,
+			Target:  s.Target,
+			Status:  s.Status,
+			Error:   s.Error,
+			Actions: s.Actions,
+		})
+	}
+
+	return events
+}
+
+func (p natsPublisher) publish(r reloader.Report) error {
+	events := reloadEvents(r)
+	if len(events) == 0 {
+		return nil
+	}
+
+	options, err := p.options()
+	if err != nil {
+		return err
+	}
+	nc, err := nats.Connect(p.url, options...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer nc.Close()
+
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode reload event: %w", err)
+		}
+		if err := nc.Publish(p.subject, payload); err != nil {
+			return fmt.Errorf("failed to publish reload events: %w", err)
+		}
+	}
+	// the flush waits for the server to process the publishes, a -ERR like a
+	// permissions violation is answered before the PONG and kept as last error
+	if err := nc.FlushTimeout(natsTimeout); err != nil {
+		return fmt.Errorf("failed to publish reload events: %w", err)
+	}
+	if err := nc.LastError(); err != nil {
+		return fmt.Errorf("failed to publish reload events: %w", err)
+	}
+
+	return nil
+}
+
+// options authenticate with the configured credentials, TLS is used for
+// tls:// URLs and when the server requires it
+func (p natsPublisher) options() ([]nats.Option, error) {
+	options := []nats.Option{
+		nats.Name("fluentd-reloader"),
+		nats.Timeout(natsTimeout),
+		// the events are published on a fresh connection for every batch
+		nats.NoReconnect(),
+	}
+	if p.user != "" {
+		options = append(options, nats.UserInfo(p.user, p.password))
+	}
+	if p.token != "" {
+		options = append(options, nats.Token(p.token))
+	}
+	if p.credsFile != "" {
+		options = append(options, nats.UserCredentials(p.credsFile))
+	}
+	if p.nkeySeedFile != "" {
+		option, err := nats.NkeyOptionFromSeed(p.nkeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read NATS nkey seed: %w", err)
+		}
+		options = append(options, option)
+	}
+	if p.caFile != "" {
+		options = append(options, nats.RootCAs(p.caFile))
+	}
+	if p.certFile != "" || p.keyFile != "" {
+		options = append(options, nats.ClientCert(p.certFile, p.keyFile))
+	}
+
+	return options, nil
+}
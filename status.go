@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/donchev7/fluentd-reloader/pkg/reloader"
+)
+
+// targetStatus is what the status endpoint reports about a target across runs
+type targetStatus struct {
+	Cluster          string    `json:"cluster,omitempty"`
+	Target           string    `json:"target"`
+	LastCheck        time.Time `json:"lastCheck"`
+	LastReload       time.Time `json:"lastReload"`
+	Status           string    `json:"status"`
+	ServedNotAfter   time.Time `json:"servedNotAfter"`
+	ExpectedNotAfter time.Time `json:"expectedNotAfter"`
+	Pods             int       `json:"pods"`
+	LastError        string    `json:"lastError,omitempty"`
+	LastErrorTime    time.Time `json:"lastErrorTime"`
+}
+
+// status keeps the state of every target for the status endpoint
+type status struct {
+	// paused reports whether reloads are paused
+	paused func() bool
+
+	mu      sync.Mutex
+	targets map[string]*targetStatus
+}
+
+func (st *status) update(r reloader.Report) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.targets == nil {
+		st.targets = map[string]*targetStatus{}
+	}
+	for _, s := range r.Targets {
+		key := s.Cluster + "/" + s.Target
+		t, ok := st.targets[key]
+		if !ok {
+			t = &targetStatus{Cluster: s.Cluster, Target: s.Target}
+			st.targets[key] = t
+		}
+
+		t.LastCheck = r.GeneratedAt
+		t.Status = s.Status
+		t.ServedNotAfter = s.ServedNotAfter
+		t.ExpectedNotAfter = s.ExpectedNotAfter
+		t.Pods = len(s.DiscoveredPods)
+		if s.Status == reloader.StatusReloaded {
+			t.LastReload = r.GeneratedAt
+		}
+		if s.Error != "" {
+			t.LastError = s.Error
+			t.LastErrorTime = r.GeneratedAt
+		}
+	}
+}
+
+func (st *status) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	st.mu.Lock()
+	targets := make([]targetStatus, 0, len(st.targets))
+	for _, t := range st.targets {
+		targets = append(targets, *t)
+	}
+	st.mu.Unlock()
+
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Cluster != targets[j].Cluster {
+			return targets[i].Cluster < targets[j].Cluster
+		}
+		return targets[i].Target < targets[j].Target
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	body := map[string]interface{}{"targets": targets}
+	if st.paused != nil {
+		body["paused"] = st.paused()
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}